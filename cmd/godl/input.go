@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+)
+
+// entry 一个下载项，来自命令行或输入文件
+type entry struct {
+	url      string
+	out      string // 保存的文件名，为空时使用url路径的最后一段
+	dir      string // 保存目录，为空时使用-d
+	checksum string // 算法=十六进制摘要，见task.Options.Checksum
+	header   http.Header
+}
+
+// parseInput 解析aria2格式的输入文件：每行一个url，其后以空白开头的行是该url的选项，
+// 如 "  out=a.bin"；支持out、dir、checksum和header（可重复）。空行和#开头的行忽略
+func parseInput(r io.Reader) ([]entry, error) {
+	var (
+		entries []entry
+		cur     *entry
+		line    int
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line++
+		text := sc.Text()
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if text[0] != ' ' && text[0] != '\t' {
+			if strings.ContainsAny(trimmed, " \t") {
+				return nil, fmt.Errorf("line %d: one url per line (mirrors are not supported)", line)
+			}
+			entries = append(entries, entry{url: trimmed})
+			cur = &entries[len(entries)-1]
+			continue
+		}
+
+		if cur == nil {
+			return nil, fmt.Errorf("line %d: option before any url", line)
+		}
+		kv := strings.SplitN(trimmed, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: want key=value, got %q", line, trimmed)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "out":
+			cur.out = value
+		case "dir":
+			cur.dir = value
+		case "checksum":
+			cur.checksum = value
+		case "header":
+			name, v, err := httpclient.ParseHeader(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			if cur.header == nil {
+				cur.header = http.Header{}
+			}
+			cur.header.Add(name, v)
+		default:
+			return nil, fmt.Errorf("line %d: unknown option %q", line, key)
+		}
+	}
+	return entries, sc.Err()
+}
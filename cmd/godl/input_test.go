@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/azd1997/blockchair_downloader/pool"
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+func TestParseInput(t *testing.T) {
+	entries, err := parseInput(strings.NewReader(`# 注释
+https://h/a.bin
+  out=x.bin
+	dir=/tmp/d
+  checksum=sha-256=00
+
+https://h/b.bin
+  header=X-A: 1
+  header=X-B: 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("entries = %+v", entries)
+	}
+	a, b := entries[0], entries[1]
+	if a.url != "https://h/a.bin" || a.out != "x.bin" || a.dir != "/tmp/d" || a.checksum != "sha-256=00" || a.header != nil {
+		t.Errorf("a = %+v", a)
+	}
+	if b.url != "https://h/b.bin" || b.header.Get("X-A") != "1" || b.header.Get("X-B") != "2" {
+		t.Errorf("b = %+v", b)
+	}
+
+	for _, bad := range []string{
+		"  out=x\nhttps://h/a",
+		"https://h/a\n  out",
+		"https://h/a\n  foo=1",
+		"https://h/a\n  header=bad",
+		"https://h/a\thttps://mirror/a",
+	} {
+		if _, err := parseInput(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("timeout"), ExitFailed},
+		{&task.StatusError{Code: 404}, ExitNotFound},
+		{fmt.Errorf("wrapped: %w", &task.StatusError{Code: 410}), ExitNotFound},
+		{&task.StatusError{Code: 403}, ExitAuth},
+		{fmt.Errorf("chunk 0-99: %v: %w", pool.ErrTooManyTries, &task.StatusError{Method: "GET", Code: 401}), ExitAuth},
+		{&task.StatusError{Code: 500}, ExitFailed},
+		{&task.ChecksumError{}, ExitChecksum},
+	}
+	for _, c := range cases {
+		if got := exitCode(c.err); got != c.want {
+			t.Errorf("exitCode(%v) = %d, want %d", c.err, got, c.want)
+		}
+	}
+}
+
+func TestLoadEntries_glob(t *testing.T) {
+	*globFlag, *outFlag = true, "#1.bin"
+	defer func() { *globFlag, *outFlag = false, "" }()
+	entries, err := loadEntries([]string{"https://h/{a,b}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []entry{{url: "https://h/a", out: "a.bin"}, {url: "https://h/b", out: "b.bin"}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("entries = %+v", entries)
+	}
+
+	*globFlag = false
+	if _, err := loadEntries([]string{"https://h/a", "https://h/b"}); err == nil {
+		t.Fatal("-o with several urls should fail")
+	}
+}
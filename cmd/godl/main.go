@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/azd1997/blockchair_downloader/config"
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/logging"
+	"github.com/azd1997/blockchair_downloader/metrics"
+	"github.com/azd1997/blockchair_downloader/pool"
+	"github.com/azd1997/blockchair_downloader/progress"
+	"github.com/azd1997/blockchair_downloader/task"
+	"github.com/azd1997/blockchair_downloader/urlgen"
+)
+
+// 命令行格式：
+// godl [-config FILE] [-profile NAME] [-i FILE] [-d DIR] [-o NAME] [-n 16] [-j 5] [-k 1M] [-retries 10] [-limit 2M] [-checksum sha-256=HEX] [-restart] [-overwrite] [-g] [-state FILE] [-metrics :9100] [-q] [URL...]
+
+// 退出码，有多个任务失败时取第一个失败的任务（按提交顺序）
+const (
+	ExitOK       = 0 // 全部下载成功
+	ExitFailed   = 1 // 网络、服务器或本地文件错误
+	ExitUsage    = 2 // 参数或输入文件有误
+	ExitNotFound = 3 // 服务器返回404/410
+	ExitChecksum = 4 // 校验和不符
+	ExitAuth     = 5 // 服务器返回401/403
+)
+
+var (
+	inputFlag     = flag.String("i", "", "输入文件，每行一个url，其后缩进的行为该url的选项（aria2格式，支持out、dir、checksum、header），-表示标准输入")
+	dirFlag       = flag.String("d", ".", "保存目录")
+	outFlag       = flag.String("o", "", "保存的文件名，只能用于单个url；与-g一起使用时#1、#2...为各占位符的取值")
+	nDownloader   = flag.Int("n", 16, "指定使用最多n个下载器同时工作")
+	nTaskFlag     = flag.Int("j", 5, "指定最多同时下载j个文件")
+	chunkFlag     = flag.String("k", "", "分块大小，如 512K、4M，默认为4K")
+	retriesFlag   = flag.Int("retries", pool.MaxTries, "单个分块最多尝试次数")
+	limitFlag     = flag.String("limit", "", "所有下载合计的速度上限（字节/秒），如 500K、2M，默认不限速")
+	checksumFlag  = flag.String("checksum", "", "下载完成后校验，如 sha-256=HEX，支持md5、sha-1、sha-224、sha-256、sha-384、sha-512；只能用于单个url")
+	restartFlag   = flag.Bool("restart", false, "丢弃已有的续传数据，从头下载；默认从.DOWNLOADING续传")
+	overwriteFlag = flag.Bool("overwrite", false, "覆盖已存在的同名文件，默认在文件名后追加时间戳")
+	globFlag      = flag.Bool("g", false, "将url作为模板展开，如 https://h/[1-10].gz、https://h/<20210101..20210131>.gz，语法见urlgen")
+	stateFlag     = flag.String("state", "", "任务队列状态数据库路径，设置后进程重启时会续传未完成的任务")
+	quietFlag     = flag.Bool("q", false, "安静模式，不显示进度和日志，只输出错误")
+	metricsFlag   = flag.String("metrics", "", "在该地址的/metrics提供Prometheus指标，如 :9100")
+	clientOpts    httpclient.Options
+	authFlags     httpclient.AuthFlags
+	logFlags      logging.Flags
+	configLoader  config.Loader
+
+	// configFlags 配置项对应的参数，见config
+	configFlags = config.Flags{
+		"downloaders": "n",
+		"tasks":       "j",
+		"dir":         "d",
+		"chunk_size":  "k",
+		"retries":     "retries",
+		"rate_limit":  "limit",
+	}
+)
+
+func init() {
+	clientOpts.RegisterFlags(flag.CommandLine)
+	authFlags.RegisterFlags(flag.CommandLine)
+	logFlags.RegisterFlags(flag.CommandLine)
+	configLoader.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "用法：godl [flags] URL...\n      godl [flags] -i FILE")
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	os.Exit(run())
+}
+
+// run 下载并返回退出码，参数错误时直接退出
+func run() int {
+	settings, err := configLoader.Load(flag.CommandLine, configFlags)
+	if err != nil {
+		usage(err)
+	}
+	entries, err := loadEntries(flag.Args())
+	if err != nil {
+		usage(err)
+	}
+	if len(entries) == 0 {
+		flag.Usage()
+		return ExitUsage
+	}
+
+	var chunkSize, limit int64
+	if *chunkFlag != "" {
+		if chunkSize, err = config.ParseBytes(*chunkFlag); err != nil || chunkSize <= 0 {
+			usage(fmt.Errorf("-k: invalid chunk size %q", *chunkFlag))
+		}
+	}
+	if *limitFlag != "" {
+		if limit, err = config.ParseBytes(*limitFlag); err != nil {
+			usage(fmt.Errorf("-limit: %v", err))
+		}
+	}
+	auth, err := authFlags.Auth()
+	if err != nil {
+		usage(err)
+	}
+	if auth, err = settings.ApplyHosts(&clientOpts, auth); err != nil {
+		usage(err)
+	}
+
+	var renderer *progress.Renderer
+	if *quietFlag {
+		log.SetOutput(ioutil.Discard)
+	} else {
+		renderer = progress.New(os.Stdout)
+		log.SetOutput(renderer)
+	}
+	logger, err := logFlags.Logger(log.Writer())
+	if err != nil {
+		usage(err)
+	}
+	pool.SetLogger(logger)
+	task.SetLogger(logger)
+
+	if err = pool.InitWithClient(*nDownloader, clientOpts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailed
+	}
+	pool.SetRateLimit(limit)
+	pool.Start()
+	defer pool.Stop()
+	if *metricsFlag != "" {
+		go func() {
+			fmt.Fprintln(os.Stderr, "-metrics:", metrics.ListenAndServe(*metricsFlag))
+		}()
+	}
+
+	var manager *task.Manager
+	if *stateFlag != "" {
+		manager, err = task.NewPersistentManager(*nTaskFlag, *stateFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitFailed
+		}
+		defer manager.Close()
+	} else {
+		manager = task.NewManager(*nTaskFlag)
+	}
+	manager.SetAuth(auth)
+	if renderer != nil {
+		manager.OnEvent(func(e task.Event) {
+			if e.Type == task.EventStarted {
+				renderer.Add(path.Base(e.Task.FileName), e.Task)
+			}
+		})
+		renderer.Start()
+	}
+
+	for _, e := range entries {
+		// 已在队列中（从状态数据库恢复的未失败任务）
+		if job, ok := manager.Lookup(e.url); ok && job.State != task.JobFailed {
+			continue
+		}
+		opts := task.Options{
+			Dir:       e.dir,
+			FileName:  e.out,
+			Overwrite: *overwriteFlag,
+			Restart:   *restartFlag,
+			ChunkSize: chunkSize,
+			MaxTries:  *retriesFlag,
+			Checksum:  e.checksum,
+		}
+		if opts.Dir == "" {
+			opts.Dir = *dirFlag
+		}
+		if len(e.header) > 0 {
+			opts.Auth = auth.WithHeader(e.header)
+		}
+		manager.Add(e.url, opts)
+	}
+
+	result := manager.Wait()
+	if renderer != nil {
+		renderer.Stop()
+	}
+	code := ExitOK
+	for _, job := range result.Jobs {
+		if job.State == task.JobFailed {
+			fmt.Fprintf(os.Stderr, "下载失败：%s: %v\n", httpclient.Redact(job.Url), job.Err)
+			if code == ExitOK {
+				code = exitCode(job.Err)
+			}
+		}
+	}
+	if code == ExitOK && renderer != nil {
+		fmt.Printf("下载完成：共%d个文件\n", result.Completed)
+	}
+	return code
+}
+
+// loadEntries 命令行中的url（-g时按模板展开）和-i输入文件中的下载项
+func loadEntries(args []string) ([]entry, error) {
+	var entries []entry
+	for _, arg := range args {
+		if !*globFlag {
+			entries = append(entries, entry{url: arg, out: *outFlag})
+			continue
+		}
+		t, err := urlgen.Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, it := range t.Items() {
+			e := entry{url: it.Url}
+			if *outFlag != "" {
+				e.out = it.Format(*outFlag)
+			}
+			entries = append(entries, e)
+		}
+	}
+	if *outFlag != "" && len(entries) > 1 && !*globFlag {
+		return nil, errors.New("-o can only be used with a single url")
+	}
+	if *checksumFlag != "" {
+		if len(entries) != 1 {
+			return nil, errors.New("-checksum can only be used with a single url")
+		}
+		entries[0].checksum = *checksumFlag
+	}
+
+	if *inputFlag != "" {
+		var r io.Reader = os.Stdin
+		if *inputFlag != "-" {
+			f, err := os.Open(*inputFlag)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		list, err := parseInput(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", *inputFlag, err)
+		}
+		entries = append(entries, list...)
+	}
+	return entries, nil
+}
+
+// exitCode 按失败原因确定退出码
+func exitCode(err error) int {
+	var (
+		se *task.StatusError
+		ce *task.ChecksumError
+	)
+	switch {
+	case errors.As(err, &ce):
+		return ExitChecksum
+	case errors.As(err, &se) && (se.Code == http.StatusNotFound || se.Code == http.StatusGone):
+		return ExitNotFound
+	case errors.As(err, &se) && (se.Code == http.StatusUnauthorized || se.Code == http.StatusForbidden):
+		return ExitAuth
+	}
+	return ExitFailed
+}
+
+// usage 参数有误，输出错误并退出
+func usage(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(ExitUsage)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/config"
+	"github.com/azd1997/blockchair_downloader/daemon"
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/logging"
+	"github.com/azd1997/blockchair_downloader/pool"
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+// 命令行格式：
+// godld [-config FILE] [-profile NAME] [-listen 127.0.0.1:6800] [-secret TOKEN] [-allow-origin URL,...] [-ui=false] [-d DIR] [-n 16] [-schedule 00:00-08:00=50] [-j 5] [-k 1M] [-retries 10] [-limit 2M] [-state FILE] [-q]
+
+var (
+	listenFlag   = flag.String("listen", "127.0.0.1:6800", "控制接口的监听地址，JSON-RPC为/jsonrpc，REST为/api/")
+	secretFlag   = flag.String("secret", "", "访问令牌，与aria2的--rpc-secret相同；也可以用环境变量GODL_SECRET设置")
+	originFlag   = flag.String("allow-origin", "", "允许跨站访问控制接口的来源，如 http://ariang.example.com，多个用逗号分隔，*表示任意来源")
+	uiFlag       = flag.Bool("ui", true, "在监听地址的/提供网页界面")
+	dirFlag      = flag.String("d", ".", "默认保存目录")
+	nDownloader  = flag.Int("n", 16, "指定使用最多n个下载器同时工作")
+	scheduleFlag = flag.String("schedule", "", "按时段调整下载器数，如 00:00-08:00=50,08:00-18:00=5，其余时段使用-n")
+	nTaskFlag    = flag.Int("j", 5, "指定最多同时下载j个文件")
+	chunkFlag    = flag.String("k", "", "默认分块大小，如 512K、4M，默认为4K")
+	retriesFlag  = flag.Int("retries", pool.MaxTries, "单个分块最多尝试次数")
+	limitFlag    = flag.String("limit", "", "所有下载合计的速度上限（字节/秒），如 500K、2M，默认不限速")
+	stateFlag    = flag.String("state", "", "任务队列状态数据库路径，设置后进程重启时会恢复任务")
+	quietFlag    = flag.Bool("q", false, "安静模式，不输出任务日志")
+	clientOpts   httpclient.Options
+	authFlags    httpclient.AuthFlags
+	logFlags     logging.Flags
+	configLoader config.Loader
+
+	// configFlags 配置项对应的参数，见config
+	configFlags = config.Flags{
+		"downloaders": "n",
+		"tasks":       "j",
+		"dir":         "d",
+		"chunk_size":  "k",
+		"retries":     "retries",
+		"rate_limit":  "limit",
+		"schedule":    "schedule",
+	}
+)
+
+func init() {
+	clientOpts.RegisterFlags(flag.CommandLine)
+	authFlags.RegisterFlags(flag.CommandLine)
+	logFlags.RegisterFlags(flag.CommandLine)
+	configLoader.RegisterFlags(flag.CommandLine)
+}
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func run() error {
+	settings, err := configLoader.Load(flag.CommandLine, configFlags)
+	if err != nil {
+		return err
+	}
+	var chunkSize, limit int64
+	if *chunkFlag != "" {
+		if chunkSize, err = config.ParseBytes(*chunkFlag); err != nil || chunkSize <= 0 {
+			return fmt.Errorf("-k: invalid chunk size %q", *chunkFlag)
+		}
+	}
+	if *limitFlag != "" {
+		if limit, err = config.ParseBytes(*limitFlag); err != nil {
+			return fmt.Errorf("-limit: %v", err)
+		}
+	}
+	schedule, err := pool.ParseSchedule(*scheduleFlag)
+	if err != nil {
+		return err
+	}
+	auth, err := authFlags.Auth()
+	if err != nil {
+		return err
+	}
+	if auth, err = settings.ApplyHosts(&clientOpts, auth); err != nil {
+		return err
+	}
+	secret := *secretFlag
+	if secret == "" {
+		secret = os.Getenv(config.EnvPrefix + "SECRET")
+	}
+	if *quietFlag {
+		log.SetOutput(ioutil.Discard)
+	}
+	logger, err := logFlags.Logger(log.Writer())
+	if err != nil {
+		return err
+	}
+	pool.SetLogger(logger)
+	task.SetLogger(logger)
+
+	if err = pool.InitWithClient(*nDownloader, clientOpts); err != nil {
+		return err
+	}
+	pool.SetRateLimit(limit)
+	pool.Start()
+	defer pool.Stop()
+	if len(schedule) > 0 {
+		stopSchedule := make(chan struct{})
+		defer close(stopSchedule)
+		go pool.RunSchedule(schedule, *nDownloader, stopSchedule)
+	}
+
+	var manager *task.Manager
+	if *stateFlag != "" {
+		if manager, err = task.NewPersistentManager(*nTaskFlag, *stateFlag); err != nil {
+			return err
+		}
+		defer manager.Close()
+	} else {
+		manager = task.NewManager(*nTaskFlag)
+	}
+	manager.SetAuth(auth)
+	manager.OnEvent(logEvent)
+
+	// 收到SIGINT/SIGTERM或aria2.shutdown时退出，下载中的任务在下次启动时从.DOWNLOADING续传
+	stop := make(chan struct{}, 1)
+	shutdown := func() {
+		select {
+		case stop <- struct{}{}:
+		default:
+		}
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		shutdown()
+	}()
+
+	srv := &http.Server{
+		Addr: *listenFlag,
+		Handler: daemon.New(manager, daemon.Options{
+			Secret:  secret,
+			Origins: origins(*originFlag),
+			UI:      *uiFlag,
+			Defaults: task.Options{
+				Dir:       *dirFlag,
+				ChunkSize: chunkSize,
+				MaxTries:  *retriesFlag,
+			},
+			Auth:     auth,
+			Shutdown: shutdown,
+		}),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	log.Printf("godld listening on %s\n", *listenFlag)
+
+	select {
+	case err = <-errc:
+		return err
+	case <-stop:
+	}
+	log.Println("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = srv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// logEvent 输出任务开始、结束等日志
+func logEvent(e task.Event) {
+	url := httpclient.Redact(e.Url)
+	switch e.Type {
+	case task.EventQueued, task.EventStarted, task.EventPaused, task.EventResumed, task.EventRemoved:
+		log.Printf("[%d] %s: %s\n", e.JobID, e.Type, url)
+	case task.EventCompleted:
+		log.Printf("[%d] completed: %s -> %s\n", e.JobID, url, e.Task.FileName)
+	case task.EventFailed:
+		log.Printf("[%d] failed: %s: %v\n", e.JobID, url, e.Err)
+	}
+}
+
+// origins 解析-allow-origin
+func origins(s string) []string {
+	var list []string
+	for _, o := range strings.Split(s, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			list = append(list, o)
+		}
+	}
+	return list
+}
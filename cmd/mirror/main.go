@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/azd1997/blockchair_downloader/config"
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/logging"
+	"github.com/azd1997/blockchair_downloader/metrics"
+	"github.com/azd1997/blockchair_downloader/mirror"
+	"github.com/azd1997/blockchair_downloader/pool"
+	"github.com/azd1997/blockchair_downloader/progress"
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+// 命令行格式：
+// mirror [-config FILE] [-profile NAME] [-out ./download] [-l 5] [-A '*.gz'] [-R 'tmp,*.html'] [-accept-regex RE] [-reject-regex RE] [-N=true] [-n 20] [-j 3] [-metrics :9100] [-q] URL...
+
+var (
+	nDownloaderFlag = flag.Int("n", 20, "指定使用最多n个下载器同时工作")
+	nTaskFlag       = flag.Int("j", 3, "指定最多同时下载j个文件")
+	outFlag         = flag.String("out", task.DownloadDir, "保存目录，文件按相对URL的路径保存")
+	depthFlag       = flag.Int("l", 5, "最大递归深度，0只下载URL目录下的文件，-1不限制")
+	acceptFlag      = flag.String("A", "", "只下载匹配的文件，逗号分隔的glob，如 *.gz,*.zip；含/的匹配相对路径")
+	rejectFlag      = flag.String("R", "", "跳过匹配的文件和目录，逗号分隔的glob")
+	acceptReFlag    = flag.String("accept-regex", "", "只下载相对路径匹配该正则的文件")
+	rejectReFlag    = flag.String("reject-regex", "", "跳过相对路径匹配该正则的文件和目录")
+	timestampFlag   = flag.Bool("N", true, "本地文件大小相同且不比远程旧时跳过")
+	stateFlag       = flag.String("state", "", "任务队列状态数据库路径，设置后进程重启时会续传未完成的任务")
+	quietFlag       = flag.Bool("q", false, "安静模式，不显示进度和日志，只输出错误")
+	metricsFlag     = flag.String("metrics", "", "在该地址的/metrics提供Prometheus指标，如 :9100")
+	clientOpts      httpclient.Options
+	authFlags       httpclient.AuthFlags
+	logFlags        logging.Flags
+	configLoader    config.Loader
+	configFlags     = config.Flags{"downloaders": "n", "tasks": "j", "dir": "out"}
+)
+
+func init() {
+	clientOpts.RegisterFlags(flag.CommandLine)
+	authFlags.RegisterFlags(flag.CommandLine)
+	logFlags.RegisterFlags(flag.CommandLine)
+	configLoader.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "用法：mirror [flags] URL...")
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	settings, err := configLoader.Load(flag.CommandLine, configFlags)
+	if err != nil {
+		fatal(err)
+	}
+	opts, err := walkOptions()
+	if err != nil {
+		fatal(err)
+	}
+	auth, err := authFlags.Auth()
+	if err != nil {
+		fatal(err)
+	}
+	if auth, err = settings.ApplyHosts(&clientOpts, auth); err != nil {
+		fatal(err)
+	}
+
+	var renderer *progress.Renderer
+	if *quietFlag {
+		log.SetOutput(ioutil.Discard)
+	} else {
+		renderer = progress.New(os.Stdout)
+		log.SetOutput(renderer)
+	}
+	logger, err := logFlags.Logger(log.Writer())
+	if err != nil {
+		fatal(err)
+	}
+	pool.SetLogger(logger)
+	task.SetLogger(logger)
+
+	if err = pool.InitWithClient(*nDownloaderFlag, clientOpts); err != nil {
+		fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+	if *metricsFlag != "" {
+		go func() {
+			fmt.Fprintln(os.Stderr, "-metrics:", metrics.ListenAndServe(*metricsFlag))
+		}()
+	}
+
+	var manager *task.Manager
+	if *stateFlag != "" {
+		manager, err = task.NewPersistentManager(*nTaskFlag, *stateFlag)
+		if err != nil {
+			fatal(err)
+		}
+		defer manager.Close()
+	} else {
+		manager = task.NewManager(*nTaskFlag)
+	}
+	manager.SetAuth(auth)
+
+	// 下载完成后修改时间设为远程的，供-N判断
+	var (
+		mu    sync.Mutex
+		files = make(map[string]mirror.File)
+	)
+	manager.OnEvent(func(e task.Event) {
+		switch e.Type {
+		case task.EventStarted:
+			if renderer != nil {
+				renderer.Add(path.Base(e.Url), e.Task)
+			}
+		case task.EventCompleted:
+			mu.Lock()
+			f, ok := files[e.Url]
+			mu.Unlock()
+			if ok {
+				mirror.Touch(e.Task.FileName, f)
+			}
+		}
+	})
+	if renderer != nil {
+		renderer.Start()
+	}
+
+	// 边遍历边提交，索引页读取失败的目录记下来，最后按失败处理
+	var walkErrs, skipped int
+	for _, base := range flag.Args() {
+		err = mirror.Walk(pool.HTTPClient(), auth, base, opts, func(f mirror.File, err error) error {
+			if errors.Is(err, mirror.ErrUnsafePath) {
+				walkErrs++
+				fmt.Fprintf(os.Stderr, "路径超出保存目录，跳过：%s\n", httpclient.Redact(f.Url))
+				return nil
+			}
+			if err != nil {
+				walkErrs++
+				fmt.Fprintf(os.Stderr, "读取目录失败：%v\n", err)
+				return nil
+			}
+			if f.Dir {
+				return nil
+			}
+			local, err := f.LocalPath(*outFlag)
+			if err != nil {
+				walkErrs++
+				fmt.Fprintf(os.Stderr, "路径超出保存目录，跳过：%s\n", httpclient.Redact(f.Url))
+				return nil
+			}
+			if *timestampFlag && mirror.UpToDate(local, f) {
+				skipped++
+				log.Printf("未变化，跳过：%s\n", local)
+				return nil
+			}
+			mu.Lock()
+			files[f.Url] = f
+			mu.Unlock()
+			// 已在队列中（从状态数据库恢复的未失败任务）
+			if job, ok := manager.Lookup(f.Url); ok && job.State != task.JobFailed {
+				return nil
+			}
+			manager.Add(f.Url, task.Options{
+				Dir:       filepath.Dir(local),
+				FileName:  filepath.Base(local),
+				Overwrite: true,
+			})
+			return nil
+		})
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	result := manager.Wait()
+	if renderer != nil {
+		renderer.Stop()
+	}
+	for _, job := range result.Jobs {
+		if job.State == task.JobFailed {
+			fmt.Fprintf(os.Stderr, "下载失败：%s: %v\n", httpclient.Redact(job.Url), job.Err)
+		}
+	}
+	if result.Failed > 0 || walkErrs > 0 {
+		os.Exit(1)
+	}
+	if renderer != nil {
+		fmt.Printf("镜像完成：下载%d个文件，%d个未变化\n", result.Completed, skipped)
+	}
+}
+
+// walkOptions 由命令行参数生成遍历选项
+func walkOptions() (opts mirror.Options, err error) {
+	opts.Depth = *depthFlag
+	opts.Accept = splitList(*acceptFlag)
+	opts.Reject = splitList(*rejectFlag)
+	if *acceptReFlag != "" {
+		if opts.AcceptRegex, err = regexp.Compile(*acceptReFlag); err != nil {
+			return
+		}
+	}
+	if *rejectReFlag != "" {
+		opts.RejectRegex, err = regexp.Compile(*rejectReFlag)
+	}
+	return
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// fatal 输出错误并退出，安静模式下也会输出到stderr
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
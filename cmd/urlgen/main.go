@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/azd1997/blockchair_downloader/urlgen"
+)
+
+// 命令行格式：
+// urlgen [-c] [-o NAME] TEMPLATE...
+//
+// 每行输出一个url；指定-o时每个url后跟一行缩进的 out=文件名（aria2的输入文件格式），
+// 可以直接作为godl -i的输入。
+
+var (
+	countFlag = flag.Bool("c", false, "只输出生成的url数")
+	outFlag   = flag.String("o", "", "文件名模板，#1、#2...为第1、2...个占位符的取值，如 #1_#2.gz")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), `用法：urlgen [flags] TEMPLATE...
+
+模板语法：
+  {a,b,c}                      列表
+  [1-100] [001-100] [0-100:5]  数字范围，起始值有前导0时补齐宽度，:后为步长
+  [a-z]                        字母范围
+  <20210101..20210131>         日期范围，起止时间可写为2021-01-01T15、2021-01-01、20210101、2021-01、202101、2021
+  <2021-01..2021-12,1mo|200601>  ,后为步长(h、d、w、mo、y)，|后为Go的时间格式
+  \{ \[ \<                     原样输出
+
+参数：`)
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var tmpls []*urlgen.Template
+	total := 0
+	for _, arg := range flag.Args() {
+		t, err := urlgen.Parse(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		tmpls = append(tmpls, t)
+		total += t.Count()
+	}
+	if *countFlag {
+		fmt.Println(total)
+		return
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, t := range tmpls {
+		t.Each(func(it urlgen.Item) error {
+			fmt.Fprintln(w, it.Url)
+			if *outFlag != "" {
+				fmt.Fprintf(w, "  out=%s\n", it.Format(*outFlag))
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,424 @@
+// Package config 命令行工具(godl、mirror、blockchair)共用的YAML配置文件
+//
+// 配置文件中可以设置全局默认值、按主机的设置(hosts)和命名的profile(profiles)，例如：
+//
+//	downloaders: 20
+//	tasks: 3
+//	chunk_size: 1M
+//	headers:
+//	  User-Agent: godl/1.0
+//	hosts:
+//	  gz.blockchair.com:
+//	    downloaders: 10
+//	    rate_limit: 2M
+//	  files.example.com:
+//	    proxy: socks5://127.0.0.1:1080
+//	    headers:
+//	      X-Api-Key: secret
+//	profiles:
+//	  night:
+//	    downloaders: 50
+//	    rate_limit: 0
+//
+// 全局设置按以下顺序合并，后面的覆盖前面的：
+//
+//	内置默认值 < 配置文件 < -profile(或GODL_PROFILE)指定的profile < 环境变量GODL_<KEY> < 命令行参数
+//
+// 请求头按名字合并；命令行的-H会代替配置中的全局请求头。
+// hosts中的设置只作用于该主机（主机名不含端口），代理和请求头覆盖全局设置，
+// 下载器数和限速是在全局上限之内对该主机的额外限制；profile中的hosts按主机合并到配置文件的hosts。
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/pool"
+	"gopkg.in/yaml.v2"
+)
+
+// 环境变量
+const (
+	PathEnv    = "GODL_CONFIG"  // 配置文件路径
+	ProfileEnv = "GODL_PROFILE" // 使用的profile
+	EnvPrefix  = "GODL_"        // 配置项的环境变量前缀，如GODL_DOWNLOADERS、GODL_RATE_LIMIT
+)
+
+// Settings 一层设置，零值（空串、0、nil）表示没有设置
+// 字节数写作1048576、512K、1.5M，时长写作30s、1m，见ParseBytes和time.ParseDuration
+type Settings struct {
+	Downloaders    int               `yaml:"downloaders"`     // 下载器数
+	Tasks          int               `yaml:"tasks"`           // 同时下载的文件数
+	Dir            string            `yaml:"dir"`             // 保存目录
+	ChunkSize      string            `yaml:"chunk_size"`      // 分块大小
+	Retries        int               `yaml:"retries"`         // 单个分块最多尝试次数
+	RateLimit      string            `yaml:"rate_limit"`      // 合计限速，字节/秒，0为不限速
+	Proxy          string            `yaml:"proxy"`           // 代理，见httpclient.Options.Proxy
+	ConnectTimeout string            `yaml:"connect_timeout"` // 建立连接的超时时间
+	HeaderTimeout  string            `yaml:"header_timeout"`  // 等待响应头的超时时间
+	CAFile         string            `yaml:"ca_file"`         // 额外信任的CA证书
+	Insecure       *bool             `yaml:"insecure"`        // 不校验服务端证书
+	Key            string            `yaml:"key"`             // blockchair的API key
+	Schedule       string            `yaml:"schedule"`        // 按时段调整下载器数，见pool.ParseSchedule
+	Headers        map[string]string `yaml:"headers"`         // 每个请求都带上的请求头
+
+	Hosts map[string]Host `yaml:"hosts"` // 按主机名的设置
+}
+
+// Host 单个主机的设置
+type Host struct {
+	Downloaders int               `yaml:"downloaders"` // 同时下载该主机的下载器数上限
+	RateLimit   string            `yaml:"rate_limit"`  // 该主机合计限速，字节/秒
+	Proxy       string            `yaml:"proxy"`       // 代理，可以为direct
+	Headers     map[string]string `yaml:"headers"`     // 覆盖全局的同名请求头
+}
+
+// Config 配置文件，顶层为全局设置
+type Config struct {
+	Settings `yaml:",inline"`
+	Profiles map[string]Settings `yaml:"profiles"`
+}
+
+// keys 全局配置项，也是环境变量GODL_<KEY>和Flags的键
+var keys = []string{
+	"downloaders", "tasks", "dir", "chunk_size", "retries", "rate_limit",
+	"proxy", "connect_timeout", "header_timeout", "ca_file", "insecure", "key", "schedule", "headers",
+}
+
+// DefaultPath 默认的配置文件路径：$GODL_CONFIG，否则为用户配置目录下的godl/config.yaml
+func DefaultPath() string {
+	if p := os.Getenv(PathEnv); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "godl", "config.yaml")
+}
+
+// Load 读取配置文件，未知的配置项视为错误
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse 解析YAML格式的配置
+func Parse(data []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	return c, nil
+}
+
+// Resolve 全局设置与profile合并的结果，profile为空时只有全局设置
+func (c *Config) Resolve(profile string) (Settings, error) {
+	var s Settings
+	s.merge(c.Settings)
+	if profile != "" {
+		p, ok := c.Profiles[profile]
+		if !ok {
+			return s, fmt.Errorf("config: unknown profile %q", profile)
+		}
+		s.merge(p)
+	}
+	return s, nil
+}
+
+// merge o中设置了的项覆盖s，请求头按名字合并，主机按主机名逐项合并
+func (s *Settings) merge(o Settings) {
+	if o.Downloaders != 0 {
+		s.Downloaders = o.Downloaders
+	}
+	if o.Tasks != 0 {
+		s.Tasks = o.Tasks
+	}
+	if o.Dir != "" {
+		s.Dir = o.Dir
+	}
+	if o.ChunkSize != "" {
+		s.ChunkSize = o.ChunkSize
+	}
+	if o.Retries != 0 {
+		s.Retries = o.Retries
+	}
+	if o.RateLimit != "" {
+		s.RateLimit = o.RateLimit
+	}
+	if o.Proxy != "" {
+		s.Proxy = o.Proxy
+	}
+	if o.ConnectTimeout != "" {
+		s.ConnectTimeout = o.ConnectTimeout
+	}
+	if o.HeaderTimeout != "" {
+		s.HeaderTimeout = o.HeaderTimeout
+	}
+	if o.CAFile != "" {
+		s.CAFile = o.CAFile
+	}
+	if o.Insecure != nil {
+		s.Insecure = o.Insecure
+	}
+	if o.Key != "" {
+		s.Key = o.Key
+	}
+	if o.Schedule != "" {
+		s.Schedule = o.Schedule
+	}
+	s.Headers = mergeHeaders(s.Headers, o.Headers)
+
+	for name, h := range o.Hosts {
+		if s.Hosts == nil {
+			s.Hosts = map[string]Host{}
+		}
+		name = strings.ToLower(name)
+		old := s.Hosts[name]
+		if h.Downloaders != 0 {
+			old.Downloaders = h.Downloaders
+		}
+		if h.RateLimit != "" {
+			old.RateLimit = h.RateLimit
+		}
+		if h.Proxy != "" {
+			old.Proxy = h.Proxy
+		}
+		old.Headers = mergeHeaders(old.Headers, h.Headers)
+		s.Hosts[name] = old
+	}
+}
+
+func mergeHeaders(dst, src map[string]string) map[string]string {
+	for k, v := range src {
+		if dst == nil {
+			dst = map[string]string{}
+		}
+		// 名字不区分大小写
+		for old := range dst {
+			if http.CanonicalHeaderKey(old) == http.CanonicalHeaderKey(k) {
+				delete(dst, old)
+			}
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// values 设置了的全局配置项，键见keys；请求头为按名字排序的'Name: value'
+func (s Settings) values() map[string][]string {
+	v := map[string][]string{}
+	set := func(key, value string) {
+		if value != "" {
+			v[key] = []string{value}
+		}
+	}
+	itoa := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	set("downloaders", itoa(s.Downloaders))
+	set("tasks", itoa(s.Tasks))
+	set("dir", s.Dir)
+	set("chunk_size", s.ChunkSize)
+	set("retries", itoa(s.Retries))
+	set("rate_limit", s.RateLimit)
+	set("proxy", s.Proxy)
+	set("connect_timeout", s.ConnectTimeout)
+	set("header_timeout", s.HeaderTimeout)
+	set("ca_file", s.CAFile)
+	if s.Insecure != nil {
+		set("insecure", strconv.FormatBool(*s.Insecure))
+	}
+	set("key", s.Key)
+	set("schedule", s.Schedule)
+	for _, name := range sortedKeys(s.Headers) {
+		v["headers"] = append(v["headers"], name+": "+s.Headers[name])
+	}
+	return v
+}
+
+// env 用环境变量GODL_<KEY>覆盖v中的配置项，请求头不能用环境变量设置
+func env(v map[string][]string, lookup func(string) (string, bool)) {
+	for _, key := range keys {
+		if key == "headers" {
+			continue
+		}
+		if value, ok := lookup(EnvPrefix + strings.ToUpper(key)); ok && value != "" {
+			v[key] = []string{value}
+		}
+	}
+}
+
+// Flags 配置项对应的命令行参数名，没有对应参数的配置项在该命令中不生效
+type Flags map[string]string
+
+// ClientFlags httpclient.Options和httpclient.AuthFlags注册的参数，Loader.Load总是包含这些
+var ClientFlags = Flags{
+	"proxy":           "proxy",
+	"connect_timeout": "connect-timeout",
+	"header_timeout":  "header-timeout",
+	"ca_file":         "cacert",
+	"insecure":        "insecure",
+	"headers":         "H",
+}
+
+// apply 把v作为fs中没有在命令行指定的参数的值，值的格式错误时返回错误
+func apply(fs *flag.FlagSet, names Flags, v map[string][]string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for _, key := range keys {
+		name, ok := names[key]
+		if !ok {
+			name, ok = ClientFlags[key]
+		}
+		if !ok || explicit[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		for _, value := range v[key] {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("config %s: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Loader 命令行中的-config和-profile参数
+type Loader struct {
+	Path    string
+	Profile string
+}
+
+// RegisterFlags 注册命令行参数
+func (l *Loader) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&l.Path, "config", "", "配置文件路径，默认为$"+PathEnv+"或"+DefaultPath()+"（不存在时忽略）")
+	fs.StringVar(&l.Profile, "profile", "", "使用配置文件中的profile，也可以用环境变量"+ProfileEnv+"设置")
+}
+
+// Load 在fs.Parse之后调用：合并配置文件、profile和环境变量，作为fs中没有在命令行指定的参数的值
+// 返回合并后的设置，其中的hosts需要再调用ApplyHosts生效
+// 没有用-config指定且默认路径下没有配置文件时只使用环境变量
+func (l *Loader) Load(fs *flag.FlagSet, names Flags) (Settings, error) {
+	return l.load(fs, names, os.LookupEnv)
+}
+
+func (l *Loader) load(fs *flag.FlagSet, names Flags, lookup func(string) (string, bool)) (Settings, error) {
+	path := l.Path
+	if path == "" {
+		if p, ok := lookup(PathEnv); ok && p != "" {
+			path = p
+		}
+	}
+	profile := l.Profile
+	if profile == "" {
+		profile, _ = lookup(ProfileEnv)
+	}
+
+	c := &Config{}
+	switch {
+	case path != "":
+		var err error
+		if c, err = Load(path); err != nil {
+			return Settings{}, err
+		}
+	default:
+		if p := DefaultPath(); p != "" {
+			data, err := ioutil.ReadFile(p)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return Settings{}, err
+			}
+			if err == nil {
+				if c, err = Parse(data); err != nil {
+					return Settings{}, fmt.Errorf("%s: %v", p, err)
+				}
+			}
+		}
+	}
+
+	s, err := c.Resolve(profile)
+	if err != nil {
+		return s, err
+	}
+	v := s.values()
+	env(v, lookup)
+	return s, apply(fs, names, v)
+}
+
+// ApplyHosts 使按主机的设置生效：下载器数和限速见pool.SetHostLimit，代理写入o.HostProxy，
+// 请求头写入返回的Auth的HostHeader（auth可以为nil，没有按主机的请求头时原样返回auth）
+// 需要在用o创建下载器池之前调用
+func (s Settings) ApplyHosts(o *httpclient.Options, auth *httpclient.Auth) (*httpclient.Auth, error) {
+	hostHeader := map[string]http.Header{}
+	for name, h := range s.Hosts {
+		var limit int64
+		if h.RateLimit != "" {
+			var err error
+			if limit, err = ParseBytes(h.RateLimit); err != nil {
+				return nil, fmt.Errorf("config hosts.%s.rate_limit: %v", name, err)
+			}
+		}
+		pool.SetHostLimit(name, pool.HostLimit{MaxDownloaders: h.Downloaders, RateLimit: limit})
+
+		if h.Proxy != "" {
+			if o.HostProxy == nil {
+				o.HostProxy = map[string]string{}
+			}
+			o.HostProxy[name] = h.Proxy
+		}
+		if len(h.Headers) > 0 {
+			header := http.Header{}
+			for k, v := range h.Headers {
+				header.Set(k, v)
+			}
+			hostHeader[name] = header
+		}
+	}
+	if len(hostHeader) == 0 {
+		return auth, nil
+	}
+	a := auth.WithHeader(nil)
+	a.HostHeader = hostHeader
+	return a, nil
+}
+
+// ParseBytes 解析1048576、512K、1.5M、2G这样的字节数，单位按1024进位
+func ParseBytes(s string) (int64, error) {
+	t := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	mul := int64(1)
+	if n := len(t); n > 0 {
+		if i := strings.IndexByte("KMGT", t[n-1]); i >= 0 {
+			mul = 1 << (10 * uint(i+1))
+			t = t[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(t, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(f * float64(mul)), nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
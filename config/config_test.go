@@ -0,0 +1,245 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/pool"
+)
+
+const testConfig = `
+downloaders: 20
+tasks: 3
+chunk_size: 1M
+rate_limit: 2M
+headers:
+  User-Agent: godl/1.0
+  X-A: a
+hosts:
+  Files.Example.com:
+    downloaders: 4
+    proxy: socks5://127.0.0.1:1080
+    headers:
+      X-Api-Key: secret
+profiles:
+  night:
+    downloaders: 50
+    rate_limit: 0
+    headers:
+      x-a: night
+    hosts:
+      files.example.com:
+        rate_limit: 512K
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.Resolve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Downloaders != 20 || s.RateLimit != "2M" || s.Headers["X-A"] != "a" {
+		t.Fatalf("defaults = %+v", s)
+	}
+
+	s, err = c.Resolve("night")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Downloaders != 50 || s.Tasks != 3 || s.RateLimit != "0" {
+		t.Fatalf("night = %+v", s)
+	}
+	if len(s.Headers) != 2 || s.Headers["x-a"] != "night" || s.Headers["User-Agent"] != "godl/1.0" {
+		t.Fatalf("night headers = %v", s.Headers)
+	}
+	h := s.Hosts["files.example.com"]
+	if h.Downloaders != 4 || h.RateLimit != "512K" || h.Proxy == "" || h.Headers["X-Api-Key"] != "secret" {
+		t.Fatalf("night host = %+v", h)
+	}
+
+	if _, err = c.Resolve("day"); err == nil {
+		t.Fatal("unknown profile should fail")
+	}
+	if _, err = Parse([]byte("downloader: 1\n")); err == nil {
+		t.Fatal("unknown key should fail")
+	}
+}
+
+// testFlags 与godl相同的参数
+func testFlags() (*flag.FlagSet, *httpclient.Options, *httpclient.AuthFlags) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("n", 16, "")
+	fs.Int("j", 5, "")
+	fs.String("limit", "", "")
+	o, a := &httpclient.Options{}, &httpclient.AuthFlags{}
+	o.RegisterFlags(fs)
+	a.RegisterFlags(fs)
+	return fs, o, a
+}
+
+var testNames = Flags{"downloaders": "n", "tasks": "j", "rate_limit": "limit"}
+
+func TestLoader_Precedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err = ioutil.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 配置文件 < profile < 环境变量 < 命令行
+	fs, o, a := testFlags()
+	l := &Loader{}
+	l.RegisterFlags(fs)
+	if err = fs.Parse([]string{"-config", path, "-j", "7", "-H", "X-B: cli"}); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{ProfileEnv: "night", "GODL_DOWNLOADERS": "30", "GODL_CONNECT_TIMEOUT": "5s"}
+	s, err := l.load(fs, testNames, func(k string) (string, bool) { v, ok := env[k]; return v, ok })
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(name string) string { return fs.Lookup(name).Value.String() }
+	if get("n") != "30" || get("j") != "7" || get("limit") != "0" {
+		t.Fatalf("n=%s j=%s limit=%s", get("n"), get("j"), get("limit"))
+	}
+	if o.DialTimeout != 5*time.Second {
+		t.Fatalf("connect timeout = %v", o.DialTimeout)
+	}
+	// 命令行的-H代替配置中的全局请求头
+	if len(a.Headers) != 1 || a.Headers[0] != "X-B: cli" {
+		t.Fatalf("headers = %v", a.Headers)
+	}
+	if s.Hosts["files.example.com"].RateLimit != "512K" {
+		t.Fatalf("hosts = %v", s.Hosts)
+	}
+
+	// 没有命令行参数时使用配置文件中的请求头
+	fs, _, a = testFlags()
+	l = &Loader{Path: path}
+	if _, err = l.load(fs, testNames, func(string) (string, bool) { return "", false }); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(a.Headers, ", ") != "User-Agent: godl/1.0, X-A: a" || fs.Lookup("n").Value.String() != "20" {
+		t.Fatalf("headers = %v, n = %s", a.Headers, fs.Lookup("n").Value)
+	}
+
+	// 配置值格式错误
+	fs, _, _ = testFlags()
+	env = map[string]string{"GODL_TASKS": "many"}
+	if _, err = l.load(fs, testNames, func(k string) (string, bool) { v, ok := env[k]; return v, ok }); err == nil {
+		t.Fatal("invalid env value should fail")
+	}
+
+	// 用-config指定的文件不存在
+	l = &Loader{Path: filepath.Join(dir, "missing.yaml")}
+	if _, err = l.load(fs, testNames, func(string) (string, bool) { return "", false }); err == nil {
+		t.Fatal("missing config should fail")
+	}
+}
+
+func TestLoader_Key(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err = ioutil.WriteFile(path, []byte("key: file\nprofiles:\n  paid:\n    key: profile\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 命令行 > 环境变量 > profile > 配置文件
+	for _, c := range []struct {
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{nil, nil, "file"},
+		{[]string{"-profile", "paid"}, nil, "profile"},
+		{[]string{"-profile", "paid"}, map[string]string{"GODL_KEY": "env"}, "env"},
+		{[]string{"-profile", "paid", "-key", "cli"}, map[string]string{"GODL_KEY": "env"}, "cli"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		key := fs.String("key", "", "")
+		l := &Loader{}
+		l.RegisterFlags(fs)
+		if err = fs.Parse(append([]string{"-config", path}, c.args...)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = l.load(fs, Flags{"key": "key"}, func(k string) (string, bool) { v, ok := c.env[k]; return v, ok }); err != nil {
+			t.Fatal(err)
+		}
+		if *key != c.want {
+			t.Errorf("%v %v: key = %q, want %q", c.args, c.env, *key, c.want)
+		}
+	}
+}
+
+func TestSettings_ApplyHosts(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.Resolve("night")
+	defer pool.SetHostLimit("files.example.com", pool.HostLimit{})
+
+	var o httpclient.Options
+	auth, err := s.ApplyHosts(&o, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := pool.GetHostLimit("files.example.com"); l.MaxDownloaders != 4 || l.RateLimit != 512<<10 {
+		t.Fatalf("host limit = %+v", l)
+	}
+	if o.HostProxy["files.example.com"] != "socks5://127.0.0.1:1080" {
+		t.Fatalf("host proxy = %v", o.HostProxy)
+	}
+	if auth == nil || auth.HostHeader["files.example.com"].Get("X-Api-Key") != "secret" {
+		t.Fatalf("auth = %+v", auth)
+	}
+
+	// 没有按主机的请求头时原样返回
+	base := &httpclient.Auth{Header: http.Header{}}
+	if got, _ := (Settings{}).ApplyHosts(&o, base); got != base {
+		t.Fatal("auth replaced")
+	}
+	s.Hosts["bad.example.com"] = Host{RateLimit: "fast"}
+	if _, err = s.ApplyHosts(&o, nil); err == nil {
+		t.Fatal("invalid rate limit should fail")
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	cases := map[string]int64{
+		"1048576": 1 << 20,
+		"512K":    512 << 10,
+		"1.5M":    3 << 19,
+		"2g":      2 << 30,
+		"4MiB":    4 << 20,
+		"100B":    100,
+	}
+	for s, want := range cases {
+		if got, err := ParseBytes(s); err != nil || got != want {
+			t.Errorf("ParseBytes(%q) = %d, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "M", "-1K", "1X"} {
+		if _, err := ParseBytes(s); err == nil {
+			t.Errorf("ParseBytes(%q): want error", s)
+		}
+	}
+}
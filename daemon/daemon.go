@@ -0,0 +1,316 @@
+// Package daemon 下载守护进程的HTTP控制接口
+//
+// 同一个Server提供两套接口，背后都是task.Manager：
+//
+//	POST /jsonrpc  JSON-RPC 2.0，方法名与aria2兼容（aria2.addUri、aria2.tellStatus等），AriaNg等前端可以直接使用，见rpc.go
+//	/api/...       REST接口，见rest.go
+//	/api/events    事件推送(SSE/WebSocket)，见events.go
+//	/metrics       Prometheus指标，包括metrics.Default中下载器池和任务的指标，以及队列中各状态的任务数
+//	/              网页界面（Options.UI），见ui.go
+//
+// 设置了Secret时，JSON-RPC的第一个参数需要是"token:SECRET"（与aria2的--rpc-secret相同），
+// REST请求需要带上Authorization: Bearer SECRET或查询参数token=SECRET。
+//
+// 为了防止网页跨站调用本机的守护进程，带有Origin的请求只有与Host相同或在Options.Origins中时才接受，
+// 带有请求体的请求必须是Content-Type: application/json（浏览器发送这种请求前需要预检）；
+// 没有设置Secret时，Host还必须是IP地址或localhost，防止DNS重绑定。
+// 任务的dir只能在Defaults.Dir之内，out(file_name)不能超出dir。
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/metrics"
+	"github.com/azd1997/blockchair_downloader/pool"
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+// Version 守护进程的版本，aria2.getVersion返回
+const Version = "1.0.0"
+
+// Options 守护进程选项
+type Options struct {
+	Secret string // 访问令牌，为空时不检查
+	UI     bool   // 在/提供网页界面
+
+	// Origins 允许跨站访问的来源，如 http://ariang.example.com，"*"表示任意来源；与Host相同的来源总是允许
+	Origins []string
+
+	// Defaults 新任务的默认选项，请求中没有设置的字段使用这里的值
+	Defaults task.Options
+	// Auth 与Manager.SetAuth相同的认证信息，任务带有额外的请求头时在它的基础上添加
+	Auth *httpclient.Auth
+
+	// Shutdown aria2.shutdown调用时执行，为nil时不支持远程关闭
+	Shutdown func()
+}
+
+// Server 控制接口，实现http.Handler
+type Server struct {
+	m      *task.Manager
+	opts   Options
+	mux    *http.ServeMux
+	events *broker
+	reg    *metrics.Registry // 只属于这个Server的指标
+}
+
+// New 创建控制接口
+func New(m *task.Manager, opts Options) *Server {
+	s := &Server{m: m, opts: opts, mux: http.NewServeMux(), events: newBroker(), reg: metrics.NewRegistry()}
+	m.OnEvent(s.events.publish)
+	s.reg.GaugeFunc("godl_jobs", "队列中各状态的任务数", []string{"state"}, s.collectJobs)
+	s.mux.HandleFunc("/jsonrpc", s.serveRPC)
+	s.mux.HandleFunc("/api/jobs", s.auth(s.serveJobs))
+	s.mux.HandleFunc("/api/jobs/", s.auth(s.serveJob))
+	s.mux.HandleFunc("/api/stats", s.auth(s.serveStats))
+	s.mux.HandleFunc("/api/options", s.auth(s.serveOptions))
+	s.mux.HandleFunc("/api/events", s.auth(s.serveEvents))
+	s.mux.Handle("/metrics", s.auth(metrics.Handler(metrics.Default, s.reg).ServeHTTP))
+	if opts.UI {
+		s.mux.Handle("/", uiHandler())
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if !s.allowOrigin(origin, r.Host) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("origin %s not allowed", origin))
+			return
+		}
+		if !sameOrigin(origin, r.Host) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			if r.Method == http.MethodOptions { // 预检
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+	}
+	if s.opts.Secret == "" && !localHost(r.Host) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("host %s not allowed without secret", r.Host))
+		return
+	}
+	if r.ContentLength != 0 && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// allowOrigin 来源与Host相同，或在Options.Origins中
+func (s *Server) allowOrigin(origin, host string) bool {
+	if sameOrigin(origin, host) {
+		return true
+	}
+	for _, o := range s.opts.Origins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && strings.EqualFold(u.Host, host)
+}
+
+// localHost Host为IP地址或localhost
+func localHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.ParseIP(host) != nil || strings.EqualFold(host, "localhost")
+}
+
+// cleanFileName 清理任务的文件名，可以含有子目录，但不能是绝对路径或超出保存目录
+func cleanFileName(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || clean == "." || clean == ".." ||
+		strings.HasPrefix(clean, ".."+string(filepath.Separator)) || strings.HasPrefix(clean, string(filepath.Separator)) {
+		return "", fmt.Errorf("file name %q must stay inside dir", name)
+	}
+	return clean, nil
+}
+
+// resolveDir 任务的保存目录只能在Defaults.Dir之内：相对路径相对于Defaults.Dir，绝对路径必须在它之下
+func (s *Server) resolveDir(dir string) (string, error) {
+	root := s.opts.Defaults.Dir
+	if dir == "" || dir == root {
+		return root, nil
+	}
+	if root == "" {
+		root = task.DownloadDir
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	abs := filepath.Clean(filepath.FromSlash(dir))
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(absRoot, abs)
+	}
+	rel, err := filepath.Rel(absRoot, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("dir %q must stay inside %s", dir, root)
+	}
+	return abs, nil
+}
+
+// checkPaths 检查REST请求中的dir和file_name，见resolveDir、cleanFileName
+func (s *Server) checkPaths(opts *task.Options) (err error) {
+	if opts.Dir, err = s.resolveDir(opts.Dir); err != nil {
+		return err
+	}
+	opts.FileName, err = cleanFileName(opts.FileName)
+	return err
+}
+
+// Manager 背后的任务队列
+func (s *Server) Manager() *task.Manager {
+	return s.m
+}
+
+// checkSecret 令牌是否正确，没有设置Secret时总是正确
+func (s *Server) checkSecret(token string) bool {
+	if s.opts.Secret == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Secret)) == 1
+}
+
+// auth REST接口检查Authorization: Bearer SECRET，没有请求头时检查查询参数token
+func (s *Server) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if !s.checkSecret(token) {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// withHeader 附加了请求头的认证信息，没有请求头时返回nil（使用Manager的默认设置）
+func (s *Server) withHeader(headers []string) (*httpclient.Auth, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	h := http.Header{}
+	for _, v := range headers {
+		name, value, err := httpclient.ParseHeader(v)
+		if err != nil {
+			return nil, err
+		}
+		h.Add(name, value)
+	}
+	return s.opts.Auth.WithHeader(h), nil
+}
+
+// GlobalStats 所有任务的汇总
+type GlobalStats struct {
+	Pool      pool.PoolStats `json:"pool"`
+	Queued    int            `json:"queued"`
+	Active    int            `json:"active"`
+	Paused    int            `json:"paused"`
+	Completed int            `json:"completed"`
+	Failed    int            `json:"failed"`
+}
+
+// Stats 当前的汇总
+func (s *Server) Stats() GlobalStats {
+	g := GlobalStats{Pool: pool.Stats()}
+	for _, j := range s.m.Jobs() {
+		switch j.State {
+		case task.JobQueued:
+			g.Queued++
+		case task.JobActive:
+			g.Active++
+		case task.JobPaused:
+			g.Paused++
+		case task.JobCompleted:
+			g.Completed++
+		case task.JobFailed:
+			g.Failed++
+		}
+	}
+	return g
+}
+
+// collectJobs 输出godl_jobs
+func (s *Server) collectJobs(emit func(float64, ...string)) {
+	g := s.Stats()
+	emit(float64(g.Queued), task.JobQueued.String())
+	emit(float64(g.Active), task.JobActive.String())
+	emit(float64(g.Paused), task.JobPaused.String())
+	emit(float64(g.Completed), task.JobCompleted.String())
+	emit(float64(g.Failed), task.JobFailed.String())
+}
+
+// GlobalOptions 运行中可以修改的全局选项，零值表示不修改
+type GlobalOptions struct {
+	MaxActive      int   `json:"max_active"`      // 同时下载的文件数
+	MaxDownloaders int   `json:"max_downloaders"` // 下载器数
+	RateLimit      int64 `json:"rate_limit"`      // 合计限速，字节/秒，-1表示取消限速
+}
+
+// GlobalOptions 当前的全局选项，RateLimit为0表示不限速
+func (s *Server) GlobalOptions() GlobalOptions {
+	return GlobalOptions{
+		MaxActive:      s.m.MaxActive(),
+		MaxDownloaders: pool.MaxDownloaders(),
+		RateLimit:      pool.RateLimit(),
+	}
+}
+
+// SetGlobalOptions 修改全局选项
+func (s *Server) SetGlobalOptions(o GlobalOptions) error {
+	if o.MaxActive != 0 {
+		if err := s.m.SetMaxActive(o.MaxActive); err != nil {
+			return err
+		}
+	}
+	if o.MaxDownloaders != 0 {
+		if err := pool.SetMaxDownloaders(o.MaxDownloaders); err != nil {
+			return err
+		}
+	}
+	if o.RateLimit != 0 {
+		pool.SetRateLimit(o.RateLimit) // <0时取消限速
+	}
+	return nil
+}
+
+// writeJSON 输出JSON响应
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError 输出 {"error": "..."}
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
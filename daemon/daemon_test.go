@@ -0,0 +1,433 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/pool"
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+const testSecret = "s3cret"
+
+// newTestServer 文件服务器和守护进程，返回守护进程的地址
+func newTestServer(t *testing.T) (files *httptest.Server, daemon *httptest.Server, s *Server, cleanup func()) {
+	if err := pool.Init(4); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+
+	data := make([]byte, 300<<10)
+	rand.Read(data)
+	files = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "missing") {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+	}))
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = New(task.NewManager(1), Options{Secret: testSecret, Defaults: task.Options{Dir: dir}})
+	daemon = httptest.NewServer(s)
+	return files, daemon, s, func() {
+		daemon.Close()
+		files.Close()
+		pool.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// rpc 调用JSON-RPC方法，返回result或error
+func rpc(t *testing.T, url, method string, params ...interface{}) (json.RawMessage, *rpcError) {
+	body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": "1", "method": method, "params": params})
+	rsp, err := http.Post(url+"/jsonrpc", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	var r struct {
+		Result json.RawMessage
+		Error  *rpcError
+	}
+	if err = json.NewDecoder(rsp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	return r.Result, r.Error
+}
+
+func TestServer_RPC(t *testing.T) {
+	files, daemon, s, cleanup := newTestServer(t)
+	defer cleanup()
+	token := "token:" + testSecret
+
+	if _, e := rpc(t, daemon.URL, "aria2.getVersion", "token:wrong"); e == nil || e.Message != "Unauthorized" {
+		t.Fatalf("wrong token: %v", e)
+	}
+	if _, e := rpc(t, daemon.URL, "aria2.nope", token); e == nil || e.Code != codeNoMethod {
+		t.Fatalf("unknown method: %v", e)
+	}
+
+	for _, o := range []map[string]interface{}{
+		{"out": "../a.bin"},
+		{"dir": "/tmp/x", "allow-overwrite": "true"},
+		{"dir": "../.."},
+	} {
+		if _, e := rpc(t, daemon.URL, "aria2.addUri", token, []string{files.URL + "/a.bin"}, o); e == nil || e.Code != codeInvalidParams {
+			t.Fatalf("%v: %v", o, e)
+		}
+	}
+	r, e := rpc(t, daemon.URL, "aria2.addUri", token, []string{files.URL + "/a.bin"}, map[string]interface{}{"out": "a.bin", "max-tries": "2"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	var gid string
+	json.Unmarshal(r, &gid)
+	if len(gid) != 16 {
+		t.Fatalf("gid = %q", gid)
+	}
+	if _, e = rpc(t, daemon.URL, "aria2.addUri", token, []string{files.URL + "/missing"}); e != nil {
+		t.Fatal(e)
+	}
+	s.Manager().Wait()
+
+	r, e = rpc(t, daemon.URL, "aria2.tellStatus", token, gid, []string{"status", "totalLength", "completedLength", "files"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	var st struct {
+		Status          string
+		TotalLength     string
+		CompletedLength string
+		Files           []struct{ Path string }
+	}
+	json.Unmarshal(r, &st)
+	if st.Status != "complete" || st.TotalLength != "307200" || st.CompletedLength != st.TotalLength ||
+		len(st.Files) != 1 || !strings.HasSuffix(st.Files[0].Path, "a.bin") {
+		t.Fatalf("status = %s", r)
+	}
+
+	r, e = rpc(t, daemon.URL, "aria2.tellStopped", token, -1, 10, []string{"gid", "status", "errorCode"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	var stopped []map[string]string
+	json.Unmarshal(r, &stopped)
+	if len(stopped) != 2 || stopped[0]["status"] != "error" || stopped[0]["errorCode"] != "3" || stopped[1]["gid"] != gid {
+		t.Fatalf("stopped = %s", r)
+	}
+
+	r, e = rpc(t, daemon.URL, "system.multicall", []map[string]interface{}{
+		{"methodName": "aria2.getGlobalStat", "params": []string{token}},
+		{"methodName": "aria2.pause", "params": []string{token, gid}},
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	var multi []json.RawMessage
+	json.Unmarshal(r, &multi)
+	if len(multi) != 2 || !strings.Contains(string(multi[0]), `"numStopped":"2"`) || !strings.Contains(string(multi[1]), `"code":1`) {
+		t.Fatalf("multicall = %s", r)
+	}
+
+	if _, e = rpc(t, daemon.URL, "aria2.changeGlobalOption", token, map[string]string{"max-concurrent-downloads": "3"}); e != nil {
+		t.Fatal(e)
+	}
+	if s.Manager().MaxActive() != 3 {
+		t.Fatalf("max active = %d", s.Manager().MaxActive())
+	}
+	if _, e = rpc(t, daemon.URL, "aria2.purgeDownloadResult", token); e != nil || len(s.Manager().Jobs()) != 0 {
+		t.Fatalf("purge: %v, jobs=%d", e, len(s.Manager().Jobs()))
+	}
+}
+
+func TestServer_RPCBatch(t *testing.T) {
+	_, daemon, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	body := `[{"jsonrpc":"2.0","id":1,"method":"aria2.getVersion","params":["token:s3cret"]},` +
+		`{"jsonrpc":"2.0","id":2,"method":"aria2.tellStatus","params":["token:s3cret","zz"]}]`
+	rsp, err := http.Post(daemon.URL+"/jsonrpc", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	var rsps []rpcResponse
+	if err = json.NewDecoder(rsp.Body).Decode(&rsps); err != nil {
+		t.Fatal(err)
+	}
+	if len(rsps) != 2 || rsps[0].Error != nil || rsps[1].Error == nil || string(rsps[1].ID) != "2" {
+		t.Fatalf("batch = %+v", rsps)
+	}
+}
+
+func TestServer_REST(t *testing.T) {
+	files, daemon, s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	do := func(method, path, body string, v interface{}) int {
+		req, _ := http.NewRequest(method, daemon.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testSecret)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsp.Body.Close()
+		if v != nil {
+			json.NewDecoder(rsp.Body).Decode(v)
+		}
+		return rsp.StatusCode
+	}
+
+	if rsp, err := http.Get(daemon.URL + "/api/jobs"); err != nil || rsp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("no token: %v %v", rsp.StatusCode, err)
+	}
+
+	for _, dir := range []string{"/tmp/x", "../.."} {
+		body := `{"url": "` + files.URL + `/b.bin", "options": {"dir": "` + dir + `", "overwrite": true}}`
+		if code := do(http.MethodPost, "/api/jobs", body, nil); code != http.StatusBadRequest {
+			t.Fatalf("dir %s: %d", dir, code)
+		}
+	}
+
+	var added struct{ Ids []uint64 }
+	body := `{"urls": ["` + files.URL + `/b.bin", "` + files.URL + `/c.bin"], "options": {"priority": 1}}`
+	if code := do(http.MethodPost, "/api/jobs", body, &added); code != http.StatusCreated || len(added.Ids) != 2 {
+		t.Fatalf("add: %d %v", code, added)
+	}
+	s.Manager().Wait()
+
+	var job Job
+	if code := do(http.MethodGet, "/api/jobs/"+itoa(added.Ids[0]), "", &job); code != http.StatusOK ||
+		job.State != "completed" || job.Options.Priority != 1 || job.Stats.BytesDone != 300<<10 {
+		t.Fatalf("get: %d %+v", code, job)
+	}
+	if code := do(http.MethodPost, "/api/jobs/"+itoa(added.Ids[0])+"/pause", "", nil); code != http.StatusConflict {
+		t.Fatalf("pause finished: %d", code)
+	}
+	if code := do(http.MethodPost, "/api/jobs/"+itoa(added.Ids[0])+"/retry", "", nil); code != http.StatusConflict {
+		t.Fatalf("retry completed: %d", code)
+	}
+	if code := do(http.MethodDelete, "/api/jobs/"+itoa(added.Ids[0]), "", nil); code != http.StatusNoContent {
+		t.Fatalf("delete: %d", code)
+	}
+	if code := do(http.MethodGet, "/api/jobs/"+itoa(added.Ids[0]), "", nil); code != http.StatusNotFound {
+		t.Fatalf("get deleted: %d", code)
+	}
+
+	var g GlobalStats
+	if code := do(http.MethodGet, "/api/stats", "", &g); code != http.StatusOK || g.Completed != 1 {
+		t.Fatalf("stats: %d %+v", code, g)
+	}
+	var o GlobalOptions
+	if code := do(http.MethodPatch, "/api/options", `{"max_active": 2}`, &o); code != http.StatusOK || o.MaxActive != 2 {
+		t.Fatalf("options: %d %+v", code, o)
+	}
+}
+
+func itoa(id uint64) string {
+	b, _ := json.Marshal(id)
+	return string(b)
+}
+
+func TestServer_UI(t *testing.T) {
+	m := task.NewManager(1)
+	srv := httptest.NewServer(New(m, Options{Secret: testSecret, UI: true}))
+	defer srv.Close()
+
+	// 页面不需要令牌
+	for path, want := range map[string]string{"/": "<title>godld</title>", "/app.js": "/api/events", "/style.css": ".bar"} {
+		rsp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if rsp.StatusCode != http.StatusOK || !strings.Contains(string(body), want) {
+			t.Fatalf("%s: %s", path, rsp.Status)
+		}
+	}
+
+	off := httptest.NewServer(New(m, Options{}))
+	defer off.Close()
+	if rsp, err := http.Get(off.URL + "/"); err != nil || rsp.StatusCode != http.StatusNotFound {
+		t.Fatalf("ui disabled: %v %v", rsp.StatusCode, err)
+	}
+}
+
+func TestServer_Metrics(t *testing.T) {
+	files, daemon, s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	if _, e := rpc(t, daemon.URL, "aria2.addUri", "token:"+testSecret, []string{files.URL + "/m.bin"}); e != nil {
+		t.Fatal(e)
+	}
+	s.Manager().Wait()
+
+	if rsp, err := http.Get(daemon.URL + "/metrics"); err != nil || rsp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("no token: %v %v", rsp.StatusCode, err)
+	}
+	rsp, err := http.Get(daemon.URL + "/metrics?token=" + testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	host := "127.0.0.1" // 不含端口，与按主机限速相同
+	for _, want := range []string{
+		`godl_jobs{state="completed"} 1`,
+		`godl_bytes_total{host="` + host + `"}`,
+		`godl_chunks_total{result="success",reason=""}`,
+		`godl_downloaders{state="idle"}`,
+		`godl_request_duration_seconds_count{host="` + host + `"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("missing %s in:\n%s", want, body)
+		}
+	}
+}
+
+func TestServer_CrossOrigin(t *testing.T) {
+	files, daemon, s, cleanup := newTestServer(t)
+	defer cleanup()
+	s.opts.Origins = []string{"http://ariang.example.com"}
+
+	send := func(method, path, origin, contentType, body string) *http.Response {
+		req, _ := http.NewRequest(method, daemon.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testSecret)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+		return rsp
+	}
+	add := `{"jsonrpc":"2.0","id":1,"method":"aria2.addUri","params":["token:s3cret",["` + files.URL + `/a.bin"]]}`
+
+	// 网页不经预检就能发出的text/plain请求
+	if rsp := send(http.MethodPost, "/jsonrpc", "http://evil.example.com", "text/plain", add); rsp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross origin: %s", rsp.Status)
+	}
+	if rsp := send(http.MethodPost, "/jsonrpc", "", "text/plain", add); rsp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("text/plain: %s", rsp.Status)
+	}
+	if rsp := send(http.MethodPost, "/api/jobs", daemon.URL, "", `{"url":"`+files.URL+`/a.bin"}`); rsp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("no content type: %s", rsp.Status)
+	}
+	if rsp := send(http.MethodGet, "/api/stats", daemon.URL, "", ""); rsp.StatusCode != http.StatusOK ||
+		rsp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("same origin: %s %v", rsp.Status, rsp.Header)
+	}
+	rsp := send(http.MethodOptions, "/jsonrpc", "http://ariang.example.com", "", "")
+	if rsp.StatusCode != http.StatusNoContent || rsp.Header.Get("Access-Control-Allow-Origin") != "http://ariang.example.com" ||
+		!strings.Contains(rsp.Header.Get("Access-Control-Allow-Headers"), "Content-Type") {
+		t.Fatalf("preflight: %s %v", rsp.Status, rsp.Header)
+	}
+	if rsp := send(http.MethodPost, "/jsonrpc", "http://ariang.example.com", "application/json; charset=utf-8", add); rsp.StatusCode != http.StatusOK {
+		t.Fatalf("allowed origin: %s", rsp.Status)
+	}
+	s.Manager().Wait()
+
+	// WebSocket握手同样检查Origin
+	addr := strings.TrimPrefix(daemon.URL, "http://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /api/events HTTP/1.1\r\nHost: "+addr+"\r\nOrigin: http://evil.example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: MDEyMzQ1Njc4OWFiY2RlZg==\r\n\r\n")
+	if rsp, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil || rsp.StatusCode != http.StatusForbidden {
+		t.Fatalf("websocket: %v %v", rsp, err)
+	}
+}
+
+func TestServer_NoSecretHost(t *testing.T) {
+	s := New(task.NewManager(1), Options{})
+	for host, want := range map[string]int{
+		"127.0.0.1:6800":     http.StatusOK,
+		"localhost:6800":     http.StatusOK,
+		"[::1]:6800":         http.StatusOK,
+		"evil.example.com":   http.StatusForbidden, // DNS重绑定
+		"evil.example.com:1": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: %d, want %d", host, w.Code, want)
+		}
+	}
+}
+
+func TestCleanFileName(t *testing.T) {
+	for name, want := range map[string]string{
+		"":            "",
+		"a.gz":        "a.gz",
+		"sub/./a.gz":  "sub/a.gz",
+		"sub/../a.gz": "a.gz",
+		"..a.gz":      "..a.gz",
+	} {
+		if got, err := cleanFileName(name); err != nil || got != want {
+			t.Errorf("%q: %q %v", name, got, err)
+		}
+	}
+	for _, name := range []string{"../a.gz", "sub/../../a.gz", "..", ".", "/etc/passwd", "./"} {
+		if _, err := cleanFileName(name); err == nil {
+			t.Errorf("%q: want error", name)
+		}
+	}
+}
+
+func TestServer_ResolveDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := &Server{opts: Options{Defaults: task.Options{Dir: root}}}
+	for dir, want := range map[string]string{
+		"":                       root,
+		root:                     root,
+		"sub":                    filepath.Join(root, "sub"),
+		"sub/../other":           filepath.Join(root, "other"),
+		filepath.Join(root, "a"): filepath.Join(root, "a"),
+	} {
+		if got, err := s.resolveDir(dir); err != nil || got != want {
+			t.Errorf("%q: %q %v", dir, got, err)
+		}
+		// 已经解析过的目录再次解析不变（PATCH、changeOption时）
+		if got, err := s.resolveDir(want); err != nil || got != want {
+			t.Errorf("%q again: %q %v", want, got, err)
+		}
+	}
+	for _, dir := range []string{"/tmp/x", "../..", "..", "sub/../../x", root + "-other"} {
+		if got, err := s.resolveDir(dir); err == nil {
+			t.Errorf("%q: want error, got %q", dir, got)
+		}
+	}
+}
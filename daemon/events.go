@@ -0,0 +1,262 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+// 事件推送：
+//
+//	GET /api/events?id=1,2&tag=nightly&type=progress,completed
+//
+// 默认为Server-Sent Events，带有Upgrade: websocket时为WebSocket，每个事件是一个JSON文本消息。
+// 过滤条件之间为“且”，同一条件的多个值为“或”；没有type时推送除chunk_done外的所有事件。
+// WebSocket客户端可以随时发送 {"ids": [...], "tags": [...], "types": [...]} 替换过滤条件。
+// 浏览器的EventSource和WebSocket不能设置请求头，令牌可以放在?token=中。
+
+// KeepAlive 没有事件时发送心跳的间隔
+var KeepAlive = 30 * time.Second
+
+// subscriberBuffer 每个订阅者缓存的事件数，缓存满（客户端太慢）时断开连接，客户端重连后应通过/api/jobs获取最新状态
+const subscriberBuffer = 256
+
+// EventMessage 推送给订阅者的事件
+type EventMessage struct {
+	Type  string    `json:"type"`
+	JobID uint64    `json:"job_id"`
+	Url   string    `json:"url"` // 已隐去密码
+	Tags  []string  `json:"tags,omitempty"`
+	Time  time.Time `json:"time"`
+	File  string    `json:"file,omitempty"`
+
+	// 分块事件(chunk_done/retrying)的分块范围与尝试次数
+	Begin int64 `json:"begin,omitempty"`
+	End   int64 `json:"end,omitempty"`
+	Tried int   `json:"tried,omitempty"`
+
+	ChunkDone int64       `json:"chunk_done"`
+	ChunkNum  int64       `json:"chunk_num"`
+	Stats     *task.Stats `json:"stats,omitempty"` // 任务已开始时的统计信息
+	Error     string      `json:"error,omitempty"`
+}
+
+func newEventMessage(e task.Event) EventMessage {
+	msg := EventMessage{
+		Type:      e.Type.String(),
+		JobID:     e.JobID,
+		Url:       httpclient.Redact(e.Url),
+		Tags:      e.Tags,
+		Time:      e.Time,
+		Begin:     e.Begin,
+		End:       e.End,
+		Tried:     e.Tried,
+		ChunkDone: e.ChunkDone,
+		ChunkNum:  e.ChunkNum,
+	}
+	if e.Task != nil {
+		st := e.Task.Stats()
+		msg.Stats, msg.File = &st, e.Task.FileName
+	}
+	if e.Err != nil {
+		msg.Error = e.Err.Error()
+	}
+	return msg
+}
+
+// Filter 订阅的过滤条件，空的条件不过滤
+type Filter struct {
+	IDs   []uint64 `json:"ids"`
+	Tags  []string `json:"tags"`
+	Types []string `json:"types"`
+}
+
+// parseFilter 解析查询参数id、tag、type，每个参数可以重复出现或用逗号分隔
+func parseFilter(q map[string][]string) (Filter, error) {
+	var f Filter
+	split := func(name string) []string {
+		var list []string
+		for _, v := range q[name] {
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					list = append(list, s)
+				}
+			}
+		}
+		return list
+	}
+	for _, s := range split("id") {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("invalid id %q", s)
+		}
+		f.IDs = append(f.IDs, id)
+	}
+	f.Tags, f.Types = split("tag"), split("type")
+	return f, nil
+}
+
+func (f Filter) match(e task.Event) bool {
+	if len(f.Types) == 0 {
+		if e.Type == task.EventChunkDone {
+			return false
+		}
+	} else if !contains(f.Types, e.Type.String()) {
+		return false
+	}
+	if len(f.IDs) > 0 {
+		found := false
+		for _, id := range f.IDs {
+			found = found || id == e.JobID
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Tags) > 0 {
+		found := false
+		for _, tag := range e.Tags {
+			found = found || contains(f.Tags, tag)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriber 一个事件订阅，C在订阅结束或客户端太慢时关闭
+type subscriber struct {
+	C      chan []byte
+	filter Filter
+	closed bool
+}
+
+// broker 将Manager的事件分发给订阅者
+type broker struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{subs: map[*subscriber]struct{}{}}
+}
+
+func (b *broker) subscribe(f Filter) *subscriber {
+	s := &subscriber{C: make(chan []byte, subscriberBuffer), filter: f}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *broker) unsubscribe(s *subscriber) {
+	b.mu.Lock()
+	b.remove(s)
+	b.mu.Unlock()
+}
+
+// remove 调用者需持有b.mu
+func (b *broker) remove(s *subscriber) {
+	if !s.closed {
+		s.closed = true
+		close(s.C)
+		delete(b.subs, s)
+	}
+}
+
+// setFilter 替换订阅的过滤条件
+func (b *broker) setFilter(s *subscriber, f Filter) {
+	b.mu.Lock()
+	s.filter = f
+	b.mu.Unlock()
+}
+
+// publish 作为Manager的事件回调，在任务的下载协程中执行，不会阻塞
+func (b *broker) publish(e task.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var data []byte
+	for s := range b.subs {
+		if !s.filter.match(e) {
+			continue
+		}
+		if data == nil { // 有订阅者时才编码
+			data, _ = json.Marshal(newEventMessage(e))
+		}
+		select {
+		case s.C <- data:
+		default:
+			b.remove(s)
+		}
+	}
+}
+
+// serveEvents 推送事件，见文件开头的说明
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	f, err := parseFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if isWebSocket(r) {
+		s.serveWebSocket(w, r, f)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+	sub := s.events.subscribe(f)
+	defer s.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // 经过nginx时不缓冲
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case data, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if _, err = fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
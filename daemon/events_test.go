@@ -0,0 +1,207 @@
+package daemon
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+func TestFilter(t *testing.T) {
+	f, err := parseFilter(map[string][]string{"id": {"1,2", "3"}, "tag": {"a"}})
+	if err != nil || len(f.IDs) != 3 || f.Tags[0] != "a" {
+		t.Fatalf("filter = %+v, %v", f, err)
+	}
+	if _, err = parseFilter(map[string][]string{"id": {"x"}}); err == nil {
+		t.Fatal("invalid id should fail")
+	}
+
+	e := task.Event{Type: task.EventProgress, JobID: 2, Tags: []string{"b", "a"}}
+	if !f.match(e) {
+		t.Fatal("should match")
+	}
+	if (Filter{Tags: []string{"c"}}).match(e) || (Filter{IDs: []uint64{4}}).match(e) || (Filter{Types: []string{"completed"}}).match(e) {
+		t.Fatal("should not match")
+	}
+	// 没有type时不推送chunk_done
+	if (Filter{}).match(task.Event{Type: task.EventChunkDone}) || !(Filter{Types: []string{"chunk_done"}}).match(task.Event{Type: task.EventChunkDone}) {
+		t.Fatal("chunk_done filter")
+	}
+}
+
+func TestBroker_SlowSubscriber(t *testing.T) {
+	b := newBroker()
+	slow := b.subscribe(Filter{})
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.publish(task.Event{Type: task.EventQueued, JobID: uint64(i)})
+	}
+	n := 0
+	for range slow.C {
+		n++
+	}
+	if n != subscriberBuffer || len(b.subs) != 0 {
+		t.Fatalf("received %d, subscribers %d", n, len(b.subs))
+	}
+	b.unsubscribe(slow) // 已被移除时不再关闭
+}
+
+// addJob 通过REST添加任务
+func addJob(t *testing.T, daemon, body string) uint64 {
+	req, _ := http.NewRequest(http.MethodPost, daemon+"/api/jobs", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testSecret)
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	var added struct{ Ids []uint64 }
+	json.NewDecoder(rsp.Body).Decode(&added)
+	if len(added.Ids) != 1 {
+		t.Fatalf("add: %s", rsp.Status)
+	}
+	return added.Ids[0]
+}
+
+func TestServer_SSE(t *testing.T) {
+	files, daemon, s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	if rsp, err := http.Get(daemon.URL + "/api/events?token=wrong"); err != nil || rsp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong token: %v", err)
+	}
+	rsp, err := http.Get(daemon.URL + "/api/events?tag=night&type=queued,completed&token=" + testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	if rsp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("content type = %s", rsp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan EventMessage, 10)
+	go func() {
+		sc := bufio.NewScanner(rsp.Body)
+		for sc.Scan() {
+			if strings.HasPrefix(sc.Text(), "data: ") {
+				var msg EventMessage
+				json.Unmarshal([]byte(strings.TrimPrefix(sc.Text(), "data: ")), &msg)
+				events <- msg
+			}
+		}
+	}()
+
+	addJob(t, daemon.URL, `{"url": "`+files.URL+`/day.bin"}`)
+	id := addJob(t, daemon.URL, `{"url": "`+files.URL+`/night.bin", "options": {"tags": ["night"]}}`)
+	s.Manager().Wait()
+
+	for _, want := range []string{"queued", "completed"} {
+		select {
+		case msg := <-events:
+			if msg.Type != want || msg.JobID != id || msg.Tags[0] != "night" {
+				t.Fatalf("event = %+v, want %s", msg, want)
+			}
+			if want == "completed" && (msg.Stats == nil || msg.Stats.BytesDone != 300<<10 || !strings.HasSuffix(msg.File, "night.bin")) {
+				t.Fatalf("completed = %+v", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", want)
+		}
+	}
+}
+
+// wsClient 测试用的WebSocket客户端
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, addr, path string) *wsClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: "+addr+"\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+key+"\r\nAuthorization: Bearer "+testSecret+"\r\n\r\n")
+	br := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if rsp.StatusCode != http.StatusSwitchingProtocols || rsp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("handshake: %s %v", rsp.Status, rsp.Header)
+	}
+	return &wsClient{conn: conn, br: br}
+}
+
+// write 发送带掩码的帧
+func (c *wsClient) write(op byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+func (c *wsClient) read(t *testing.T) (byte, []byte) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := int(h[1] & 0x7f)
+	if n == 126 {
+		var b [2]byte
+		io.ReadFull(c.br, b[:])
+		n = int(binary.BigEndian.Uint16(b[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return h[0] & 0x0f, payload
+}
+
+func TestServer_WebSocket(t *testing.T) {
+	files, daemon, s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	c := dialWebSocket(t, strings.TrimPrefix(daemon.URL, "http://"), "/api/events?type=queued")
+	defer c.conn.Close()
+
+	// 替换过滤条件；pong在过滤条件生效之后返回
+	c.write(opText, []byte(`{"tags": ["b"], "types": ["completed"]}`))
+	c.write(opPing, []byte("x"))
+	if op, payload := c.read(t); op != opPong || string(payload) != "x" {
+		t.Fatalf("op = %x, payload = %q", op, payload)
+	}
+
+	addJob(t, daemon.URL, `{"url": "`+files.URL+`/a.bin", "options": {"tags": ["a"]}}`)
+	id := addJob(t, daemon.URL, `{"url": "`+files.URL+`/b.bin", "options": {"tags": ["b"]}}`)
+	s.Manager().Wait()
+
+	op, payload := c.read(t)
+	var msg EventMessage
+	json.Unmarshal(payload, &msg)
+	if op != opText || msg.Type != "completed" || msg.JobID != id {
+		t.Fatalf("op = %x, event = %s", op, payload)
+	}
+
+	c.write(opClose, []byte{0x03, 0xe8})
+	if op, _ = c.read(t); op != opClose {
+		t.Fatalf("op = %x, want close", op)
+	}
+}
@@ -0,0 +1,219 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+// REST接口：
+//
+//	GET    /api/jobs             所有任务，?tag=TAG只列出带有该标签的任务
+//	POST   /api/jobs             添加任务，{"url": "...", "options": {...}} 或 {"urls": [...], "options": {...}}
+//	GET    /api/jobs/ID          单个任务
+//	PATCH  /api/jobs/ID          修改任务选项，只修改请求中出现的字段
+//	DELETE /api/jobs/ID          删除任务
+//	POST   /api/jobs/ID/pause    暂停
+//	POST   /api/jobs/ID/resume   恢复
+//	POST   /api/jobs/ID/retry    重新下载失败的任务
+//	GET    /api/stats            汇总，见GlobalStats
+//	GET    /api/options          全局选项，见GlobalOptions
+//	PATCH  /api/options          修改全局选项
+
+// Job 任务的JSON表示
+type Job struct {
+	task.JobStatus
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+func newJob(s task.JobStatus) Job {
+	j := Job{JobStatus: s, State: s.State.String()}
+	if s.Err != nil {
+		j.Error = s.Err.Error()
+	}
+	return j
+}
+
+// addRequest POST /api/jobs的请求体
+type addRequest struct {
+	Url     string          `json:"url"`
+	Urls    []string        `json:"urls"`
+	Options json.RawMessage `json:"options"` // task.Options，没有的字段使用默认值
+	Headers []string        `json:"headers"` // 'Name: value'
+}
+
+func (s *Server) serveJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tag := r.URL.Query().Get("tag")
+		jobs := []Job{}
+		for _, st := range s.m.Jobs() {
+			if tag == "" || contains(st.Options.Tags, tag) {
+				jobs = append(jobs, newJob(st))
+			}
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		var req addRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		urls := req.Urls
+		if req.Url != "" {
+			urls = append([]string{req.Url}, urls...)
+		}
+		if len(urls) == 0 {
+			writeError(w, http.StatusBadRequest, errors.New("url is required"))
+			return
+		}
+		opts := s.opts.Defaults
+		if len(req.Options) > 0 {
+			if err = json.Unmarshal(req.Options, &opts); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		if err = s.checkPaths(&opts); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(urls) > 1 && opts.FileName != "" {
+			writeError(w, http.StatusBadRequest, errors.New("file_name can only be used with a single url"))
+			return
+		}
+		auth, err := s.withHeader(req.Headers)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		opts.Auth = auth
+
+		ids := make([]uint64, len(urls))
+		for i, u := range urls {
+			ids[i] = s.m.Add(u, opts).ID
+		}
+		writeJSON(w, http.StatusCreated, map[string][]uint64{"ids": ids})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// serveJob /api/jobs/ID[/pause|/resume|/retry]
+func (s *Server) serveJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, task.ErrJobNotFound)
+		return
+	}
+
+	if len(parts) == 2 {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		switch parts[1] {
+		case "pause":
+			err = s.m.Pause(id)
+		case "resume":
+			err = s.m.Resume(id)
+		case "retry":
+			err = s.m.Retry(id)
+		default:
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", parts[1]))
+			return
+		}
+		s.writeJob(w, id, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJob(w, id, nil)
+	case http.MethodPatch:
+		st, err := s.m.Job(id)
+		if err != nil {
+			writeError(w, statusCode(err), err)
+			return
+		}
+		opts := st.Options
+		if err = json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err = s.checkPaths(&opts); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.writeJob(w, id, s.m.SetOptions(id, opts))
+	case http.MethodDelete:
+		if err = s.m.Remove(id); err != nil {
+			writeError(w, statusCode(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// writeJob 操作成功时输出任务的最新状态
+func (s *Server) writeJob(w http.ResponseWriter, id uint64, err error) {
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	st, err := s.m.Job(id)
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newJob(st))
+}
+
+func (s *Server) serveStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Stats())
+}
+
+func (s *Server) serveOptions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var o GlobalOptions
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.SetGlobalOptions(o); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.GlobalOptions())
+}
+
+// statusCode Manager返回的错误对应的HTTP状态码
+func statusCode(err error) int {
+	switch err {
+	case task.ErrJobNotFound:
+		return http.StatusNotFound
+	case task.ErrJobFinished, task.ErrJobStarting, task.ErrJobNotFailed, task.ErrPauseUnsupported:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,744 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/azd1997/blockchair_downloader/config"
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+// JSON-RPC 2.0的错误码；aria2的方法本身出错时使用1
+const (
+	codeParse          = -32700
+	codeInvalidRequest = -32600
+	codeNoMethod       = -32601
+	codeInvalidParams  = -32602
+	codeFailed         = 1
+)
+
+// maxRequestSize JSON-RPC请求体的上限
+const maxRequestSize = 4 << 20
+
+type rpcRequest struct {
+	Version string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+func invalidParams(format string, a ...interface{}) error {
+	return &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf(format, a...)}
+}
+
+// rpcMethod 去掉token之后的参数
+type rpcMethod func(s *Server, params []json.RawMessage) (interface{}, error)
+
+// methods aria2兼容的方法，gid为任务编号的16位十六进制
+var methods map[string]rpcMethod
+
+func init() {
+	methods = map[string]rpcMethod{
+		"aria2.addUri":               (*Server).addUri,
+		"aria2.remove":               (*Server).remove,
+		"aria2.forceRemove":          (*Server).remove,
+		"aria2.pause":                (*Server).pause,
+		"aria2.forcePause":           (*Server).pause,
+		"aria2.pauseAll":             (*Server).pauseAll,
+		"aria2.forcePauseAll":        (*Server).pauseAll,
+		"aria2.unpause":              (*Server).unpause,
+		"aria2.unpauseAll":           (*Server).unpauseAll,
+		"aria2.tellStatus":           (*Server).tellStatus,
+		"aria2.getUris":              (*Server).getUris,
+		"aria2.getFiles":             (*Server).getFiles,
+		"aria2.tellActive":           (*Server).tellActive,
+		"aria2.tellWaiting":          (*Server).tellWaiting,
+		"aria2.tellStopped":          (*Server).tellStopped,
+		"aria2.getOption":            (*Server).getOption,
+		"aria2.changeOption":         (*Server).changeOption,
+		"aria2.getGlobalOption":      (*Server).getGlobalOption,
+		"aria2.changeGlobalOption":   (*Server).changeGlobalOption,
+		"aria2.getGlobalStat":        (*Server).getGlobalStat,
+		"aria2.removeDownloadResult": (*Server).removeDownloadResult,
+		"aria2.purgeDownloadResult":  (*Server).purgeDownloadResult,
+		"aria2.getVersion":           (*Server).getVersion,
+		"aria2.getSessionInfo":       (*Server).getSessionInfo,
+		"aria2.shutdown":             (*Server).shutdown,
+		"aria2.forceShutdown":        (*Server).shutdown,
+		"system.multicall":           (*Server).multicall,
+		"system.listMethods":         (*Server).listMethods,
+	}
+}
+
+// sessionID 进程启动时生成，aria2.getSessionInfo返回
+var sessionID = func() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}()
+
+func (s *Server) serveRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, rpcResponse{Version: "2.0", Error: &rpcError{codeParse, err.Error()}})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.handleRPC(body))
+}
+
+// handleRPC 处理单个或批量(JSON数组)请求，返回对应的响应
+func (s *Server) handleRPC(body []byte) interface{} {
+	if b := strings.TrimSpace(string(body)); strings.HasPrefix(b, "[") {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return rpcResponse{Version: "2.0", Error: &rpcError{codeParse, err.Error()}}
+		}
+		if len(batch) == 0 {
+			return rpcResponse{Version: "2.0", Error: &rpcError{codeInvalidRequest, "empty batch"}}
+		}
+		rsps := make([]rpcResponse, len(batch))
+		for i, req := range batch {
+			rsps[i] = s.call(req)
+		}
+		return rsps
+	}
+	return s.call(body)
+}
+
+// call 处理一个请求
+func (s *Server) call(body []byte) rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return rpcResponse{Version: "2.0", Error: &rpcError{codeParse, err.Error()}}
+	}
+	rsp := rpcResponse{Version: "2.0", ID: req.ID}
+	result, err := s.invoke(req.Method, req.Params)
+	if err != nil {
+		rsp.Error = toRPCError(err)
+	} else {
+		rsp.Result = result
+	}
+	return rsp
+}
+
+// invoke 检查token后调用方法，system.*不需要token
+func (s *Server) invoke(method string, params []json.RawMessage) (interface{}, error) {
+	fn, ok := methods[method]
+	if !ok {
+		return nil, &rpcError{codeNoMethod, "method not found: " + method}
+	}
+	var token string
+	if len(params) > 0 {
+		if json.Unmarshal(params[0], &token) == nil && strings.HasPrefix(token, "token:") {
+			params = params[1:]
+		} else {
+			token = ""
+		}
+	}
+	if !strings.HasPrefix(method, "system.") && !s.checkSecret(strings.TrimPrefix(token, "token:")) {
+		return nil, &rpcError{codeFailed, "Unauthorized"}
+	}
+	return fn(s, params)
+}
+
+func toRPCError(err error) *rpcError {
+	var re *rpcError
+	if errors.As(err, &re) {
+		return re
+	}
+	return &rpcError{codeFailed, err.Error()}
+}
+
+///////////////////////// 参数 ///////////////////////////
+
+// param 解析第i个参数，不存在时保持v不变；required为true时不存在视为错误
+func param(params []json.RawMessage, i int, v interface{}, required bool) error {
+	if i >= len(params) {
+		if required {
+			return invalidParams("missing param #%d", i+1)
+		}
+		return nil
+	}
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return invalidParams("param #%d: %v", i+1, err)
+	}
+	return nil
+}
+
+// gidParam 第i个参数为gid
+func gidParam(params []json.RawMessage, i int) (uint64, error) {
+	var gid string
+	if err := param(params, i, &gid, true); err != nil {
+		return 0, err
+	}
+	return parseGID(gid)
+}
+
+func formatGID(id uint64) string {
+	return fmt.Sprintf("%016x", id)
+}
+
+func parseGID(gid string) (uint64, error) {
+	id, err := strconv.ParseUint(gid, 16, 64)
+	if err != nil || len(gid) != 16 {
+		return 0, &rpcError{codeFailed, fmt.Sprintf("GID %s is not found", gid)}
+	}
+	return id, nil
+}
+
+// stringList aria2的选项值可以是字符串或字符串数组（如header）
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, e := range v {
+			list = append(list, fmt.Sprint(e))
+		}
+		return list
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+// applyOptions 将aria2格式的选项写入opts，不支持的选项忽略
+// 支持dir、out、checksum、header、max-tries、piece-length、allow-overwrite，以及扩展的priority、weight
+func (s *Server) applyOptions(opts *task.Options, o map[string]interface{}) error {
+	var headers []string
+	for k, v := range o {
+		value := fmt.Sprint(v)
+		var err error
+		switch k {
+		case "dir":
+			if opts.Dir, err = s.resolveDir(value); err != nil {
+				return invalidParams("option dir: %v", err)
+			}
+		case "out":
+			if opts.FileName, err = cleanFileName(value); err != nil {
+				return invalidParams("option out: %v", err)
+			}
+		case "checksum":
+			opts.Checksum = value
+		case "header":
+			headers = stringList(v)
+		case "max-tries":
+			opts.MaxTries, err = strconv.Atoi(value)
+		case "piece-length":
+			opts.ChunkSize, err = config.ParseBytes(value)
+		case "allow-overwrite":
+			opts.Overwrite = value == "true"
+		case "priority":
+			opts.Priority, err = strconv.Atoi(value)
+		case "weight":
+			opts.Weight, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return invalidParams("option %s: invalid value %q", k, value)
+		}
+	}
+	if len(headers) > 0 {
+		auth, err := s.withHeader(headers)
+		if err != nil {
+			return invalidParams("option header: %v", err)
+		}
+		opts.Auth = auth
+	}
+	return nil
+}
+
+///////////////////////// 任务 ///////////////////////////
+
+// addUri(uris, [options], [position])，uris中是同一个文件的镜像，只使用第一个
+func (s *Server) addUri(params []json.RawMessage) (interface{}, error) {
+	var uris []string
+	if err := param(params, 0, &uris, true); err != nil {
+		return nil, err
+	}
+	if len(uris) == 0 {
+		return nil, invalidParams("no uri")
+	}
+	var o map[string]interface{}
+	if err := param(params, 1, &o, false); err != nil {
+		return nil, err
+	}
+	opts := s.opts.Defaults
+	if err := s.applyOptions(&opts, o); err != nil {
+		return nil, err
+	}
+	return formatGID(s.m.Add(uris[0], opts).ID), nil
+}
+
+// gidCall 以gid为唯一参数的方法
+func (s *Server) gidCall(params []json.RawMessage, fn func(uint64) error) (interface{}, error) {
+	id, err := gidParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = fn(id); err != nil {
+		return nil, err
+	}
+	return formatGID(id), nil
+}
+
+func (s *Server) remove(params []json.RawMessage) (interface{}, error) {
+	return s.gidCall(params, s.m.Remove)
+}
+
+func (s *Server) pause(params []json.RawMessage) (interface{}, error) {
+	return s.gidCall(params, s.m.Pause)
+}
+
+func (s *Server) unpause(params []json.RawMessage) (interface{}, error) {
+	return s.gidCall(params, s.m.Resume)
+}
+
+// pauseAll 暂停所有排队中和下载中的任务，个别任务失败（如不支持暂停）时忽略
+func (s *Server) pauseAll(params []json.RawMessage) (interface{}, error) {
+	for _, j := range s.m.Jobs() {
+		if j.State == task.JobQueued || j.State == task.JobActive {
+			s.m.Pause(j.ID)
+		}
+	}
+	return "OK", nil
+}
+
+func (s *Server) unpauseAll(params []json.RawMessage) (interface{}, error) {
+	for _, j := range s.m.Jobs() {
+		if j.State == task.JobPaused {
+			s.m.Resume(j.ID)
+		}
+	}
+	return "OK", nil
+}
+
+func (s *Server) removeDownloadResult(params []json.RawMessage) (interface{}, error) {
+	id, err := gidParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	j, err := s.m.Job(id)
+	if err != nil {
+		return nil, err
+	}
+	if j.State != task.JobCompleted && j.State != task.JobFailed {
+		return nil, fmt.Errorf("could not remove download result of GID#%s", formatGID(id))
+	}
+	if err = s.m.Remove(id); err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
+
+func (s *Server) purgeDownloadResult(params []json.RawMessage) (interface{}, error) {
+	s.m.Purge()
+	return "OK", nil
+}
+
+///////////////////////// 状态 ///////////////////////////
+
+// keysParam 第i个参数为要返回的字段，没有时返回全部
+func keysParam(params []json.RawMessage, i int) ([]string, error) {
+	var keys []string
+	err := param(params, i, &keys, false)
+	return keys, err
+}
+
+func (s *Server) tellStatus(params []json.RawMessage) (interface{}, error) {
+	id, err := gidParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := keysParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := s.m.Job(id)
+	if err != nil {
+		return nil, err
+	}
+	return filterKeys(status(j), keys), nil
+}
+
+func (s *Server) getUris(params []json.RawMessage) (interface{}, error) {
+	id, err := gidParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	j, err := s.m.Job(id)
+	if err != nil {
+		return nil, err
+	}
+	return status(j)["files"].([]map[string]interface{})[0]["uris"], nil
+}
+
+func (s *Server) getFiles(params []json.RawMessage) (interface{}, error) {
+	id, err := gidParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	j, err := s.m.Job(id)
+	if err != nil {
+		return nil, err
+	}
+	return status(j)["files"], nil
+}
+
+func (s *Server) tellActive(params []json.RawMessage) (interface{}, error) {
+	keys, err := keysParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.list(keys, func(st task.JobState) bool { return st == task.JobActive }, 0, -1)
+}
+
+// tellWaiting(offset, num, [keys])，包括排队中和暂停的任务
+func (s *Server) tellWaiting(params []json.RawMessage) (interface{}, error) {
+	return s.tellRange(params, func(st task.JobState) bool { return st == task.JobQueued || st == task.JobPaused })
+}
+
+// tellStopped(offset, num, [keys])，包括已完成和失败的任务
+func (s *Server) tellStopped(params []json.RawMessage) (interface{}, error) {
+	return s.tellRange(params, func(st task.JobState) bool { return st == task.JobCompleted || st == task.JobFailed })
+}
+
+func (s *Server) tellRange(params []json.RawMessage, match func(task.JobState) bool) (interface{}, error) {
+	var offset, num int
+	if err := param(params, 0, &offset, true); err != nil {
+		return nil, err
+	}
+	if err := param(params, 1, &num, true); err != nil {
+		return nil, err
+	}
+	keys, err := keysParam(params, 2)
+	if err != nil {
+		return nil, err
+	}
+	return s.list(keys, match, offset, num)
+}
+
+// list 按提交顺序列出匹配的任务；offset为负数时从末尾倒数，并按倒序返回（与aria2相同）；num<0表示全部
+func (s *Server) list(keys []string, match func(task.JobState) bool, offset, num int) (interface{}, error) {
+	var jobs []task.JobStatus
+	for _, j := range s.m.Jobs() {
+		if match(j.State) {
+			jobs = append(jobs, j)
+		}
+	}
+	if offset < 0 {
+		offset = len(jobs) + offset
+		if offset < 0 {
+			return []map[string]interface{}{}, nil
+		}
+		// 倒序：从offset开始往前
+		reversed := make([]task.JobStatus, 0, offset+1)
+		for i := offset; i >= 0; i-- {
+			reversed = append(reversed, jobs[i])
+		}
+		jobs, offset = reversed, 0
+	}
+	if offset > len(jobs) {
+		offset = len(jobs)
+	}
+	jobs = jobs[offset:]
+	if num >= 0 && num < len(jobs) {
+		jobs = jobs[:num]
+	}
+	result := make([]map[string]interface{}, len(jobs))
+	for i, j := range jobs {
+		result[i] = filterKeys(status(j), keys)
+	}
+	return result, nil
+}
+
+// aria2Status 任务状态对应的aria2状态
+func aria2Status(st task.JobState) string {
+	switch st {
+	case task.JobQueued:
+		return "waiting"
+	case task.JobActive:
+		return "active"
+	case task.JobPaused:
+		return "paused"
+	case task.JobCompleted:
+		return "complete"
+	}
+	return "error"
+}
+
+// errorCode 失败原因对应的aria2错误码：3资源不存在，24认证失败，32校验和不符，其他为1
+func errorCode(err error) string {
+	var (
+		se *task.StatusError
+		ce *task.ChecksumError
+	)
+	switch {
+	case errors.As(err, &ce):
+		return "32"
+	case errors.As(err, &se) && (se.Code == http.StatusNotFound || se.Code == http.StatusGone):
+		return "3"
+	case errors.As(err, &se) && (se.Code == http.StatusUnauthorized || se.Code == http.StatusForbidden):
+		return "24"
+	}
+	return "1"
+}
+
+// status aria2.tellStatus格式的任务状态，数值都是字符串
+func status(j task.JobStatus) map[string]interface{} {
+	itoa := func(n int64) string { return strconv.FormatInt(n, 10) }
+	total := j.Stats.BytesTotal
+	if total < 0 {
+		total = 0
+	}
+	done := j.Stats.BytesDone
+	if j.State == task.JobCompleted && j.Stats.BytesTotal < 0 {
+		total = done
+	}
+	speed := int64(j.Stats.Speed)
+	if j.State != task.JobActive {
+		speed = 0
+	}
+
+	path := j.File
+	if path == "" && j.Options.FileName != "" {
+		path = filepath.Join(j.Options.Dir, j.Options.FileName)
+	}
+	dir := j.Options.Dir
+	if dir == "" && path != "" {
+		dir = filepath.Dir(path)
+	}
+	uriStatus := "waiting"
+	if j.State == task.JobActive {
+		uriStatus = "used"
+	}
+
+	st := map[string]interface{}{
+		"gid":             formatGID(j.ID),
+		"status":          aria2Status(j.State),
+		"totalLength":     itoa(total),
+		"completedLength": itoa(done),
+		"uploadLength":    "0",
+		"downloadSpeed":   itoa(speed),
+		"uploadSpeed":     "0",
+		"connections":     strconv.Itoa(j.Stats.ActiveConns),
+		"numPieces":       itoa(j.Stats.ChunksTotal),
+		"pieceLength":     itoa(j.Options.ChunkSize),
+		"dir":             dir,
+		"files": []map[string]interface{}{{
+			"index":           "1",
+			"path":            path,
+			"length":          itoa(total),
+			"completedLength": itoa(done),
+			"selected":        "true",
+			"uris":            []map[string]string{{"uri": j.Url, "status": uriStatus}},
+		}},
+	}
+	if j.State == task.JobFailed && j.Err != nil {
+		st["errorCode"] = errorCode(j.Err)
+		st["errorMessage"] = j.Err.Error()
+	}
+	return st
+}
+
+// filterKeys 只保留keys中的字段，keys为空时全部保留
+func filterKeys(st map[string]interface{}, keys []string) map[string]interface{} {
+	if len(keys) == 0 {
+		return st
+	}
+	out := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		if v, ok := st[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+///////////////////////// 选项 ///////////////////////////
+
+// taskOptions 任务选项的aria2格式
+func taskOptions(o task.Options) map[string]string {
+	m := map[string]string{
+		"dir":             o.Dir,
+		"out":             o.FileName,
+		"allow-overwrite": strconv.FormatBool(o.Overwrite),
+		"priority":        strconv.Itoa(o.Priority),
+		"weight":          strconv.Itoa(o.Weight),
+	}
+	if o.Checksum != "" {
+		m["checksum"] = o.Checksum
+	}
+	if o.MaxTries > 0 {
+		m["max-tries"] = strconv.Itoa(o.MaxTries)
+	}
+	if o.ChunkSize > 0 {
+		m["piece-length"] = strconv.FormatInt(o.ChunkSize, 10)
+	}
+	return m
+}
+
+func (s *Server) getOption(params []json.RawMessage) (interface{}, error) {
+	id, err := gidParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	j, err := s.m.Job(id)
+	if err != nil {
+		return nil, err
+	}
+	return taskOptions(j.Options), nil
+}
+
+// changeOption(gid, options)，下载中的任务只有priority、weight生效，见task.Manager.SetOptions
+func (s *Server) changeOption(params []json.RawMessage) (interface{}, error) {
+	id, err := gidParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	var o map[string]interface{}
+	if err = param(params, 1, &o, true); err != nil {
+		return nil, err
+	}
+	j, err := s.m.Job(id)
+	if err != nil {
+		return nil, err
+	}
+	opts := j.Options
+	if err = s.applyOptions(&opts, o); err != nil {
+		return nil, err
+	}
+	if err = s.m.SetOptions(id, opts); err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
+
+// getGlobalOption 全局选项，max-downloaders是扩展的选项（下载器数）
+func (s *Server) getGlobalOption(params []json.RawMessage) (interface{}, error) {
+	g := s.GlobalOptions()
+	m := taskOptions(s.opts.Defaults)
+	m["max-concurrent-downloads"] = strconv.Itoa(g.MaxActive)
+	m["max-overall-download-limit"] = strconv.FormatInt(g.RateLimit, 10)
+	m["max-downloaders"] = strconv.Itoa(g.MaxDownloaders)
+	return m, nil
+}
+
+// changeGlobalOption 支持max-concurrent-downloads、max-overall-download-limit（0为不限速）和max-downloaders
+func (s *Server) changeGlobalOption(params []json.RawMessage) (interface{}, error) {
+	var o map[string]interface{}
+	if err := param(params, 0, &o, true); err != nil {
+		return nil, err
+	}
+	var g GlobalOptions
+	for k, v := range o {
+		value := fmt.Sprint(v)
+		var err error
+		switch k {
+		case "max-concurrent-downloads":
+			g.MaxActive, err = strconv.Atoi(value)
+		case "max-downloaders":
+			g.MaxDownloaders, err = strconv.Atoi(value)
+		case "max-overall-download-limit":
+			if g.RateLimit, err = config.ParseBytes(value); err == nil && g.RateLimit == 0 {
+				g.RateLimit = -1
+			}
+		}
+		if err != nil {
+			return nil, invalidParams("option %s: invalid value %q", k, value)
+		}
+	}
+	if err := s.SetGlobalOptions(g); err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
+
+func (s *Server) getGlobalStat(params []json.RawMessage) (interface{}, error) {
+	g := s.Stats()
+	return map[string]string{
+		"downloadSpeed":   strconv.FormatInt(int64(g.Pool.Speed), 10),
+		"uploadSpeed":     "0",
+		"numActive":       strconv.Itoa(g.Active),
+		"numWaiting":      strconv.Itoa(g.Queued + g.Paused),
+		"numStopped":      strconv.Itoa(g.Completed + g.Failed),
+		"numStoppedTotal": strconv.Itoa(g.Completed + g.Failed),
+	}, nil
+}
+
+///////////////////////// 其他 ///////////////////////////
+
+func (s *Server) getVersion(params []json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{"version": Version, "enabledFeatures": []string{}}, nil
+}
+
+func (s *Server) getSessionInfo(params []json.RawMessage) (interface{}, error) {
+	return map[string]string{"sessionId": sessionID}, nil
+}
+
+func (s *Server) shutdown(params []json.RawMessage) (interface{}, error) {
+	if s.opts.Shutdown == nil {
+		return nil, errors.New("shutdown is disabled")
+	}
+	go s.opts.Shutdown()
+	return "OK", nil
+}
+
+// multicall([{methodName, params}, ...])，成功的结果包在数组中，失败的为{code, message}
+func (s *Server) multicall(params []json.RawMessage) (interface{}, error) {
+	var calls []struct {
+		MethodName string            `json:"methodName"`
+		Params     []json.RawMessage `json:"params"`
+	}
+	if err := param(params, 0, &calls, true); err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, len(calls))
+	for i, c := range calls {
+		if c.MethodName == "system.multicall" {
+			results[i] = &rpcError{codeFailed, "recursive system.multicall forbidden"}
+			continue
+		}
+		r, err := s.invoke(c.MethodName, c.Params)
+		if err != nil {
+			results[i] = toRPCError(err)
+		} else {
+			results[i] = []interface{}{r}
+		}
+	}
+	return results, nil
+}
+
+func (s *Server) listMethods(params []json.RawMessage) (interface{}, error) {
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,21 @@
+package daemon
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// uiFiles 网页界面的静态文件，页面本身不需要令牌，接口请求由页面带上令牌
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler 在/提供网页界面
+func uiHandler() http.Handler {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}
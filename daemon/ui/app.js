@@ -0,0 +1,278 @@
+// godld网页界面：任务列表通过REST接口获取，进度通过/api/events实时更新
+'use strict';
+
+const $ = id => document.getElementById(id);
+const tokenKey = 'godld.token';
+let token = localStorage.getItem(tokenKey) || '';
+let jobs = new Map();   // id -> Job
+let events = null;      // EventSource
+let refreshTimer = null;
+
+// api 调用REST接口，返回解析后的JSON；401时显示令牌输入框
+async function api(method, path, body) {
+  const headers = {};
+  if (token) headers['Authorization'] = 'Bearer ' + token;
+  if (body !== undefined) headers['Content-Type'] = 'application/json';
+  const rsp = await fetch(path, { method, headers, body: body === undefined ? undefined : JSON.stringify(body) });
+  if (rsp.status === 401) {
+    showLogin();
+    throw new Error('令牌错误');
+  }
+  if (rsp.status === 204) return null;
+  const data = await rsp.json();
+  if (!rsp.ok) throw new Error(data.error || rsp.statusText);
+  return data;
+}
+
+function showLogin() {
+  if (events) events.close();
+  events = null;
+  $('app').hidden = true;
+  $('login').hidden = false;
+  $('token').focus();
+}
+
+function message(err) {
+  $('message').textContent = err ? String(err.message || err) : '';
+}
+
+///////////////////////// 格式化 ///////////////////////////
+
+function formatBytes(n) {
+  if (n < 0 || n === undefined) return '?';
+  const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return (i === 0 ? n : n.toFixed(1)) + ' ' + units[i];
+}
+
+// formatDuration ns为Go的time.Duration
+function formatDuration(ns) {
+  if (ns === undefined || ns < 0) return '-';
+  let s = Math.round(ns / 1e9);
+  const h = Math.floor(s / 3600); s %= 3600;
+  const m = Math.floor(s / 60); s %= 60;
+  if (h > 0) return `${h}h${m}m`;
+  if (m > 0) return `${m}m${s}s`;
+  return `${s}s`;
+}
+
+// parseBytes 与config.ParseBytes相同的格式：512K、2M、1.5G
+function parseBytes(s) {
+  const m = /^\s*([0-9.]+)\s*([kmgt]?)(i?b)?\s*$/i.exec(s);
+  if (!m) throw new Error(`无效的大小 "${s}"`);
+  const scale = { '': 1, k: 1 << 10, m: 1 << 20, g: 1 << 30, t: 2 ** 40 }[m[2].toLowerCase()];
+  return Math.round(parseFloat(m[1]) * scale);
+}
+
+// formatLimit 限速的输入格式，整数倍时使用K、M、G
+function formatLimit(n) {
+  if (!(n > 0)) return '0';
+  const units = ['', 'K', 'M', 'G'];
+  let i = 0;
+  while (n % 1024 === 0 && i < units.length - 1) { n /= 1024; i++; }
+  return n + units[i];
+}
+
+function basename(path) {
+  const parts = path.split(/[\\/]/);
+  return parts[parts.length - 1] || path;
+}
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (k === 'class') e.className = v;
+    else if (k.startsWith('on')) e.addEventListener(k.slice(2), v);
+    else e.setAttribute(k, v);
+  }
+  for (const c of children) {
+    if (c !== null && c !== undefined) e.append(c);
+  }
+  return e;
+}
+
+///////////////////////// 任务列表 ///////////////////////////
+
+async function refresh() {
+  try {
+    const list = await api('GET', '/api/jobs');
+    jobs = new Map(list.map(j => [j.id, j]));
+    render();
+    message();
+  } catch (err) {
+    message(err);
+  }
+}
+
+// scheduleRefresh 任务状态变化时合并多个事件后刷新一次
+function scheduleRefresh() {
+  if (refreshTimer) return;
+  refreshTimer = setTimeout(() => { refreshTimer = null; refresh(); }, 300);
+}
+
+function render() {
+  const state = $('filter-state').value;
+  const tag = $('filter-tag').value.trim();
+  const tbody = $('jobs');
+  tbody.textContent = '';
+  let shown = 0;
+  for (const job of [...jobs.values()].reverse()) {
+    if (state && job.state !== state) continue;
+    if (tag && !(job.options.tags || []).includes(tag)) continue;
+    tbody.append(renderJob(job));
+    shown++;
+  }
+  $('empty').hidden = shown > 0;
+  renderSummary();
+}
+
+function renderJob(job) {
+  const st = job.stats || {};
+  const active = job.state === 'active';
+  const name = job.file ? basename(job.file) : (job.options.file_name || basename(job.url.split('?')[0]));
+
+  const total = st.bytes_total > 0 ? st.bytes_total : 0;
+  const pct = total ? Math.min(100, st.bytes_done / total * 100) : (job.state === 'completed' ? 100 : 0);
+  const label = total ? `${pct.toFixed(1)}%  ${formatBytes(st.bytes_done)} / ${formatBytes(total)}` : formatBytes(st.bytes_done || 0);
+
+  const actions = el('td', { class: 'actions' });
+  const action = (text, fn) => actions.append(el('button', { type: 'button', onclick: fn }, text));
+  if (job.state === 'queued' || active) action('暂停', () => act('POST', `/api/jobs/${job.id}/pause`));
+  if (job.state === 'paused') action('继续', () => act('POST', `/api/jobs/${job.id}/resume`));
+  if (job.state === 'failed') action('重试', () => act('POST', `/api/jobs/${job.id}/retry`));
+  action('删除', () => {
+    if (confirm(`删除任务 #${job.id} ${name}？已下载的文件不会删除。`)) act('DELETE', `/api/jobs/${job.id}`);
+  });
+
+  return el('tr', { id: `job-${job.id}` },
+    el('td', null, String(job.id)),
+    el('td', { class: 'name', title: job.file || '' },
+      name,
+      ...(job.options.tags || []).map(t => el('span', { class: 'tag' }, t)),
+      el('div', { class: 'url' }, job.url),
+      job.error ? el('div', { class: 'error' }, job.error) : null),
+    el('td', null, el('span', { class: `state ${job.state}` }, st.paused && active ? 'paused' : job.state)),
+    el('td', null, el('div', { class: `bar ${job.state}` }, el('div', { style: `width:${pct}%` }), el('span', null, label))),
+    el('td', null, active ? formatBytes(st.speed) + '/s' : ''),
+    el('td', null, active ? formatDuration(st.eta) : ''),
+    actions);
+}
+
+function renderSummary() {
+  const count = {};
+  let speed = 0;
+  for (const job of jobs.values()) {
+    count[job.state] = (count[job.state] || 0) + 1;
+    if (job.state === 'active') speed += (job.stats && job.stats.speed) || 0;
+  }
+  $('summary').textContent = `${formatBytes(speed)}/s · 下载中 ${count.active || 0} · 排队 ${count.queued || 0} · ` +
+    `暂停 ${count.paused || 0} · 完成 ${count.completed || 0} · 失败 ${count.failed || 0}`;
+}
+
+async function act(method, path) {
+  try {
+    await api(method, path);
+    scheduleRefresh();
+  } catch (err) {
+    message(err);
+  }
+}
+
+///////////////////////// 事件 ///////////////////////////
+
+// connect 订阅事件：progress只更新对应的行，其他事件重新获取任务列表
+function connect() {
+  if (events) events.close();
+  const types = 'queued,started,progress,paused,resumed,completed,failed,removed';
+  events = new EventSource(`/api/events?type=${types}&token=${encodeURIComponent(token)}`);
+  events.onopen = () => { $('conn').className = 'conn on'; scheduleRefresh(); };
+  events.onerror = () => { $('conn').className = 'conn off'; };
+  events.onmessage = e => {
+    const msg = JSON.parse(e.data);
+    const job = jobs.get(msg.job_id);
+    if (msg.type === 'progress' && job && msg.stats) {
+      job.stats = msg.stats;
+      if (msg.file) job.file = msg.file;
+      const row = $(`job-${job.id}`);
+      if (row) row.replaceWith(renderJob(job));
+      renderSummary();
+      return;
+    }
+    scheduleRefresh();
+  };
+}
+
+///////////////////////// 表单 ///////////////////////////
+
+async function loadOptions() {
+  try {
+    const o = await api('GET', '/api/options');
+    $('opt-active').value = o.max_active;
+    $('opt-downloaders').value = o.max_downloaders;
+    $('opt-limit').value = formatLimit(o.rate_limit);
+  } catch (err) {
+    message(err);
+  }
+}
+
+$('add-form').addEventListener('submit', async e => {
+  e.preventDefault();
+  const urls = $('add-urls').value.split('\n').map(s => s.trim()).filter(s => s && !s.startsWith('#'));
+  if (!urls.length) return;
+  const options = {};
+  if ($('add-dir').value.trim()) options.dir = $('add-dir').value.trim();
+  const tags = $('add-tags').value.split(',').map(s => s.trim()).filter(Boolean);
+  if (tags.length) options.tags = tags;
+  if ($('add-priority').value !== '') options.priority = parseInt($('add-priority').value, 10);
+  try {
+    await api('POST', '/api/jobs', { urls, options });
+    $('add-urls').value = '';
+    message();
+    scheduleRefresh();
+  } catch (err) {
+    message(err);
+  }
+});
+
+$('options-form').addEventListener('submit', async e => {
+  e.preventDefault();
+  try {
+    const limit = parseBytes($('opt-limit').value || '0');
+    await api('PATCH', '/api/options', {
+      max_active: parseInt($('opt-active').value, 10) || 0,
+      max_downloaders: parseInt($('opt-downloaders').value, 10) || 0,
+      rate_limit: limit > 0 ? limit : -1,
+    });
+    message();
+    loadOptions();
+  } catch (err) {
+    message(err);
+  }
+});
+
+$('login-form').addEventListener('submit', e => {
+  e.preventDefault();
+  token = $('token').value;
+  localStorage.setItem(tokenKey, token);
+  start();
+});
+
+$('filter-state').addEventListener('change', render);
+$('filter-tag').addEventListener('input', render);
+
+async function start() {
+  $('login').hidden = true;
+  $('app').hidden = false;
+  await refresh();
+  if ($('app').hidden) return; // 令牌错误
+  loadOptions();
+  connect();
+}
+
+// 没有事件连接时（如经过不支持长连接的代理）定期刷新
+setInterval(() => {
+  if (!$('app').hidden && (!events || events.readyState !== EventSource.OPEN)) refresh();
+}, 5000);
+
+start();
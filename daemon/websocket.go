@@ -0,0 +1,255 @@
+package daemon
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket服务端的最小实现(RFC 6455)：只发送文本消息，收到的文本消息作为过滤条件
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+
+	// maxMessageSize 客户端消息的上限，客户端只会发送过滤条件
+	maxMessageSize = 64 << 10
+	writeTimeout   = 10 * time.Second
+)
+
+var errProtocol = errors.New("websocket: protocol error")
+
+// isWebSocket 是否为WebSocket握手请求
+func isWebSocket(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[name] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn 握手完成后的连接，写操作可以在多个协程中进行
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex
+}
+
+// upgrade 完成握手，失败时已输出错误响应
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, http.StatusBadRequest, errors.New("unsupported websocket version"))
+		return nil, errProtocol
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("websocket unsupported"))
+		return nil, errProtocol
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// writeFrame 发送一个完整的帧，服务端的帧不加掩码
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = header[:4]
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// close 发送关闭帧并关闭连接
+func (c *wsConn) close(code uint16) {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	c.writeFrame(opClose, payload)
+	c.conn.Close()
+}
+
+// readFrame 读取一个帧，客户端的帧必须带掩码
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(c.br, h[:]); err != nil {
+		return
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0f
+	if h[0]&0x70 != 0 || h[1]&0x80 == 0 {
+		return fin, op, nil, errProtocol
+	}
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > maxMessageSize {
+		return fin, op, nil, fmt.Errorf("websocket: frame too large (%d bytes)", n)
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// readMessage 读取下一个文本或二进制消息，处理期间收到的控制帧；对方关闭连接时返回io.EOF
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err = c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return nil, io.EOF
+		case opText, opBinary:
+			if started {
+				return nil, errProtocol
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				return nil, errProtocol
+			}
+		default:
+			return nil, errProtocol
+		}
+		if len(msg)+len(payload) > maxMessageSize {
+			return nil, fmt.Errorf("websocket: message too large")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// serveWebSocket 以WebSocket推送事件
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request, f Filter) {
+	c, err := upgrade(w, r)
+	if err != nil {
+		return
+	}
+	sub := s.events.subscribe(f)
+	defer s.events.unsubscribe(sub)
+
+	// 读协程：处理控制帧和过滤条件，连接断开时通知写协程
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			msg, err := c.readMessage()
+			if err != nil {
+				return
+			}
+			var f Filter
+			if err = json.Unmarshal(msg, &f); err != nil {
+				data, _ := json.Marshal(map[string]string{"error": err.Error()})
+				c.writeFrame(opText, data)
+				continue
+			}
+			s.events.setFilter(sub, f)
+		}
+	}()
+
+	ticker := time.NewTicker(KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case data, ok := <-sub.C:
+			if !ok { // 客户端太慢
+				c.close(1013) // Try Again Later
+				return
+			}
+			err = c.writeFrame(opText, data)
+		case <-ticker.C:
+			err = c.writeFrame(opPing, nil)
+		case <-done:
+			c.conn.Close()
+			return
+		}
+		if err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+}
@@ -1,9 +1,10 @@
 module github.com/azd1997/blockchair_downloader
 
-go 1.15
+go 1.16
 
 require (
 	github.com/azd1997/ego v0.1.0
 	golang.org/x/sys v0.0.0-20200116001909-b77594299b42 // indirect
-	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba // indirect
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,248 @@
+package httpclient
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Credential 一组认证信息，Token非空时使用Bearer认证，否则Username非空时使用Basic认证
+type Credential struct {
+	Username string
+	Password string
+	Token    string
+	Header   http.Header // 额外的请求头，如自定义的X-Api-Key
+}
+
+// CredentialProvider 请求收到401时调用，返回新的凭据（例如刷新过期的token）
+// 返回错误时不再重试，401响应原样返回给调用者
+type CredentialProvider func(req *http.Request, rsp *http.Response) (Credential, error)
+
+// Auth 一个下载任务的请求头、Cookie和认证信息
+// 同一个Auth会被探测请求和多个下载器并发使用，创建后不应再修改导出字段
+type Auth struct {
+	Header     http.Header            // 每个请求都带上的请求头
+	HostHeader map[string]http.Header // 按主机名（小写，不含端口）附加的请求头，覆盖Header中的同名请求头
+	Query      url.Values             // 每个请求都加上的查询参数，如API key；只在发出请求时加上，不会出现在文件名和日志中
+	Jar        http.CookieJar         // 请求带上其中的Cookie，响应的Set-Cookie也会存入，见LoadCookies
+	Netrc      *Netrc                 // 没有设置Credential时按主机名查找登录信息，见LoadNetrc
+
+	Credential Credential         // 初始的凭据
+	Provider   CredentialProvider // 收到401时刷新凭据，为nil时不刷新
+
+	mu  sync.Mutex
+	gen uint64      // 凭据每刷新一次加1
+	cur *Credential // 刷新后的凭据，代替Credential
+}
+
+// Do 设置请求头、Cookie和认证后发出请求
+// 收到401且设置了Provider时刷新凭据并重试一次；a为nil时等同于c.Do(req)
+// 只适合没有请求体的请求(GET/HEAD)，因为重试会重新发送req
+// 返回的*url.Error中的敏感信息已被隐藏，见Redact
+func (a *Auth) Do(c *http.Client, req *http.Request) (*http.Response, error) {
+	if a == nil {
+		rsp, err := c.Do(req)
+		return rsp, redactError(err)
+	}
+	var retry *http.Request
+	if a.Provider != nil {
+		// 重试时在原始请求上重新设置，已经加上的Cookie、请求头不会重复
+		retry = req.Clone(req.Context())
+	}
+	gen := a.apply(req)
+	rsp, err := a.do(c, req)
+	if err != nil || rsp.StatusCode != http.StatusUnauthorized || retry == nil {
+		return rsp, err
+	}
+
+	if err := a.refresh(gen, req, rsp); err != nil {
+		return rsp, nil
+	}
+	rsp.Body.Close()
+	a.apply(retry)
+	return a.do(c, retry)
+}
+
+func (a *Auth) do(c *http.Client, req *http.Request) (*http.Response, error) {
+	rsp, err := c.Do(req)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	if a.Jar != nil {
+		if cookies := rsp.Cookies(); len(cookies) > 0 {
+			a.Jar.SetCookies(req.URL, cookies)
+		}
+	}
+	return rsp, err
+}
+
+// apply 设置请求头、Cookie和认证，返回使用的凭据代数
+func (a *Auth) apply(req *http.Request) uint64 {
+	for k, vs := range a.Header {
+		req.Header.Del(k)
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	for k, vs := range a.HostHeader[strings.ToLower(req.URL.Hostname())] {
+		req.Header.Del(k)
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	if len(a.Query) > 0 {
+		q := req.URL.Query()
+		for k, vs := range a.Query {
+			q[k] = vs
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+	if a.Jar != nil {
+		for _, c := range a.Jar.Cookies(req.URL) {
+			req.AddCookie(c)
+		}
+	}
+
+	a.mu.Lock()
+	cred, gen := a.Credential, a.gen
+	if a.cur != nil {
+		cred = *a.cur
+	}
+	a.mu.Unlock()
+
+	for k, vs := range cred.Header {
+		req.Header.Del(k)
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	switch {
+	case cred.Token != "":
+		req.Header.Set("Authorization", "Bearer "+cred.Token)
+	case cred.Username != "":
+		req.SetBasicAuth(cred.Username, cred.Password)
+	case a.Netrc != nil:
+		if login, password, ok := a.Netrc.Lookup(req.URL.Hostname()); ok {
+			req.SetBasicAuth(login, password)
+		}
+	}
+	return gen
+}
+
+// refresh 调用Provider刷新凭据
+// 多个下载器同时收到401时只刷新一次：如果凭据在请求发出后已经被刷新过，直接用新的凭据重试
+func (a *Auth) refresh(gen uint64, req *http.Request, rsp *http.Response) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.gen != gen {
+		return nil
+	}
+	cred, err := a.Provider(req, rsp)
+	if err != nil {
+		return err
+	}
+	a.cur = &cred
+	a.gen++
+	return nil
+}
+
+// WithHeader 返回附加了h中请求头的副本，a可以为nil
+// 副本与a共享Jar、Netrc和Provider，但凭据的刷新各自进行
+func (a *Auth) WithHeader(h http.Header) *Auth {
+	c := &Auth{Header: http.Header{}}
+	if a != nil {
+		c.Header = a.Header.Clone()
+		if c.Header == nil {
+			c.Header = http.Header{}
+		}
+		c.HostHeader, c.Query, c.Jar, c.Netrc = a.HostHeader, a.Query, a.Jar, a.Netrc
+		c.Credential, c.Provider = a.Credential, a.Provider
+	}
+	for k, vs := range h {
+		for _, v := range vs {
+			c.Header.Add(k, v)
+		}
+	}
+	return c
+}
+
+// ParseHeader 解析 'Name: value' 形式的请求头
+func ParseHeader(s string) (name, value string, err error) {
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return "", "", fmt.Errorf("invalid header %q, want 'Name: value'", s)
+	}
+	return strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]), nil
+}
+
+// AuthFlags 命令行中的请求头、Cookie和认证参数
+type AuthFlags struct {
+	Headers headerFlag
+	Cookies string
+	User    string // user:password
+	Bearer  string
+	Netrc   bool
+}
+
+// RegisterFlags 注册命令行参数
+func (f *AuthFlags) RegisterFlags(fs *flag.FlagSet) {
+	fs.Var(&f.Headers, "H", "额外的请求头，如 -H 'User-Agent: foo'，可重复")
+	fs.StringVar(&f.Cookies, "cookies", f.Cookies, "Netscape格式的cookies.txt")
+	fs.StringVar(&f.User, "user", f.User, "Basic认证的用户名和密码，格式为user:password")
+	fs.StringVar(&f.Bearer, "bearer", f.Bearer, "Bearer认证的token")
+	fs.BoolVar(&f.Netrc, "netrc", f.Netrc, "从.netrc（或$NETRC）读取登录信息")
+}
+
+// Auth 按命令行参数创建Auth，没有设置任何参数时返回nil
+func (f *AuthFlags) Auth() (*Auth, error) {
+	if len(f.Headers) == 0 && f.Cookies == "" && f.User == "" && f.Bearer == "" && !f.Netrc {
+		return nil, nil
+	}
+	a := &Auth{Header: http.Header{}}
+	for _, h := range f.Headers {
+		name, value, err := ParseHeader(h)
+		if err != nil {
+			return nil, err
+		}
+		a.Header.Add(name, value)
+	}
+	if f.Cookies != "" {
+		jar, err := LoadCookies(f.Cookies)
+		if err != nil {
+			return nil, err
+		}
+		a.Jar = jar
+	}
+	if f.User != "" {
+		kv := strings.SplitN(f.User, ":", 2)
+		a.Credential.Username = kv[0]
+		if len(kv) == 2 {
+			a.Credential.Password = kv[1]
+		}
+	}
+	a.Credential.Token = f.Bearer
+	if f.Netrc {
+		n, err := LoadNetrc(DefaultNetrcPath())
+		if err != nil {
+			return nil, err
+		}
+		a.Netrc = n
+	}
+	return a, nil
+}
+
+// headerFlag 可重复的-H参数
+type headerFlag []string
+
+func (h *headerFlag) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlag) Set(v string) error {
+	*h = append(*h, v)
+	return nil
+}
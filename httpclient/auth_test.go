@@ -0,0 +1,295 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echoServer 记录最后一个请求
+func echoServer(t *testing.T, last **http.Request) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*last = r
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, a *Auth, u string) {
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	rsp, err := a.Do(http.DefaultClient, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+}
+
+func TestAuth_Apply(t *testing.T) {
+	var last *http.Request
+	srv := echoServer(t, &last)
+
+	// nil Auth直接发出请求
+	get(t, nil, srv.URL)
+	if last.Header.Get("Authorization") != "" {
+		t.Fatal("nil auth should not add Authorization")
+	}
+
+	jar, _ := ParseCookies(strings.NewReader("127.0.0.1\tFALSE\t/\tFALSE\t0\tid\tabc\n"))
+	a := &Auth{
+		Header:     http.Header{"User-Agent": {"godl-test"}},
+		Jar:        jar,
+		Credential: Credential{Username: "u", Password: "p"},
+	}
+	get(t, a, srv.URL)
+	if user, pass, ok := last.BasicAuth(); !ok || user != "u" || pass != "p" {
+		t.Fatalf("basic auth = %q %q %v", user, pass, ok)
+	}
+	if last.UserAgent() != "godl-test" {
+		t.Fatalf("user agent = %q", last.UserAgent())
+	}
+	if c, err := last.Cookie("id"); err != nil || c.Value != "abc" {
+		t.Fatalf("cookie id: %v %v", c, err)
+	}
+
+	// 响应的Set-Cookie存入Jar，之后的请求会带上
+	get(t, a, srv.URL)
+	if c, err := last.Cookie("session"); err != nil || c.Value != "s1" {
+		t.Fatalf("cookie session: %v %v", c, err)
+	}
+
+	a = &Auth{Credential: Credential{Token: "t0"}}
+	get(t, a, srv.URL)
+	if got := last.Header.Get("Authorization"); got != "Bearer t0" {
+		t.Fatalf("Authorization = %q", got)
+	}
+
+	n, _ := ParseNetrc(strings.NewReader("machine 127.0.0.1 login nu password np\ndefault login du password dp\n"))
+	a = &Auth{Netrc: n}
+	get(t, a, srv.URL)
+	if user, pass, _ := last.BasicAuth(); user != "nu" || pass != "np" {
+		t.Fatalf("netrc auth = %q %q", user, pass)
+	}
+}
+
+func TestAuth_Refresh(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		token = "old"
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ok := r.Header.Get("Authorization") == "Bearer "+token
+		mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	var refreshed int32
+	a := &Auth{
+		Credential: Credential{Token: "old"},
+		Provider: func(req *http.Request, rsp *http.Response) (Credential, error) {
+			atomic.AddInt32(&refreshed, 1)
+			time.Sleep(10 * time.Millisecond) // 让其他请求也收到401
+			return Credential{Token: "new"}, nil
+		},
+	}
+
+	// token过期后多个并发请求收到401，只刷新一次，都用新token重试成功
+	mu.Lock()
+	token = "new"
+	mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+			rsp, err := a.Do(http.DefaultClient, req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rsp.Body.Close()
+			if rsp.StatusCode != http.StatusOK {
+				t.Errorf("status %d", rsp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&refreshed); n != 1 {
+		t.Fatalf("refreshed %d times", n)
+	}
+}
+
+func TestAuth_RefreshCookies(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		cookies [][]string
+		headers [][]string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		cookies = append(cookies, r.Header["Cookie"])
+		headers = append(headers, r.Header["X-A"])
+		mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer new" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s2"})
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	jar, _ := cookiejar.New(nil)
+	u, _ := url.Parse(srv.URL)
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "s1"}})
+	a := &Auth{
+		Header:     http.Header{"X-A": {"a"}},
+		Jar:        jar,
+		Credential: Credential{Token: "old"},
+		Provider: func(req *http.Request, rsp *http.Response) (Credential, error) {
+			return Credential{Token: "new"}, nil
+		},
+	}
+	get(t, a, srv.URL)
+
+	// 重试只带一次Cookie和请求头，Cookie为401响应更新后的值
+	if len(cookies) != 2 || strings.Join(cookies[1], "; ") != "session=s2" || strings.Join(headers[1], ",") != "a" {
+		t.Fatalf("cookies = %q, headers = %q", cookies, headers)
+	}
+}
+
+func TestParseNetrc(t *testing.T) {
+	n, err := ParseNetrc(strings.NewReader(`
+# comment
+machine a.example.com login alice password secret1
+machine b.example.com
+	login bob
+	account x
+	password secret2
+macdef init
+cd /pub
+
+default login anonymous password guest
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct{ host, login, password string }{
+		{"a.example.com", "alice", "secret1"},
+		{"b.example.com", "bob", "secret2"},
+		{"c.example.com", "anonymous", "guest"},
+	}
+	for _, c := range cases {
+		login, password, ok := n.Lookup(c.host)
+		if !ok || login != c.login || password != c.password {
+			t.Errorf("Lookup(%s) = %q %q %v", c.host, login, password, ok)
+		}
+	}
+
+	n, _ = ParseNetrc(strings.NewReader("machine a login x password y"))
+	if _, _, ok := n.Lookup("b"); ok {
+		t.Error("no default entry")
+	}
+}
+
+func TestParseCookies(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	txt := "# Netscape HTTP Cookie File\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tsub\t1\n" +
+		"#HttpOnly_www.example.com\tFALSE\t/dl\tTRUE\t" + strconv.FormatInt(future, 10) + "\tsecure\t2\n" +
+		"www.example.com\tFALSE\t/\tFALSE\t1\texpired\t3\n"
+	jar, err := ParseCookies(strings.NewReader(txt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(raw string) string {
+		u, _ := url.Parse(raw)
+		var ns []string
+		for _, c := range jar.Cookies(u) {
+			ns = append(ns, c.Name)
+		}
+		return strings.Join(ns, ",")
+	}
+	if got := names("https://www.example.com/dl/file"); got != "secure,sub" && got != "sub,secure" {
+		t.Errorf("https /dl cookies = %q", got)
+	}
+	if got := names("http://dl.example.com/"); got != "sub" {
+		t.Errorf("subdomain cookies = %q", got)
+	}
+	if got := names("http://www.example.com/dl/"); got != "sub" {
+		t.Errorf("secure cookie sent over http: %q", got)
+	}
+
+	if _, err := ParseCookies(strings.NewReader("bad line\n")); err == nil {
+		t.Error("malformed line should fail")
+	}
+}
+
+func TestAuthFlags(t *testing.T) {
+	var f AuthFlags
+	if a, err := f.Auth(); a != nil || err != nil {
+		t.Fatalf("empty flags: %v %v", a, err)
+	}
+	f.Headers.Set("X-Api-Key: k")
+	f.User = "u:p:q"
+	a, err := f.Auth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Header.Get("X-Api-Key") != "k" || a.Credential.Username != "u" || a.Credential.Password != "p:q" {
+		t.Fatalf("got %+v", a)
+	}
+	f.Headers.Set("bad")
+	if _, err := f.Auth(); err == nil {
+		t.Fatal("invalid header should fail")
+	}
+}
+
+func TestAuth_WithHeader(t *testing.T) {
+	var last *http.Request
+	srv := echoServer(t, &last)
+
+	a := (*Auth)(nil).WithHeader(http.Header{"X-A": {"1"}})
+	get(t, a, srv.URL)
+	if last.Header.Get("X-A") != "1" {
+		t.Fatalf("X-A = %q", last.Header.Get("X-A"))
+	}
+
+	base := &Auth{Header: http.Header{"X-A": {"0"}}, Credential: Credential{Token: "t"}}
+	a = base.WithHeader(http.Header{"X-B": {"2"}})
+	get(t, a, srv.URL)
+	if last.Header.Get("X-A") != "0" || last.Header.Get("X-B") != "2" || last.Header.Get("Authorization") != "Bearer t" {
+		t.Fatalf("headers = %v", last.Header)
+	}
+	if base.Header.Get("X-B") != "" {
+		t.Fatal("base auth modified")
+	}
+}
+
+func TestAuth_HostHeader(t *testing.T) {
+	var last *http.Request
+	srv := echoServer(t, &last)
+
+	a := &Auth{
+		Header:     http.Header{"X-A": {"0"}, "X-B": {"0"}},
+		HostHeader: map[string]http.Header{"127.0.0.1": {"X-B": {"1"}}, "example.com": {"X-C": {"2"}}},
+	}
+	get(t, a, srv.URL)
+	if last.Header.Get("X-A") != "0" || last.Header.Get("X-B") != "1" || last.Header.Get("X-C") != "" {
+		t.Fatalf("headers = %v", last.Header)
+	}
+	if a.WithHeader(nil).HostHeader == nil {
+		t.Fatal("WithHeader dropped HostHeader")
+	}
+}
@@ -0,0 +1,97 @@
+package httpclient
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpOnlyPrefix curl等工具导出HttpOnly Cookie时加在域名前的前缀
+const httpOnlyPrefix = "#HttpOnly_"
+
+// LoadCookies 读取Netscape格式的cookies.txt（curl、wget和浏览器插件导出的格式），返回CookieJar
+func LoadCookies(path string) (http.CookieJar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseCookies(f)
+}
+
+// ParseCookies 解析Netscape格式的Cookie，每行以tab分隔：
+//
+//	domain  include_subdomains  path  secure  expires  name  value
+//
+// 已过期的Cookie会被忽略，expires为0表示会话Cookie
+func ParseCookies(r io.Reader) (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := bufio.NewScanner(r)
+	lineNo := 0
+	now := time.Now()
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimRight(sc.Text(), "\r")
+		httpOnly := false
+		if strings.HasPrefix(line, httpOnlyPrefix) {
+			line = line[len(httpOnlyPrefix):]
+			httpOnly = true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		f := strings.Split(line, "\t")
+		if len(f) == 6 { // 值为空时有些工具会省略最后的tab
+			f = append(f, "")
+		}
+		if len(f) != 7 {
+			return nil, fmt.Errorf("cookies line %d: want 7 tab separated fields, got %d", lineNo, len(f))
+		}
+		expires, err := strconv.ParseInt(f[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cookies line %d: invalid expires %q", lineNo, f[4])
+		}
+
+		domain := f[0]
+		host := strings.TrimPrefix(domain, ".")
+		secure := strings.EqualFold(f[3], "TRUE")
+		c := &http.Cookie{
+			Name:     f[5],
+			Value:    f[6],
+			Path:     f[2],
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(f[1], "TRUE") || strings.HasPrefix(domain, ".") {
+			c.Domain = host // 带Domain属性的Cookie对子域名也有效
+		}
+		if expires != 0 {
+			c.Expires = time.Unix(expires, 0)
+			if c.Expires.Before(now) {
+				continue
+			}
+		}
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: c.Path}, []*http.Cookie{c})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return jar, nil
+}
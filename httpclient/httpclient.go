@@ -0,0 +1,196 @@
+// Package httpclient 统一配置下载用的HTTP客户端：超时、代理、TLS和连接参数
+// 探测请求(HEAD)和分块请求使用同一份Options，见pool.InitWithClient
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 零值选项使用的默认值
+const (
+	DefaultDialTimeout           = 30 * time.Second
+	DefaultKeepAlive             = 30 * time.Second
+	DefaultTLSHandshakeTimeout   = 10 * time.Second
+	DefaultResponseHeaderTimeout = 60 * time.Second
+	DefaultIdleConnTimeout       = 90 * time.Second
+	DefaultMaxIdleConnsPerHost   = 2
+)
+
+// ProxyDirect 作为Options.Proxy时表示不使用代理（忽略环境变量）
+const ProxyDirect = "direct"
+
+// Options HTTP客户端选项，零值即可使用
+// 时长为0时使用默认值；没有整体的请求超时，因为大分块和直接下载可能需要很久
+type Options struct {
+	DialTimeout           time.Duration `json:"dial_timeout"`            // 建立TCP连接
+	KeepAlive             time.Duration `json:"keep_alive"`              // TCP keep-alive间隔
+	TLSHandshakeTimeout   time.Duration `json:"tls_handshake_timeout"`   // TLS握手
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout"` // 发出请求后等待响应头
+	IdleConnTimeout       time.Duration `json:"idle_conn_timeout"`       // 空闲连接保留时间
+	MaxIdleConnsPerHost   int           `json:"max_idle_conns_per_host"`
+
+	// Proxy 代理地址，支持http://、https://、socks5://
+	// 为空时读取HTTP_PROXY/HTTPS_PROXY/NO_PROXY环境变量，为"direct"时不使用代理
+	Proxy string `json:"proxy"`
+	// HostProxy 按主机名（不含端口）指定的代理，优先于Proxy，值同样可以为"direct"
+	HostProxy map[string]string `json:"host_proxy"`
+
+	CAFile   string `json:"ca_file"`   // 额外信任的CA证书(PEM)，追加到系统证书之后
+	CertFile string `json:"cert_file"` // 客户端证书(PEM)，需要同时设置KeyFile
+	KeyFile  string `json:"key_file"`
+	Insecure bool   `json:"insecure"` // 不校验服务端证书
+
+	DisableHTTP2 bool `json:"disable_http2"`
+}
+
+// RegisterFlags 注册命令行参数，写入o
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Proxy, "proxy", o.Proxy, "代理地址(http://、https://、socks5://)，默认读取HTTP_PROXY等环境变量，direct表示不使用代理")
+	fs.DurationVar(&o.DialTimeout, "connect-timeout", o.DialTimeout, "建立连接的超时时间（默认30s）")
+	fs.DurationVar(&o.ResponseHeaderTimeout, "header-timeout", o.ResponseHeaderTimeout, "等待响应头的超时时间（默认60s）")
+	fs.StringVar(&o.CAFile, "cacert", o.CAFile, "额外信任的CA证书文件(PEM)")
+	fs.StringVar(&o.CertFile, "cert", o.CertFile, "客户端证书文件(PEM)")
+	fs.StringVar(&o.KeyFile, "cert-key", o.KeyFile, "客户端证书私钥文件(PEM)")
+	fs.BoolVar(&o.Insecure, "insecure", o.Insecure, "不校验服务端证书")
+	fs.BoolVar(&o.DisableHTTP2, "no-http2", o.DisableHTTP2, "禁用HTTP/2")
+}
+
+// Transport 按选项创建http.Transport
+// 返回的Transport可以用Clone复制，给每个下载器独立的连接池
+func (o Options) Transport() (*http.Transport, error) {
+	proxy, err := o.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   or(o.DialTimeout, DefaultDialTimeout),
+		KeepAlive: or(o.KeepAlive, DefaultKeepAlive),
+	}
+	tr := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   or(o.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: or(o.ResponseHeaderTimeout, DefaultResponseHeaderTimeout),
+		IdleConnTimeout:       or(o.IdleConnTimeout, DefaultIdleConnTimeout),
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   o.MaxIdleConnsPerHost,
+		ForceAttemptHTTP2:     !o.DisableHTTP2,
+	}
+	if tr.MaxIdleConnsPerHost <= 0 {
+		tr.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	if o.DisableHTTP2 {
+		// TLSNextProto非nil且为空时不会升级到HTTP/2
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return tr, nil
+}
+
+// Client 按选项创建http.Client
+func (o Options) Client() (*http.Client, error) {
+	tr, err := o.Transport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: tr}, nil
+}
+
+func (o Options) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	def, err := proxyFunc(o.Proxy)
+	if err != nil || len(o.HostProxy) == 0 {
+		return def, err
+	}
+	hosts := make(map[string]func(*http.Request) (*url.URL, error), len(o.HostProxy))
+	for host, p := range o.HostProxy {
+		if hosts[strings.ToLower(host)], err = proxyFunc(p); err != nil {
+			return nil, fmt.Errorf("%s: %v", host, err)
+		}
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		f, ok := hosts[strings.ToLower(req.URL.Hostname())]
+		if !ok {
+			f = def
+		}
+		if f == nil {
+			return nil, nil
+		}
+		return f(req)
+	}, nil
+}
+
+func proxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case ProxyDirect:
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", proxy, err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: unsupported scheme", proxy)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", proxy)
+	}
+	return http.ProxyURL(u), nil
+}
+
+func (o Options) tlsConfig() (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: o.Insecure}
+
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		c.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, errors.New("client certificate needs both cert and key file")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+func or(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
@@ -0,0 +1,138 @@
+package httpclient
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Netrc .netrc文件中的登录信息
+type Netrc struct {
+	machines map[string]netrcEntry
+	def      *netrcEntry // default条目
+}
+
+type netrcEntry struct {
+	login    string
+	password string
+}
+
+// DefaultNetrcPath 默认的.netrc路径：$NETRC，否则为用户目录下的.netrc（Windows下为_netrc）
+func DefaultNetrcPath() string {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// LoadNetrc 读取.netrc文件
+func LoadNetrc(path string) (*Netrc, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseNetrc(f)
+}
+
+// ParseNetrc 解析.netrc格式：machine/login/password/default，忽略account和macdef
+func ParseNetrc(r io.Reader) (*Netrc, error) {
+	n := &Netrc{machines: map[string]netrcEntry{}}
+
+	var tokens []string
+	sc := bufio.NewScanner(r)
+	inMacro := false
+	for sc.Scan() {
+		line := sc.Text()
+		if inMacro { // macdef以空行结束
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i, f := range fields {
+			if f == "macdef" {
+				fields = fields[:i]
+				inMacro = true
+				break
+			}
+		}
+		tokens = append(tokens, fields...)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		machine string
+		entry   *netrcEntry
+	)
+	flush := func() {
+		if entry == nil {
+			return
+		}
+		if machine == "" {
+			if n.def == nil {
+				n.def = entry
+			}
+		} else if _, ok := n.machines[machine]; !ok { // 同一主机以第一个为准
+			n.machines[machine] = *entry
+		}
+		entry = nil
+	}
+	for i := 0; i < len(tokens); i++ {
+		next := func() string {
+			if i+1 < len(tokens) {
+				i++
+				return tokens[i]
+			}
+			return ""
+		}
+		switch tokens[i] {
+		case "machine":
+			flush()
+			machine, entry = next(), &netrcEntry{}
+		case "default":
+			flush()
+			machine, entry = "", &netrcEntry{}
+		case "login":
+			if entry != nil {
+				entry.login = next()
+			}
+		case "password":
+			if entry != nil {
+				entry.password = next()
+			}
+		case "account":
+			next()
+		}
+	}
+	flush()
+	return n, nil
+}
+
+// Lookup 按主机名查找登录信息，没有对应的machine时使用default
+func (n *Netrc) Lookup(host string) (login, password string, ok bool) {
+	if n == nil {
+		return "", "", false
+	}
+	if e, found := n.machines[host]; found {
+		return e.login, e.password, true
+	}
+	if n.def != nil {
+		return n.def.login, n.def.password, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,60 @@
+package httpclient
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Redacted 代替敏感信息的文本
+const Redacted = "REDACTED"
+
+// SensitiveParams 打印时需要隐藏值的查询参数，不区分大小写
+var SensitiveParams = []string{
+	"key", "apikey", "api_key", "token", "access_token", "auth", "password", "secret", "signature", "sig",
+}
+
+// Redact 隐藏url中的密码和敏感查询参数（如blockchair的key），用于日志和错误信息
+// 无法解析的url原样返回
+func Redact(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), Redacted)
+	}
+	if u.RawQuery != "" {
+		parts := strings.Split(u.RawQuery, "&")
+		for i, p := range parts {
+			kv := strings.SplitN(p, "=", 2)
+			name, err := url.QueryUnescape(kv[0])
+			if err != nil {
+				name = kv[0]
+			}
+			if len(kv) == 2 && sensitive(name) {
+				parts[i] = kv[0] + "=" + Redacted
+			}
+		}
+		u.RawQuery = strings.Join(parts, "&")
+	}
+	return u.String()
+}
+
+func sensitive(name string) bool {
+	for _, s := range SensitiveParams {
+		if strings.EqualFold(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactError 隐藏*url.Error中的url，http.Client返回的错误会带上完整的请求地址
+func redactError(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		ue.URL = Redact(ue.URL)
+	}
+	return err
+}
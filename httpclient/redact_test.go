@@ -0,0 +1,53 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"https://gz.blockchair.com/a.tsv.gz", "https://gz.blockchair.com/a.tsv.gz"},
+		{"https://gz.blockchair.com/a.tsv.gz?key=abc", "https://gz.blockchair.com/a.tsv.gz?key=REDACTED"},
+		{"https://h/p?page=2&API_KEY=x&token=y", "https://h/p?page=2&API_KEY=REDACTED&token=REDACTED"},
+		{"https://u:pw@h/p", "https://u:REDACTED@h/p"},
+		{"https://u@h/p?key", "https://u@h/p?key"},
+	}
+	for _, c := range cases {
+		if got := Redact(c.in); got != c.want {
+			t.Errorf("Redact(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestAuth_Query(t *testing.T) {
+	var got url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+	}))
+	defer srv.Close()
+
+	a := &Auth{Query: url.Values{"key": {"secret"}}}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/f.gz?page=1", nil)
+	rsp, err := a.Do(http.DefaultClient, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if got.Get("key") != "secret" || got.Get("page") != "1" {
+		t.Fatalf("query = %v", got)
+	}
+
+	// 请求失败时错误信息中不包含key
+	srv.Close()
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/f.gz", nil)
+	_, err = a.Do(http.DefaultClient, req)
+	var ue *url.Error
+	if !errors.As(err, &ue) || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package listing
+
+import (
+	"html"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	anchorRe = regexp.MustCompile(`(?is)<a\s[^>]*?href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))[^>]*>`)
+	tagRe    = regexp.MustCompile(`(?s)<[^>]*>`)
+	sizeRe   = regexp.MustCompile(`(?i)^(\d+(?:\.\d+)?)([KMGTP]?)(?:i?B)?$`)
+)
+
+// timeLayouts 各服务器索引页的时间格式
+var timeLayouts = []string{
+	"02-Jan-2006 15:04",    // nginx、Apache(pre)
+	"02-Jan-2006 15:04:05", // nginx
+	"2006-01-02 15:04",     // Apache(table)
+	"2006-01-02 15:04:05",
+	"2006-Jan-02 15:04:05", // lighttpd
+}
+
+// parseHTML 每个链接之后到行尾（或</tr>）的文本里依次是修改时间和大小，
+// 不同服务器的格式差别只在于时间和大小的写法，因此不区分服务器统一处理
+func parseHTML(page string, base *url.URL) []Entry {
+	var (
+		entries []Entry
+		index   = make(map[string]int)
+	)
+	matches := anchorRe.FindAllStringSubmatchIndex(page, -1)
+	for i, m := range matches {
+		href := html.UnescapeString(submatch(page, m, 1) + submatch(page, m, 2) + submatch(page, m, 3))
+		e, ok := child(base, strings.TrimSpace(href))
+		if !ok {
+			continue
+		}
+
+		tail := page[m[1]:]
+		if i+1 < len(matches) {
+			tail = page[m[1]:matches[i+1][0]]
+		}
+		tail = rowTail(tail)
+		parseColumns(&e, strings.Fields(html.UnescapeString(tagRe.ReplaceAllString(tail, " "))))
+
+		// 图标和文件名可能是指向同一地址的两个链接，合并
+		if j, ok := index[e.Url]; ok {
+			merge(&entries[j], e)
+			continue
+		}
+		index[e.Url] = len(entries)
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func submatch(s string, m []int, n int) string {
+	if m[2*n] < 0 {
+		return ""
+	}
+	return s[m[2*n]:m[2*n+1]]
+}
+
+// rowTail 链接所在行的剩余部分：表格格式到</tr>，<pre>格式到行尾
+func rowTail(s string) string {
+	// 链接文字本身可能被截断（nginx的name..>），跳过</a>之前的内容
+	if i := strings.Index(strings.ToLower(s), "</a>"); i >= 0 {
+		s = s[i+len("</a>"):]
+	}
+	end := len(s)
+	if i := strings.Index(strings.ToLower(s), "</tr>"); i >= 0 {
+		end = i
+	} else if i := strings.IndexByte(s, '\n'); i >= 0 {
+		end = i
+	}
+	return s[:end]
+}
+
+// parseColumns 从链接后的各列中找出时间、大小以及lighttpd的类型列
+func parseColumns(e *Entry, fields []string) {
+	for i := 0; i < len(fields); i++ {
+		if e.ModTime.IsZero() && i+1 < len(fields) {
+			if t, ok := parseTime(fields[i] + " " + fields[i+1]); ok {
+				e.ModTime = t
+				i++
+				continue
+			}
+		}
+		if fields[i] == "Directory" {
+			e.Dir = true
+			continue
+		}
+		if e.Size < 0 && !e.ModTime.IsZero() {
+			if size, approx, ok := parseSize(fields[i]); ok {
+				e.Size, e.Approx = size, approx
+			}
+		}
+	}
+	if e.Dir {
+		e.Size, e.Approx = -1, false
+	}
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseSize 解析123456、12K、1.5M、3GiB这样的大小，带单位的按1024进位，是近似值
+func parseSize(s string) (size int64, approx bool, ok bool) {
+	m := sizeRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false, false
+	}
+	if m[2] == "" {
+		n, err := strconv.ParseInt(m[1], 10, 64)
+		return n, false, err == nil
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false, false
+	}
+	exp := strings.Index("KMGTP", strings.ToUpper(m[2])) + 1
+	return int64(f * math.Pow(1024, float64(exp))), true, true
+}
+
+// merge 用后出现的同一链接补全缺少的信息
+func merge(dst *Entry, src Entry) {
+	if dst.ModTime.IsZero() {
+		dst.ModTime = src.ModTime
+	}
+	if dst.Size < 0 {
+		dst.Size, dst.Approx = src.Size, src.Approx
+	}
+	dst.Dir = dst.Dir || src.Dir
+}
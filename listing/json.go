@@ -0,0 +1,42 @@
+package listing
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// jsonEntry nginx autoindex_format json的一项
+type jsonEntry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"` // file、directory、other
+	MTime string `json:"mtime"`
+	Size  *int64 `json:"size"`
+}
+
+func parseJSON(r io.Reader, base *url.URL) ([]Entry, error) {
+	var list []jsonEntry
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(list))
+	for _, j := range list {
+		href := (&url.URL{Path: j.Name}).EscapedPath()
+		if j.Type == "directory" {
+			href += "/"
+		}
+		e, ok := child(base, "./"+href) // 以./开头，避免名字中的:被当作scheme
+		if !ok {
+			continue
+		}
+		if j.Size != nil && !e.Dir {
+			e.Size = *j.Size
+		}
+		if t, err := http.ParseTime(j.MTime); err == nil {
+			e.ModTime = t
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,126 @@
+// Package listing 解析HTTP服务器的目录索引页，得到目录下的文件名、大小和修改时间
+//
+// 支持Apache(mod_autoindex)、nginx(autoindex)、lighttpd(mod_dirlisting)的HTML索引页，
+// 以及nginx的JSON索引(autoindex_format json)。只返回目录的直接子项，
+// 上级目录、排序链接和指向其他主机的链接都会被忽略。
+package listing
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+)
+
+// MaxSize 索引页的最大字节数，超出部分不解析
+const MaxSize = 64 << 20
+
+// Entry 目录中的一项
+type Entry struct {
+	Name    string    // 文件名（已解码），目录不带结尾的/
+	Url     string    // 完整地址，目录以/结尾
+	Dir     bool      // 是否为子目录
+	Size    int64     // 文件大小，未知时为-1
+	Approx  bool      // Size是否为近似值，如Apache显示的12M
+	ModTime time.Time // 修改时间，未知时为零值；HTML索引页没有时区，按UTC处理
+}
+
+// Exact 是否有精确的文件大小，可用于校验下载结果
+func (e Entry) Exact() bool {
+	return e.Size >= 0 && !e.Approx
+}
+
+// Fetch 下载并解析rawurl的目录索引，auth可以为nil
+// rawurl不以/结尾时自动补上，重定向后以最终地址解析相对链接
+func Fetch(c *http.Client, auth *httpclient.Auth, rawurl string) ([]Entry, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html, application/json;q=0.9")
+	rsp, err := auth.Do(c, req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("GET %s: %s", httpclient.Redact(u.String()), rsp.Status)
+	}
+	return Parse(io.LimitReader(rsp.Body, MaxSize), rsp.Request.URL, rsp.Header.Get("Content-Type"))
+}
+
+// Parse 解析索引页，base为索引页的地址，用于解析相对链接
+// contentType为空时根据内容判断是JSON还是HTML
+func Parse(r io.Reader, base *url.URL, contentType string) ([]Entry, error) {
+	br := bufio.NewReader(r)
+	if isJSON(br, contentType) {
+		return parseJSON(br, base)
+	}
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	return parseHTML(string(data), base), nil
+}
+
+func isJSON(br *bufio.Reader, contentType string) bool {
+	if strings.Contains(contentType, "json") {
+		return true
+	}
+	if strings.Contains(contentType, "html") {
+		return false
+	}
+	head, _ := br.Peek(512)
+	head = bytes.TrimSpace(head)
+	return len(head) > 0 && head[0] == '['
+}
+
+// child 将href解析为base目录的直接子项，不是子项时返回false
+func child(base *url.URL, href string) (Entry, bool) {
+	if href == "" || strings.HasPrefix(href, "?") || strings.HasPrefix(href, "#") {
+		return Entry{}, false
+	}
+	ref, err := url.Parse(href)
+	if err != nil || ref.RawQuery != "" {
+		return Entry{}, false
+	}
+	u := base.ResolveReference(ref)
+	u.Fragment = ""
+	if u.Scheme != base.Scheme || u.Host != base.Host {
+		return Entry{}, false
+	}
+	dir := base.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir) + "/"
+	}
+	rest := strings.TrimPrefix(u.Path, dir)
+	if rest == u.Path || rest == "" || strings.Contains(strings.TrimSuffix(rest, "/"), "/") {
+		return Entry{}, false
+	}
+	// u.Path已经解码，%2e%2e、%2f等编码的上级目录和分隔符在这里才能识别出来
+	name := strings.TrimSuffix(rest, "/")
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return Entry{}, false
+	}
+	return Entry{
+		Name: name,
+		Url:  u.String(),
+		Dir:  strings.HasSuffix(rest, "/"),
+		Size: -1,
+	}, true
+}
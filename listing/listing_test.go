@@ -0,0 +1,145 @@
+package listing
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const base = "http://example.com/bitcoin/inputs/"
+
+func parseFile(t *testing.T, name string) []Entry {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	u, _ := url.Parse(base)
+	entries, err := Parse(f, u, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestParse(t *testing.T) {
+	mtime := func(min, sec int) time.Time { return time.Date(2021, 3, 16, 1, min, sec, 0, time.UTC) }
+	dirTime := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	const gz = "blockchair_bitcoin_inputs_20210315.tsv.gz"
+
+	cases := []struct {
+		file string
+		want []Entry
+	}{
+		{"apache.html", []Entry{
+			{Name: "old", Url: base + "old/", Dir: true, Size: -1, ModTime: dirTime},
+			{Name: gz, Url: base + gz, Size: 123 << 20, Approx: true, ModTime: mtime(2, 0)},
+			{Name: "a b.gz", Url: base + "a%20b.gz", Size: 512, ModTime: mtime(3, 0)},
+		}},
+		{"nginx.html", []Entry{
+			{Name: "old", Url: base + "old/", Dir: true, Size: -1, ModTime: dirTime},
+			{Name: gz, Url: base + gz, Size: 128974848, ModTime: mtime(2, 0)},
+			{Name: "a b.gz", Url: base + "a%20b.gz", Size: 512, ModTime: mtime(3, 0)},
+		}},
+		{"lighttpd.html", []Entry{
+			{Name: "old", Url: base + "old/", Dir: true, Size: -1, ModTime: dirTime},
+			{Name: gz, Url: base + gz, Size: 123 << 20, Approx: true, ModTime: mtime(2, 0)},
+			{Name: "a b.gz", Url: base + "a%20b.gz", Size: 512, Approx: true, ModTime: mtime(3, 0)},
+		}},
+		{"nginx.json", []Entry{
+			{Name: "old", Url: base + "old/", Dir: true, Size: -1, ModTime: dirTime},
+			{Name: gz, Url: base + gz, Size: 128974848, ModTime: mtime(2, 0)},
+			{Name: "a b.gz", Url: base + "a%20b.gz", Size: 512, ModTime: mtime(3, 0)},
+		}},
+	}
+	for _, c := range cases {
+		got := parseFile(t, c.file)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %d entries: %+v", c.file, len(got), got)
+			continue
+		}
+		for i := range got {
+			if g, w := got[i], c.want[i]; g.Name != w.Name || g.Url != w.Url || g.Dir != w.Dir ||
+				g.Size != w.Size || g.Approx != w.Approx || !g.ModTime.Equal(w.ModTime) {
+				t.Errorf("%s[%d]:\n got %+v\nwant %+v", c.file, i, g, w)
+			}
+		}
+	}
+}
+
+func TestParse_DotSegments(t *testing.T) {
+	u, _ := url.Parse(base)
+	page := `<a href="%2e%2e/">up</a> <a href="%2e%2e">up</a> <a href="%2E/">here</a> <a href="..%2fx">x</a>` +
+		`<a href="a%5c..%5cb">b</a> <a href="ok.gz">ok</a>`
+	entries, err := Parse(strings.NewReader(page), u, "text/html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "ok.gz" {
+		t.Fatalf("entries = %+v", entries)
+	}
+
+	entries, err = Parse(strings.NewReader(`[{"name":"..","type":"directory"},{"name":"a\\b","type":"file"}]`), u, "application/json")
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("json: %+v %v", entries, err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		s      string
+		size   int64
+		approx bool
+		ok     bool
+	}{
+		{"512", 512, false, true},
+		{"1.5K", 1536, true, true},
+		{"2M", 2 << 20, true, true},
+		{"3GiB", 3 << 30, true, true},
+		{"-", 0, false, false},
+		{"12X", 0, false, false},
+	}
+	for _, c := range cases {
+		size, approx, ok := parseSize(c.s)
+		if size != c.size || approx != c.approx || ok != c.ok {
+			t.Errorf("parseSize(%q) = %d %v %v", c.s, size, approx, ok)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "nginx.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bitcoin/inputs/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(data)
+	})
+	mux.HandleFunc("/missing/", http.NotFound)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// 不带结尾的/也按目录处理
+	entries, err := Fetch(http.DefaultClient, nil, srv.URL+"/bitcoin/inputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[1].Url != srv.URL+"/bitcoin/inputs/blockchair_bitcoin_inputs_20210315.tsv.gz" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if !entries[1].Exact() || entries[0].Exact() {
+		t.Fatal("Exact")
+	}
+
+	if _, err := Fetch(http.DefaultClient, nil, srv.URL+"/missing/?key=secret"); err == nil {
+		t.Fatal("want 404 error")
+	}
+}
@@ -0,0 +1,309 @@
+// Package logging 分级、结构化的日志
+//
+// Logger的方法与log/slog的*slog.Logger相同，args为交替的键值对，*slog.Logger可以直接作为Logger使用。
+// pool、task等包的日志默认不输出（Discard），由使用者通过它们的SetLogger设置：
+//
+//	l := logging.NewJSON(os.Stderr, logging.LevelInfo)
+//	pool.SetLogger(l)
+//	task.SetLogger(l)
+//	l.Warn("chunk failed", "range", "0-4095", "attempt", 2, "err", err)
+package logging
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// Logger 分级日志，args为交替的键值对，如 "attempt", 2, "err", err
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Level 日志级别，取值与slog相同
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseLevel 解析debug、info、warn(warning)、error，不区分大小写
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Discard 不输出任何日志
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Debug(string, ...interface{}) {}
+func (discard) Info(string, ...interface{})  {}
+func (discard) Warn(string, ...interface{})  {}
+func (discard) Error(string, ...interface{}) {}
+
+// With 返回每条日志都附加args的Logger
+func With(l Logger, args ...interface{}) Logger {
+	if len(args) == 0 || l == Discard {
+		return l
+	}
+	switch x := l.(type) {
+	case *logger:
+		return &logger{out: x.out, attrs: concat(x.attrs, args)}
+	case *with:
+		return &with{l: x.l, attrs: concat(x.attrs, args)}
+	}
+	return &with{l: l, attrs: args}
+}
+
+// with 为其他实现（如*slog.Logger）附加字段
+type with struct {
+	l     Logger
+	attrs []interface{}
+}
+
+func (w *with) Debug(msg string, args ...interface{}) { w.l.Debug(msg, concat(w.attrs, args)...) }
+func (w *with) Info(msg string, args ...interface{})  { w.l.Info(msg, concat(w.attrs, args)...) }
+func (w *with) Warn(msg string, args ...interface{})  { w.l.Warn(msg, concat(w.attrs, args)...) }
+func (w *with) Error(msg string, args ...interface{}) { w.l.Error(msg, concat(w.attrs, args)...) }
+
+func concat(a, b []interface{}) []interface{} {
+	if len(b) == 0 {
+		return a
+	}
+	s := make([]interface{}, 0, len(a)+len(b))
+	return append(append(s, a...), b...)
+}
+
+///////////////////////// 输出 ///////////////////////////
+
+// TimeFormat 日志中的时间格式
+const TimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// NewText 输出为 key=value 格式的Logger，与slog.TextHandler相同：
+//
+//	time=2021-03-15T08:00:00.000+08:00 level=WARN msg="chunk failed" range=0-4095 attempt=2
+func NewText(w io.Writer, level Level) Logger {
+	return &logger{out: &output{w: w, level: level}}
+}
+
+// NewJSON 每行一个JSON对象的Logger，与slog.JSONHandler相同：
+//
+//	{"time":"2021-03-15T08:00:00.000+08:00","level":"WARN","msg":"chunk failed","range":"0-4095","attempt":2}
+//
+// error、time.Duration等输出为字符串
+func NewJSON(w io.Writer, level Level) Logger {
+	return &logger{out: &output{w: w, level: level, json: true}}
+}
+
+type output struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+	json  bool
+}
+
+type logger struct {
+	out   *output
+	attrs []interface{}
+}
+
+func (l *logger) Debug(msg string, args ...interface{}) { l.log(LevelDebug, msg, args) }
+func (l *logger) Info(msg string, args ...interface{})  { l.log(LevelInfo, msg, args) }
+func (l *logger) Warn(msg string, args ...interface{})  { l.log(LevelWarn, msg, args) }
+func (l *logger) Error(msg string, args ...interface{}) { l.log(LevelError, msg, args) }
+
+func (l *logger) log(level Level, msg string, args []interface{}) {
+	if level < l.out.level {
+		return
+	}
+	var b strings.Builder
+	now := time.Now().Format(TimeFormat)
+	if l.out.json {
+		b.WriteString(`{"time":`)
+		writeJSON(&b, now)
+		b.WriteString(`,"level":`)
+		writeJSON(&b, level.String())
+		b.WriteString(`,"msg":`)
+		writeJSON(&b, msg)
+		eachAttr(l.attrs, args, func(k string, v interface{}) {
+			b.WriteByte(',')
+			writeJSON(&b, k)
+			b.WriteByte(':')
+			writeJSON(&b, value(v))
+		})
+		b.WriteString("}\n")
+	} else {
+		b.WriteString("time=" + now + " level=" + level.String() + " msg=")
+		writeText(&b, msg)
+		eachAttr(l.attrs, args, func(k string, v interface{}) {
+			b.WriteByte(' ')
+			writeText(&b, k)
+			b.WriteByte('=')
+			writeText(&b, value(v))
+		})
+		b.WriteByte('\n')
+	}
+
+	l.out.mu.Lock()
+	io.WriteString(l.out.w, b.String())
+	l.out.mu.Unlock()
+}
+
+// eachAttr 依次取出键值对，缺少值的最后一个参数键为!BADKEY（与slog相同）
+func eachAttr(attrs, args []interface{}, fn func(k string, v interface{})) {
+	for _, list := range [][]interface{}{attrs, args} {
+		for i := 0; i < len(list); i += 2 {
+			k, ok := list[i].(string)
+			if !ok || i+1 == len(list) {
+				fn("!BADKEY", list[i])
+				i--
+				continue
+			}
+			fn(k, list[i+1])
+		}
+	}
+}
+
+// value 把error、Stringer等转为字符串，其他值原样输出
+func value(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return x.Error()
+	case time.Time:
+		return x.Format(TimeFormat)
+	case fmt.Stringer:
+		return x.String()
+	}
+	return v
+}
+
+func writeJSON(b *strings.Builder, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+// writeText 含有空白、引号、=或不可打印字符的值加引号
+func writeText(b *strings.Builder, v interface{}) {
+	s, ok := v.(string)
+	if !ok {
+		s = fmt.Sprint(v)
+	}
+	if needsQuote(s) {
+		s = strconv.Quote(s)
+	}
+	b.WriteString(s)
+}
+
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+///////////////////////// 包级别的Logger ///////////////////////////
+
+// Var 可以并发替换的Logger，用于包级别的日志，零值为Discard
+type Var struct {
+	v atomic.Value // holder
+}
+
+type holder struct{ Logger }
+
+// Set 替换Logger，nil等同于Discard
+func (v *Var) Set(l Logger) {
+	if l == nil {
+		l = Discard
+	}
+	v.v.Store(holder{l})
+}
+
+// Get 当前的Logger
+func (v *Var) Get() Logger {
+	if h, ok := v.v.Load().(holder); ok {
+		return h.Logger
+	}
+	return Discard
+}
+
+func (v *Var) Debug(msg string, args ...interface{}) { v.Get().Debug(msg, args...) }
+func (v *Var) Info(msg string, args ...interface{})  { v.Get().Info(msg, args...) }
+func (v *Var) Warn(msg string, args ...interface{})  { v.Get().Warn(msg, args...) }
+func (v *Var) Error(msg string, args ...interface{}) { v.Get().Error(msg, args...) }
+
+///////////////////////// 命令行参数 ///////////////////////////
+
+// Flags 日志相关的命令行参数
+type Flags struct {
+	Level string // debug、info、warn、error，off不输出
+	JSON  bool
+}
+
+func (f *Flags) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&f.Level, "log-level", f.Level, "日志级别：debug、info、warn、error，off不输出（默认info）")
+	fs.BoolVar(&f.JSON, "log-json", f.JSON, "日志输出为JSON，每行一条")
+}
+
+// Logger 按参数创建输出到w的Logger
+func (f *Flags) Logger(w io.Writer) (Logger, error) {
+	if strings.EqualFold(f.Level, "off") || w == ioutil.Discard {
+		return Discard, nil
+	}
+	level, err := ParseLevel(f.Level)
+	if err != nil {
+		return nil, fmt.Errorf("-log-level: %v", err)
+	}
+	if f.JSON {
+		return NewJSON(w, level), nil
+	}
+	return NewText(w, level), nil
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestText(t *testing.T) {
+	var buf bytes.Buffer
+	l := With(NewText(&buf, LevelInfo), "job", 7)
+	l.Debug("hidden")
+	l.Warn("chunk failed", "range", "0-4095", "err", errors.New("unexpected status: 503 Service Unavailable"), "elapsed", 1500*time.Millisecond, "odd")
+
+	line := buf.String()
+	if strings.Count(line, "\n") != 1 || !strings.HasPrefix(line, "time=") {
+		t.Fatalf("%q", line)
+	}
+	want := ` level=WARN msg="chunk failed" job=7 range=0-4095 err="unexpected status: 503 Service Unavailable" elapsed=1.5s !BADKEY=odd` + "\n"
+	if !strings.HasSuffix(line, want) {
+		t.Fatalf("got  %q\nwant %q", line, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := With(With(NewJSON(&buf, LevelDebug), "job", 7), "url_hash", "abc")
+	l.Debug("chunk done", "range", "0-4095", "attempt", 2, "err", nil)
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if m["level"] != "DEBUG" || m["msg"] != "chunk done" || m["job"] != 7.0 || m["url_hash"] != "abc" ||
+		m["range"] != "0-4095" || m["attempt"] != 2.0 || m["err"] != nil {
+		t.Fatalf("%s", buf.String())
+	}
+	if _, err := time.Parse(TimeFormat, m["time"].(string)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVar(t *testing.T) {
+	var v Var
+	l := With(&v, "downloader", 1) // 字段在Set之后仍然有效
+	l.Error("dropped")
+
+	var buf bytes.Buffer
+	v.Set(NewText(&buf, LevelError))
+	l.Warn("hidden")
+	l.Error("shown")
+	if !strings.Contains(buf.String(), "msg=shown downloader=1\n") || strings.Contains(buf.String(), "hidden") {
+		t.Fatalf("%q", buf.String())
+	}
+	v.Set(nil)
+	if v.Get() != Discard {
+		t.Fatal("Set(nil) should discard")
+	}
+}
+
+func TestFlags(t *testing.T) {
+	var buf bytes.Buffer
+	for _, c := range []struct {
+		f    Flags
+		want string
+	}{
+		{Flags{}, "level=INFO"},
+		{Flags{Level: "WARNING"}, ""},
+		{Flags{Level: "debug", JSON: true}, `"level":"INFO"`},
+		{Flags{Level: "off"}, ""},
+	} {
+		buf.Reset()
+		l, err := c.f.Logger(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Info("x")
+		if c.want == "" && buf.Len() != 0 || !strings.Contains(buf.String(), c.want) {
+			t.Fatalf("%+v: %q", c.f, buf.String())
+		}
+	}
+	if _, err := (&Flags{Level: "loud"}).Logger(&buf); err == nil {
+		t.Fatal("want error")
+	}
+}
@@ -0,0 +1,407 @@
+// Package metrics 以Prometheus文本格式(0.0.4)输出的指标
+//
+// 只实现了需要的部分：计数器、仪表、直方图（都可以带标签），以及在输出时才计算取值的指标（见Registry.GaugeFunc）。
+// 指标通过Registry创建并登记，包内的指标都登记在Default中：
+//
+//	var bytes = metrics.Default.CounterVec("godl_bytes_total", "下载的字节数", "host")
+//	bytes.With("example.com").Add(4096)
+//	http.Handle("/metrics", metrics.Handler(metrics.Default))
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType 文本格式的Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets 默认的直方图桶（秒），覆盖几毫秒的本地请求到一分钟的慢请求
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Default 默认的Registry，pool、task等包的指标登记在这里
+var Default = NewRegistry()
+
+// Collector 一个指标（同名的所有时间序列）
+type Collector interface {
+	Name() string
+	// Write 按文本格式输出，包括HELP和TYPE行；w的写入错误由Registry处理
+	Write(w io.Writer) error
+}
+
+// Registry 一组指标，指标名不能重复
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: map[string]Collector{}}
+}
+
+// Register 登记指标，名称重复时panic
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.collectors[c.Name()]; ok {
+		panic("metrics: duplicate metric " + c.Name())
+	}
+	r.collectors[c.Name()] = c
+}
+
+// Unregister 删除指标
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	delete(r.collectors, name)
+	r.mu.Unlock()
+}
+
+// WriteText 按名称顺序输出所有指标
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	list := make([]Collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		list = append(list, c)
+	}
+	r.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+
+	bw := bufio.NewWriter(w)
+	for _, c := range list {
+		if err := c.Write(bw); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler 依次输出多个Registry的指标
+func Handler(regs ...*Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		for _, reg := range regs {
+			if err := reg.WriteText(w); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// ListenAndServe 在addr的/metrics输出Default中的指标，阻塞直至出错
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(Default))
+	return http.ListenAndServe(addr, mux)
+}
+
+///////////////////////// 取值 ///////////////////////////
+
+// Counter 只增不减的计数器，并发安全
+type Counter struct {
+	bits uint64
+}
+
+// Add 增加v，v<0时忽略
+func (c *Counter) Add(v float64) {
+	if v > 0 {
+		addFloat(&c.bits, v)
+	}
+}
+
+func (c *Counter) Inc() { c.Add(1) }
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+// Gauge 可增可减的仪表，并发安全
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) { addFloat(&g.bits, v) }
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Histogram 直方图，并发安全
+type Histogram struct {
+	upper  []float64 // 各桶的上界，不含+Inf
+	counts []uint64  // 落在各桶（不累计）的次数，最后一个为+Inf
+	sum    uint64
+	count  uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{upper: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+// Observe 记录一次观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	addFloat(&h.sum, v)
+	atomic.AddUint64(&h.count, 1)
+}
+
+func addFloat(bits *uint64, v float64) {
+	for {
+		old := atomic.LoadUint64(bits)
+		if atomic.CompareAndSwapUint64(bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+///////////////////////// 带标签的指标 ///////////////////////////
+
+// family 同名指标的所有时间序列，按标签值区分
+type family struct {
+	name, help, typ string
+	labels          []string
+
+	mu     sync.RWMutex
+	series map[string]*series
+	create func() interface{}
+}
+
+type series struct {
+	values []string
+	metric interface{} // *Counter、*Gauge或*Histogram
+}
+
+func newFamily(name, help, typ string, labels []string, create func() interface{}) *family {
+	return &family{name: name, help: help, typ: typ, labels: labels, series: map[string]*series{}, create: create}
+}
+
+func (f *family) Name() string { return f.name }
+
+// with 取标签值对应的时间序列，不存在时创建
+func (f *family) with(values []string) interface{} {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s.metric
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok = f.series[key]; !ok {
+		s = &series{values: append([]string(nil), values...), metric: f.create()}
+		f.series[key] = s
+	}
+	return s.metric
+}
+
+func (f *family) delete(values []string) {
+	f.mu.Lock()
+	delete(f.series, strings.Join(values, "\xff"))
+	f.mu.Unlock()
+}
+
+func (f *family) Write(w io.Writer) error {
+	f.mu.RLock()
+	list := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		list = append(list, s)
+	}
+	f.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return lessValues(list[i].values, list[j].values) })
+
+	writeHeader(w, f.name, f.help, f.typ)
+	for _, s := range list {
+		switch m := s.metric.(type) {
+		case *Counter:
+			writeSample(w, f.name, f.labels, s.values, m.Value())
+		case *Gauge:
+			writeSample(w, f.name, f.labels, s.values, m.Value())
+		case *Histogram:
+			writeHistogram(w, f.name, f.labels, s.values, m)
+		}
+	}
+	return nil
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct{ *family }
+
+// CounterVec 创建并登记计数器，没有标签时用With()取唯一的时间序列
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newFamily(name, help, "counter", labels, func() interface{} { return &Counter{} })}
+	r.Register(v)
+	return v
+}
+
+// With 标签值对应的计数器，顺序与创建时的标签名相同
+func (v *CounterVec) With(values ...string) *Counter { return v.with(values).(*Counter) }
+
+// Delete 删除标签值对应的时间序列
+func (v *CounterVec) Delete(values ...string) { v.delete(values) }
+
+// GaugeVec 带标签的仪表
+type GaugeVec struct{ *family }
+
+func (r *Registry) GaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newFamily(name, help, "gauge", labels, func() interface{} { return &Gauge{} })}
+	r.Register(v)
+	return v
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge { return v.with(values).(*Gauge) }
+
+func (v *GaugeVec) Delete(values ...string) { v.delete(values) }
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct{ *family }
+
+// HistogramVec 创建并登记直方图，buckets为递增的桶上界，为nil时使用DefBuckets
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: buckets of " + name + " are not sorted")
+	}
+	v := &HistogramVec{newFamily(name, help, "histogram", labels, func() interface{} { return newHistogram(buckets) })}
+	r.Register(v)
+	return v
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram { return v.with(values).(*Histogram) }
+
+func (v *HistogramVec) Delete(values ...string) { v.delete(values) }
+
+///////////////////////// 输出时计算的指标 ///////////////////////////
+
+// CollectFunc 输出时调用，每个时间序列调用一次emit
+type CollectFunc func(emit func(value float64, labelValues ...string))
+
+type funcCollector struct {
+	name, help, typ string
+	labels          []string
+	fn              CollectFunc
+}
+
+func (c *funcCollector) Name() string { return c.name }
+
+func (c *funcCollector) Write(w io.Writer) error {
+	writeHeader(w, c.name, c.help, c.typ)
+	var err error
+	c.fn(func(value float64, values ...string) {
+		if len(values) != len(c.labels) {
+			err = fmt.Errorf("metrics: %s expects %d label values, got %d", c.name, len(c.labels), len(values))
+			return
+		}
+		writeSample(w, c.name, c.labels, values, value)
+	})
+	return err
+}
+
+// GaugeFunc 登记输出时才计算取值的仪表，如当前的空闲下载器数
+func (r *Registry) GaugeFunc(name, help string, labels []string, fn CollectFunc) {
+	r.Register(&funcCollector{name: name, help: help, typ: "gauge", labels: labels, fn: fn})
+}
+
+// CounterFunc 登记输出时才计算取值的计数器，fn给出的值应只增不减
+func (r *Registry) CounterFunc(name, help string, labels []string, fn CollectFunc) {
+	r.Register(&funcCollector{name: name, help: help, typ: "counter", labels: labels, fn: fn})
+}
+
+///////////////////////// 文本格式 ///////////////////////////
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	if help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, helpEscaper.Replace(help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func writeSample(w io.Writer, name string, labels, values []string, v float64) {
+	io.WriteString(w, name)
+	writeLabels(w, labels, values, "", "")
+	io.WriteString(w, " "+formatFloat(v)+"\n")
+}
+
+func writeHistogram(w io.Writer, name string, labels, values []string, h *Histogram) {
+	var cum uint64
+	for i := range h.counts {
+		cum += atomic.LoadUint64(&h.counts[i])
+		le := "+Inf"
+		if i < len(h.upper) {
+			le = formatFloat(h.upper[i])
+		}
+		io.WriteString(w, name+"_bucket")
+		writeLabels(w, labels, values, "le", le)
+		io.WriteString(w, " "+strconv.FormatUint(cum, 10)+"\n")
+	}
+	io.WriteString(w, name+"_sum")
+	writeLabels(w, labels, values, "", "")
+	io.WriteString(w, " "+formatFloat(math.Float64frombits(atomic.LoadUint64(&h.sum)))+"\n")
+	io.WriteString(w, name+"_count")
+	writeLabels(w, labels, values, "", "")
+	io.WriteString(w, " "+strconv.FormatUint(atomic.LoadUint64(&h.count), 10)+"\n")
+}
+
+// writeLabels 输出{a="x",b="y"}，extra不为空时追加一个标签（直方图的le）
+func writeLabels(w io.Writer, labels, values []string, extra, extraValue string) {
+	if len(labels) == 0 && extra == "" {
+		return
+	}
+	io.WriteString(w, "{")
+	for i, l := range labels {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		io.WriteString(w, l+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if extra != "" {
+		if len(labels) > 0 {
+			io.WriteString(w, ",")
+		}
+		io.WriteString(w, extra+`="`+extraValue+`"`)
+	}
+	io.WriteString(w, "}")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func lessValues(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.CounterVec("test_bytes_total", "下载的\n字节数", "host")
+	c.With("b.com").Add(10)
+	c.With(`a"\.com`).Add(2.5)
+	c.With("b.com").Add(-1) // 忽略
+	r.GaugeVec("test_up", "").With().Set(1)
+	h := r.HistogramVec("test_seconds", "耗时", []float64{0.1, 1}, "op")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.With("set").Observe(v)
+	}
+	r.GaugeFunc("test_downloaders", "下载器数", []string{"state"}, func(emit func(float64, ...string)) {
+		emit(3, "busy")
+		emit(5, "idle")
+	})
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_bytes_total 下载的\n字节数
+# TYPE test_bytes_total counter
+test_bytes_total{host="a\"\\.com"} 2.5
+test_bytes_total{host="b.com"} 10
+# HELP test_downloaders 下载器数
+# TYPE test_downloaders gauge
+test_downloaders{state="busy"} 3
+test_downloaders{state="idle"} 5
+# HELP test_seconds 耗时
+# TYPE test_seconds histogram
+test_seconds_bucket{op="set",le="0.1"} 2
+test_seconds_bucket{op="set",le="1"} 3
+test_seconds_bucket{op="set",le="+Inf"} 4
+test_seconds_sum{op="set"} 3.65
+test_seconds_count{op="set"} 4
+# TYPE test_up gauge
+test_up 1
+`
+	if buf.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	c.Delete("b.com")
+	buf.Reset()
+	r.WriteText(&buf)
+	if strings.Contains(buf.String(), "b.com") {
+		t.Fatal("deleted series still written")
+	}
+}
+
+func TestRegistry_Errors(t *testing.T) {
+	r := NewRegistry()
+	r.CounterVec("test_total", "", "a")
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("duplicate name should panic")
+			}
+		}()
+		r.GaugeVec("test_total", "")
+	}()
+
+	r.GaugeFunc("test_bad", "", []string{"a", "b"}, func(emit func(float64, ...string)) { emit(1, "x") })
+	if err := r.WriteText(&bytes.Buffer{}); err == nil {
+		t.Fatal("label count mismatch should fail")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	a, b := NewRegistry(), NewRegistry()
+	a.CounterVec("a_total", "").With().Inc()
+	b.CounterVec("b_total", "").With().Inc()
+	rec := httptest.NewRecorder()
+	Handler(a, b).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Header().Get("Content-Type") != ContentType || !strings.Contains(rec.Body.String(), "a_total 1\n") ||
+		!strings.Contains(rec.Body.String(), "b_total 1\n") {
+		t.Fatalf("%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,177 @@
+// Package mirror 递归遍历HTTP服务器的目录索引（类似wget -r -np），用于镜像整个目录树
+//
+// 只跟随base之下、同一主机的链接（见listing包），本地按相对base的路径保存。
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/listing"
+)
+
+// SkipDir WalkFunc对目录返回SkipDir时不进入该目录
+var SkipDir = errors.New("skip this directory")
+
+// ErrUnsafePath 名字为.、..或含有路径分隔符，保存时会超出本地目录
+var ErrUnsafePath = errors.New("unsafe path")
+
+// Options 遍历选项
+type Options struct {
+	Depth int // 最大递归深度，0只包括base目录下的文件，<0不限制
+
+	// 文件名或相对路径匹配的glob（path.Match语法），Accept为空时接受所有文件
+	// Reject同样作用于目录
+	Accept []string
+	Reject []string
+
+	// 对相对路径的正则，与glob同时生效
+	AcceptRegex *regexp.Regexp
+	RejectRegex *regexp.Regexp
+}
+
+// File 遍历到的文件或目录
+type File struct {
+	listing.Entry
+	Path  string // 相对base的路径，用/分隔，目录以/结尾
+	Depth int    // 所在目录相对base的深度，base下的为0
+}
+
+// LocalPath 在本地目录out下的保存路径，超出out时返回ErrUnsafePath
+func (f File) LocalPath(out string) (string, error) {
+	root := filepath.Clean(out)
+	local := filepath.Join(root, filepath.FromSlash(strings.TrimSuffix(f.Path, "/")))
+	if local == root || !strings.HasPrefix(local, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: %w", f.Path, ErrUnsafePath)
+	}
+	return local, nil
+}
+
+// safeName 名字能否作为本地路径的一段
+func safeName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
+}
+
+// WalkFunc 每个目录和通过过滤的文件都调用一次
+// 读取目录索引失败时f为该目录、err为错误，返回nil继续遍历其他目录；
+// 名字不安全的项（见ErrUnsafePath）不会遍历或下载，同样以err报告
+// 对目录返回SkipDir跳过该目录，返回其他错误则停止遍历
+type WalkFunc func(f File, err error) error
+
+// Walk 从base目录开始深度优先遍历，c和auth用于读取索引页
+func Walk(c *http.Client, auth *httpclient.Auth, base string, opts Options, fn WalkFunc) error {
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	root := File{Entry: listing.Entry{Url: base, Dir: true, Size: -1}, Depth: -1}
+	w := &walker{client: c, auth: auth, opts: opts, fn: fn}
+	err := w.walk(root)
+	if err == SkipDir {
+		err = nil
+	}
+	return err
+}
+
+type walker struct {
+	client *http.Client
+	auth   *httpclient.Auth
+	opts   Options
+	fn     WalkFunc
+}
+
+func (w *walker) walk(dir File) error {
+	entries, err := listing.Fetch(w.client, w.auth, dir.Url)
+	if err != nil {
+		return w.fn(dir, err)
+	}
+	for _, e := range entries {
+		f := File{Entry: e, Path: dir.Path + e.Name, Depth: dir.Depth + 1}
+		if e.Dir {
+			f.Path += "/"
+		}
+		if !safeName(e.Name) {
+			if err := w.fn(f, fmt.Errorf("%q: %w", f.Path, ErrUnsafePath)); err != nil && err != SkipDir {
+				return err
+			}
+			continue
+		}
+		if !w.match(f) {
+			continue
+		}
+		if !e.Dir {
+			if err := w.fn(f, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if w.opts.Depth >= 0 && f.Depth >= w.opts.Depth {
+			continue
+		}
+		err := w.fn(f, nil)
+		if err == nil {
+			err = w.walk(f)
+		}
+		if err != nil && err != SkipDir {
+			return err
+		}
+	}
+	return nil
+}
+
+// match 目录只检查Reject，文件还要通过Accept
+func (w *walker) match(f File) bool {
+	rel := strings.TrimSuffix(f.Path, "/")
+	if matchAny(w.opts.Reject, f.Name, rel) || (w.opts.RejectRegex != nil && w.opts.RejectRegex.MatchString(rel)) {
+		return false
+	}
+	if f.Dir {
+		return true
+	}
+	if len(w.opts.Accept) > 0 && !matchAny(w.opts.Accept, f.Name, rel) {
+		return false
+	}
+	return w.opts.AcceptRegex == nil || w.opts.AcceptRegex.MatchString(rel)
+}
+
+// matchAny 不含/的glob匹配文件名，含/的匹配相对路径
+func matchAny(globs []string, name, rel string) bool {
+	for _, g := range globs {
+		s := name
+		if strings.Contains(g, "/") {
+			s = rel
+		}
+		if ok, _ := path.Match(g, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// UpToDate 本地文件与远程一致时不必重新下载（类似wget -N）：
+// 大小相同（远程大小为近似值时不比较）并且修改时间不早于远程
+func UpToDate(local string, f File) bool {
+	fi, err := os.Stat(local)
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	if f.Exact() && fi.Size() != f.Size {
+		return false
+	}
+	return !f.ModTime.IsZero() && !fi.ModTime().Before(f.ModTime)
+}
+
+// Touch 下载完成后将文件的修改时间设为远程的修改时间，下次据此判断是否变化
+func Touch(local string, f File) error {
+	if f.ModTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(local, time.Now(), f.ModTime)
+}
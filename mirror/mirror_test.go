@@ -0,0 +1,134 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var modTime = time.Date(2021, 3, 16, 1, 2, 0, 0, time.UTC)
+
+// treeServer 按nginx的格式列出tree中的目录，tree的key为目录路径
+func treeServer(t *testing.T, tree map[string][]string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names, ok := tree[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "<html><body><pre><a href=\"../\">../</a>\n")
+		for _, name := range names {
+			size := "-"
+			if !strings.HasSuffix(name, "/") {
+				size = "3"
+			}
+			fmt.Fprintf(w, "<a href=\"%s\">%s</a>  %s  %s\n", name, name, modTime.Format("02-Jan-2006 15:04"), size)
+		}
+		fmt.Fprintf(w, "<a href=\"http://other.example.com/x\">x</a>\n</pre></body></html>")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func walkPaths(t *testing.T, base string, opts Options, fn WalkFunc) []string {
+	var paths []string
+	err := Walk(http.DefaultClient, nil, base, opts, func(f File, err error) error {
+		if err != nil {
+			paths = append(paths, "ERR "+f.Path)
+			return nil
+		}
+		paths = append(paths, f.Path)
+		if fn != nil {
+			return fn(f, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestWalk(t *testing.T) {
+	srv := treeServer(t, map[string][]string{
+		"/pub/":          {"a.gz", "b.txt", "sub/", "tmp/", "broken/"},
+		"/pub/sub/":      {"c.gz", "deep/"},
+		"/pub/sub/deep/": {"d.gz"},
+		"/pub/tmp/":      {"e.gz"},
+		"/other/":        {"x.gz"},
+	})
+	base := srv.URL + "/pub"
+
+	got := walkPaths(t, base, Options{Depth: -1}, nil)
+	want := []string{"ERR broken/", "a.gz", "b.txt", "broken/", "sub/", "sub/c.gz", "sub/deep/", "sub/deep/d.gz", "tmp/", "tmp/e.gz"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("all:\n got %v\nwant %v", got, want)
+	}
+
+	got = walkPaths(t, base, Options{Depth: 1, Accept: []string{"*.gz"}, Reject: []string{"tmp", "broken"}}, nil)
+	want = []string{"a.gz", "sub/", "sub/c.gz"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("filtered:\n got %v\nwant %v", got, want)
+	}
+
+	got = walkPaths(t, base, Options{Depth: -1, AcceptRegex: regexp.MustCompile(`^sub/`), RejectRegex: regexp.MustCompile(`deep/d`)}, func(f File, err error) error {
+		if f.Path == "tmp/" || f.Path == "broken/" {
+			return SkipDir
+		}
+		return nil
+	})
+	want = []string{"broken/", "sub/", "sub/c.gz", "sub/deep/", "tmp/"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("regex:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestUpToDate(t *testing.T) {
+	dir := t.TempDir()
+	f := File{Path: "sub/a.gz"}
+	f.Size, f.ModTime = 3, modTime
+	local, err := f.LocalPath(dir)
+	if err != nil || local != filepath.Join(dir, "sub", "a.gz") {
+		t.Fatalf("LocalPath = %s, %v", local, err)
+	}
+	for _, p := range []string{"../a.gz", "sub/../../a.gz", "..", "./"} {
+		if local, err := (File{Path: p}).LocalPath(dir); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("LocalPath(%q) = %s, %v", p, local, err)
+		}
+	}
+	if UpToDate(local, f) {
+		t.Fatal("missing file")
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "sub"), nil, 0644)
+	local = filepath.Join(dir, "a.gz")
+	ioutil.WriteFile(local, []byte("abc"), 0644)
+	if err := Touch(local, f); err != nil {
+		t.Fatal(err)
+	}
+	if !UpToDate(local, f) {
+		t.Fatal("same size and time")
+	}
+	f.ModTime = modTime.Add(time.Minute)
+	if UpToDate(local, f) {
+		t.Fatal("remote is newer")
+	}
+	f.ModTime, f.Size = modTime, 4
+	if UpToDate(local, f) {
+		t.Fatal("size changed")
+	}
+	f.Approx = true
+	if !UpToDate(local, f) {
+		t.Fatal("approx size should not be compared")
+	}
+}
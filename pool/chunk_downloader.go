@@ -7,18 +7,30 @@
 package pool
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/azd1997/blockchair_downloader/edb"
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/logging"
 )
 
 const (
 	DefaultCacheSize = 4096	// Byte	与task中的需对应起来
+
+	MaxTries = 10	// 单个分块默认的最多尝试次数，见TaskOptions.MaxTries
+)
+
+var (
+	ErrTooManyTries = errors.New("fail too much times")
+	ErrContentRange = errors.New("Content-Range mismatched")
+	ErrRangeIgnored = errors.New("server ignored Range")
 )
 
 // Chunk 数据块
@@ -34,6 +46,36 @@ type Chunk struct {
 	TaskKey string
 
 	tried int	// 已尝试多少次
+
+	entry *taskEntry	// 提交时所属Task的登记信息，Task注销或重新登记后分块不再下载
+	gen uint64	// 提交时所属Task的暂停代数，见taskEntry
+	ctx context.Context	// 用于在Task暂停时中断下载
+	auth *httpclient.Auth	// 所属Task的认证信息，见TaskOptions.Auth
+	tries int	// 所属Task的最多尝试次数，见TaskOptions.MaxTries
+	host string	// 出队时记录的主机名，见scheduler.done
+	reason string	// 最近一次下载失败的原因，见chunkResults
+	status int	// 最近一次下载失败时服务端返回的状态码，不是状态码错误时为0
+	log logging.Logger	// 带有所属Task字段的日志，见TaskOptions.LogFields
+}
+
+func (c *Chunk) maxTries() int {
+	if c.tries > 0 {
+		return c.tries
+	}
+	return MaxTries
+}
+
+// logger 分块的日志，直接构造的Chunk以url作为字段
+func (c *Chunk) logger() logging.Logger {
+	if c.log != nil {
+		return c.log
+	}
+	return logging.With(&logger, "url", httpclient.Redact(c.Url))
+}
+
+// span 分块的范围，如 0-4095
+func (c *Chunk) span() string {
+	return strconv.FormatInt(c.Begin, 10) + "-" + strconv.FormatInt(c.End, 10)
 }
 
 func (c *Chunk) Valid() bool {
@@ -43,12 +85,11 @@ func (c *Chunk) Valid() bool {
 	return true
 }
 
-func NewChunkDownloader(id int, tryAgain chan <- *Chunk) *ChunkDownloader {
+func NewChunkDownloader(id int) *ChunkDownloader {
 	return &ChunkDownloader{
 		id:        id,
 		status:    StatusIdle,
-		client:    &http.Client{},
-		tryAgain: tryAgain,
+		client:    &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},	// 每个下载器独占连接，不与其他下载器争抢空闲连接
 		//cacheSize: DefaultCacheSize,
 	}
 }
@@ -59,11 +100,11 @@ type ChunkDownloader struct {
 	status Status
 	client *http.Client
 
-	tryAgain chan <- *Chunk	// 下载时遇到错误，就把下载任务（chunk）塞回,tryAgain就是cdp.chunkQueue
 	//cacheSize int			// 缓冲区大小，Byte
 }
 
 // Download 下载开始时状态变为busy，下载结束时变idle
+// 下载失败时返回错误，由调用者决定是否重试；失败次数过多时返回ErrTooManyTries
 func (cd *ChunkDownloader) Download(chunk *Chunk) error {
 
 	var (
@@ -73,18 +114,27 @@ func (cd *ChunkDownloader) Download(chunk *Chunk) error {
 		buf []byte
 		//n int
 		needSize int64
+		reason string	// 失败原因，见chunkResults
+		status int	// 失败时服务端返回的状态码
+		host string
+		start, t time.Time
 	)
 
-	if chunk.tried > 10 {
-		log.Fatalf(		// 程序退出
-			"The (%d)th ChunkDownloader met error when download chunk. chunk={%d-%d,%s}, err=%s\n",
-			cd.id, chunk.Begin, chunk.End, chunk.Url, "fail too much times")
+	if chunk.tried > chunk.maxTries() {
+		chunk.logger().Error("chunk gave up", "downloader", cd.id, "range", chunk.span(),
+			"attempts", chunk.tried, "reason", chunk.reason)
+		return ErrTooManyTries
 	}
 
 	req, err = http.NewRequest("GET", chunk.Url, nil)
 	if err != nil {
+		reason = "request"
 		goto ERR
 	}
+	host = strings.ToLower(req.URL.Hostname())
+	if chunk.ctx != nil {
+		req = req.WithContext(chunk.ctx)
+	}
 
 	// 请求头中设置块下载的起止范围
 	req.Header.Set(
@@ -93,16 +143,34 @@ func (cd *ChunkDownloader) Download(chunk *Chunk) error {
 	)
 
 	// 请求数据
-	rsp, err = cd.client.Do(req)
+	start = time.Now()
+	rsp, err = chunk.auth.Do(cd.client, req)
 	if err != nil {
+		reason = netReason(err)
 		goto ERR
 	}
 	defer rsp.Body.Close()
-	fmt.Println("rsp.Header: ", rsp.Header)
+	observeSince(requestDuration.With(host), start)
+
+	// 检查响应状态，避免把错误页面当作分块数据写入
+	// 200说明服务器忽略了Range返回整个文件，只有分块恰好是整个文件时才能使用
+	if rsp.StatusCode == http.StatusOK {
+		if chunk.Begin != 0 || rsp.ContentLength != chunk.End+1 {
+			err = ErrRangeIgnored
+			reason = "range_ignored"
+			goto ERR
+		}
+	} else if rsp.StatusCode != http.StatusPartialContent {
+		err = fmt.Errorf("unexpected status: %s", rsp.Status)
+		reason = statusReason(rsp.StatusCode)
+		status = rsp.StatusCode
+		goto ERR
+	}
 
 	// 检查Content-Range是否匹配
 	if !checkContentRange(chunk, rsp) {
-		log.Println("Content-Range mismatched")
+		err = ErrContentRange
+		reason = "content_range"
 		goto ERR
 	}
 
@@ -111,8 +179,9 @@ func (cd *ChunkDownloader) Download(chunk *Chunk) error {
 	// 别人不一定一下子给你发4k的数据，完全有可能发2次2k，第一次发的时候，你的read就返回了，这个时候就只有2k的数据
 	//n, err = rsp.Body.Read(buf)
 	// 要一次读完全部数据，可以用ioutil.ReadAll
-	buf, err = ioutil.ReadAll(rsp.Body)
+	buf, err = ioutil.ReadAll(LimitHostReader(req.Context(), host, rsp.Body))
 	if err != nil {
+		reason = netReason(err)
 		goto ERR
 	}
 	needSize = chunk.End + 1 - chunk.Begin
@@ -140,25 +209,39 @@ func (cd *ChunkDownloader) Download(chunk *Chunk) error {
 	//buf = buf[:n]
 
 	// 将该分块数据写入数据库
+	t = time.Now()
 	err = chunk.Db.Set([]byte(chunk.DataKey), buf)
 	if err != nil {
+		reason = "db"
 		goto ERR
 	}
+	observeSince(dbWriteDuration.With("set"), t)
 	// 确认写入成功后，将对应的任务删除
+	t = time.Now()
 	err = chunk.Db.Delete([]byte(chunk.TaskKey))
 	if err != nil {
+		reason = "db"
 		goto ERR
 	}
+	observeSince(dbWriteDuration.With("delete"), t)
 
+	observeSince(chunkDuration.With(host), start)
+	chunk.logger().Debug("chunk done", "downloader", cd.id, "range", chunk.span(),
+		"attempt", chunk.tried+1, "bytes", len(buf), "elapsed", time.Since(start))
 	return nil
 
 ERR:
-	log.Printf(
-		"The (%d)th ChunkDownloader met error when download chunk. chunk={%d-%d,%s}, err=%s\n",
-		cd.id, chunk.Begin, chunk.End, chunk.Url, err)
+	if reason == "canceled" {	// 所属Task暂停时中断的，不算失败
+		chunk.logger().Debug("chunk canceled", "downloader", cd.id, "range", chunk.span(), "attempt", chunk.tried+1)
+	} else {
+		chunk.logger().Warn("chunk failed", "downloader", cd.id, "range", chunk.span(),
+			"attempt", chunk.tried+1, "reason", reason, "err", err)
+	}
 
 	chunk.tried++
-	return nil
+	chunk.reason = reason
+	chunk.status = status
+	return err
 }
 
 func checkContentRange(chunk *Chunk, rsp *http.Response) bool {
@@ -7,35 +7,59 @@
 package pool
 
 import (
-	"container/heap"
 	"errors"
-	"log"
+	"net/http"
 	"sync"
+	"sync/atomic"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/logging"
+	"github.com/azd1997/blockchair_downloader/speed"
 )
 
 var (
 	ErrMaxDownloader = errors.New("max downloader number")
+	ErrNotInit = errors.New("cdp not initialized")
 )
 
 var (
 	cdp *ChunkDownloaderPool
-	notifies map[string]chan<-struct{}
+
+	logger logging.Var	// 见SetLogger
 )
 
+// SetLogger 设置pool的日志，默认（或nil）不输出
+// 分块的日志带有downloader、range、attempt等字段，以及TaskOptions.LogFields
+func SetLogger(l logging.Logger) {
+	logger.Set(l)
+}
+
 func Init(max int) error {
+	return InitWithClient(max, httpclient.Options{})
+}
+
+// InitWithClient 初始化cdp，所有下载器和HTTPClient都按opts配置
+func InitWithClient(max int, opts httpclient.Options) error {
 	if max <= 0 {
 		return errors.New("cdp need at least 1 cd")
 	}
+	transport, err := opts.Transport()
+	if err != nil {
+		return err
+	}
 	cdp = &ChunkDownloaderPool{
 		busyChunkDownloaderMap: map[int]*ChunkDownloader{},
 		idleChunkDownloaderMap: map[int]*ChunkDownloader{},
 		maxChunkDownloader: max,
 		curHighest: -1,	// 表示没有可用的
-		idHeap: InitRankHeap(),
-		chunkQueue: make(chan *Chunk, 100),
-		stop: make(chan struct{}),
+		transport: transport,
+		client: &http.Client{Transport: transport.Clone()},
+		sched: newScheduler(),
+		meter: speed.NewMeter(),
 	}
-	notifies = map[string]chan<- struct{}{}
+	entryLock.Lock()
+	entries = map[string]*taskEntry{}
+	entryLock.Unlock()
 	return nil
 }
 
@@ -51,228 +75,196 @@ func Stop() {
 	}
 }
 
+// Download 提交分块，所属Task需先RegisterNotify，否则分块会被丢弃
 func Download(chunk Chunk) {
 	cdp.DownloadChunk(chunk)
 }
 
-func RegisterNotify(task string, notify chan <- struct{}) {
-	notifies[task] = notify
+// HTTPClient 与下载器配置相同的http客户端，用于探测文件信息等非分块请求
+// cdp未初始化时返回http.DefaultClient
+func HTTPClient() *http.Client {
+	if cdp == nil {
+		return http.DefaultClient
+	}
+	return cdp.client
+}
+
+// SetMaxDownloaders 运行中调整最多同时工作的下载器数
+func SetMaxDownloaders(n int) error {
+	if cdp == nil {
+		return ErrNotInit
+	}
+	return cdp.SetMaxDownloaders(n)
 }
 
-func RemoveNotify(task string) {
-	delete(notifies, task)
+// MaxDownloaders 当前最多同时工作的下载器数
+func MaxDownloaders() int {
+	if cdp == nil {
+		return 0
+	}
+	return cdp.MaxDownloaders()
 }
 
 // ChunkDownloaderPool 下载器池
 // 不管程序中有多少块下载任务，调用CDP的下载方法
+// 每个下载器由一个常驻的工作协程驱动，空闲时阻塞在调度器上等待分块，
+// 下载器（及其http连接）在多个分块之间复用
 type ChunkDownloaderPool struct {
 
 	busyChunkDownloaderMap map[int]*ChunkDownloader
 	idleChunkDownloaderMap map[int]*ChunkDownloader
 	sync.RWMutex	// 两个表基本都需要同时使用，所以只用一把锁
 	maxChunkDownloader int	// 最多支持多少个ChunkDownloader
+	workers int	// 当前的工作协程数，调小maxChunkDownloader后多出的协程下完手头的分块再退出
 	curHighest int	// 最高的下载器id(0-)		// 用于为下载器分配递增id
-	idHeap *RankHeap	//
+	started bool
+
+	transport *http.Transport	// 下载器的Transport模板，每个下载器使用一份拷贝
+	client *http.Client	// 见HTTPClient
 
-	chunkQueue chan *Chunk	// 下载任务队列
+	sched *scheduler	// 下载任务队列，按优先级调度
 
-	stop chan struct{}	// 如果没有初始化stop，对nil stop的写操作将阻塞
+	meter *speed.Meter	// 统计所有分块的下载速度
+	counters counters
 }
 
 // DownloadChunk 外部调用，将块下载任务添加到
 func (cdp *ChunkDownloaderPool) DownloadChunk(chunk Chunk) {
 	if chunk.Valid() {
-		cdp.chunkQueue <- &chunk
+		stamp(&chunk)
+		opts := cdp.sched.options(chunk.Url)
+		chunk.auth, chunk.tries = opts.Auth, opts.MaxTries
+		if len(opts.LogFields) > 0 {
+			chunk.log = logging.With(&logger, opts.LogFields...)
+		}
+		cdp.sched.push(&chunk, false)
 	}
 }
 
-// Start 启动调度循环
+// Start 启动maxChunkDownloader个工作协程
 func (cdp *ChunkDownloaderPool) Start() {
-	go func() {
-		for {
-			select {
-			case chunk := <- cdp.chunkQueue:
-				go cdp.download(chunk)
-			case <- cdp.stop:
-				return
-			}
-		}
-	}()
+	cdp.Lock()
+	cdp.started = true
+	cdp.spawn()
+	cdp.Unlock()
 }
 
-// 调用时应 go cdp.download()
-func (cdp *ChunkDownloaderPool) download(chunk *Chunk) {
-
-	///////////////// 获取下载器 ////////////////////
-	cd, cdr, err := cdp.getChunkDownloader()
-	if err != nil && err != ErrMaxDownloader {
-		log.Fatalln("ChunkDownloaderPool.download fail: ", err)
+// spawn 补足工作协程，调用者需持有锁
+func (cdp *ChunkDownloaderPool) spawn() {
+	for cdp.workers < cdp.maxChunkDownloader {
+		cdp.curHighest++
+		cd := NewChunkDownloader(cdp.curHighest)
+		cd.client = &http.Client{Transport: cdp.transport.Clone()}
+		cdp.idleChunkDownloaderMap[cd.id] = cd
+		cdp.workers++
+		go cdp.work(cd)
 	}
-	if err != nil && err == ErrMaxDownloader {	// 将下载任务重新塞回
-		cdp.chunkQueue <- chunk
-		return
+}
+
+// SetMaxDownloaders 调整下载器数上限
+// 调大后排队的分块马上可以开始下载；调小时正在下载的分块会继续下完，之后同时工作的下载器不再超过n
+func (cdp *ChunkDownloaderPool) SetMaxDownloaders(n int) error {
+	if n <= 0 {
+		return errors.New("cdp need at least 1 cd")
 	}
-	if cd == nil || cdr == nil {
-		log.Fatalln("ChunkDownloaderPool.download fail: unexpected nil ChunkDownloader")
+	cdp.Lock()
+	old := cdp.maxChunkDownloader
+	cdp.maxChunkDownloader = n
+	if cdp.started {
+		cdp.spawn()
 	}
+	cdp.Unlock()
 
-	///////////////// 下载 ////////////////////
-	err = cd.Download(chunk)
-	if err != nil {
-		log.Fatalln("ChunkDownloaderPool.download fail: ", err)
+	if n != old {
+		logger.Info("max downloaders changed", "old", old, "new", n)
 	}
-	// 下载成功后通知Task
-	if notify, ok := notifies[chunk.Url]; ok && notify != nil {
-		notify <- struct{}{}
+	if n < old {
+		cdp.sched.wake()	// 让空闲的多余协程退出
 	}
-
-	///////////////// 归还下载器 ////////////////////
-
-	cdp.retChunkDownloader(cd, cdr)
-
-	log.Printf("ChunkDownloaderPool.download succ: chunk={%d-%d,%s}\n",
-		chunk.Begin, chunk.End, chunk.Url)
+	return nil
 }
 
-// 获取下载器时，要将idle下载器转变为busy
-func (cdp *ChunkDownloaderPool) getChunkDownloader() (*ChunkDownloader, *chunkDownloaderRank, error) {
-	cdp.Lock()
-	defer cdp.Unlock()
-
-	var cd *ChunkDownloader
-	var cdr *chunkDownloaderRank
+// MaxDownloaders 当前的下载器数上限
+func (cdp *ChunkDownloaderPool) MaxDownloaders() int {
+	cdp.RLock()
+	defer cdp.RUnlock()
+	return cdp.maxChunkDownloader
+}
 
-	// 1.1 如果有空闲下载器，则直接取用
-	if idles := len(cdp.idleChunkDownloaderMap); idles > 0 {
-		// 从idHeap头部取出一个idle下载器的标识
-		top := cdp.idHeap.Pop()
-		if top == nil || top.status != StatusIdle {
-			log.Fatalln("ChunkDownloaderPool.download fail: top == nil || top.status != StatusIdle")
-		}
-		// 取出对应的下载器
-		topCD, exists := cdp.idleChunkDownloaderMap[top.chunkDownloaderId]
-		if !exists || topCD.status != StatusIdle {
-			log.Fatalln("ChunkDownloaderPool.download fail: !exists || topCD.status != StatusIdle")
+// work 工作协程：不断从调度器取出分块，用自己的下载器下载
+func (cdp *ChunkDownloaderPool) work(cd *ChunkDownloader) {
+	retire := func() bool { return cdp.retire(cd) }
+	for {
+		chunk := cdp.sched.take(retire)
+		if chunk == nil {
+			cd.client.CloseIdleConnections()
+			return
 		}
-		// 将该下载器从原处取下
-		delete(cdp.idleChunkDownloaderMap, top.chunkDownloaderId)
-
-		cd = topCD
-		cdr = top
-	}
-
-	// 1.2 如果没有空闲的下载器，试图创建
-	if len(cdp.busyChunkDownloaderMap) >= cdp.maxChunkDownloader {	// 不能再创建
-		return nil, nil, ErrMaxDownloader
-	}
-
-	// 1.3 创建新下载器
-	cd = NewChunkDownloader(cdp.curHighest + 1, cdp.chunkQueue)
-	cdp.curHighest++
-	cdr = &chunkDownloaderRank{
-		chunkDownloaderId: cd.id,
-		status:            StatusIdle,
+		cdp.download(cd, chunk)
+		cdp.sched.done(chunk)
 	}
-
-	// 2 将取出的下载器添加到busy表
-	cdp.busyChunkDownloaderMap[cd.id] = cd
-	cdr.status = StatusBusy
-	cdp.idHeap.Push(cdr)
-
-	return cd, cdr, nil
 }
 
-// 归还下载器时，要将busy下载器转变为idle
-func (cdp *ChunkDownloaderPool) retChunkDownloader(cd *ChunkDownloader, cdr *chunkDownloaderRank) {
+// retire 工作协程数超过上限时，让cd对应的协程退出
+func (cdp *ChunkDownloaderPool) retire(cd *ChunkDownloader) bool {
 	cdp.Lock()
 	defer cdp.Unlock()
-
-	delete(cdp.busyChunkDownloaderMap, cd.id)
-	cdp.idleChunkDownloaderMap[cd.id] = cd
-	cdr.status = StatusIdle
-	cdp.idHeap.Heapify()	// 让cdr到达正确的位置
-}
-
-
-// Stop 关闭调度循环，清除所有结构
-func (cdp *ChunkDownloaderPool) Stop() {
-	cdp.stop <- struct{}{}
-	log.Println("ChunkDownloaderPool.Stop")
-}
-
-///////////////////////// 堆,用来对cd进行排序 ///////////////////////////
-
-func InitRankHeap() *RankHeap {
-	rh := &rankHeap{}
-	heap.Init(rh)
-	return &RankHeap{
-		rankHeap:*rh,
+	if cdp.workers <= cdp.maxChunkDownloader {
+		return false
 	}
+	cdp.workers--
+	delete(cdp.idleChunkDownloaderMap, cd.id)
+	return true
 }
 
-type RankHeap struct {
-	rankHeap
-	sync.Mutex
-}
-
-// Heapify 重新堆化
-// 不使用heap.Fix(i)的原因是，没有记录元素的下标
-func (rh *RankHeap) Heapify() {
-	rh.Lock()
-	heap.Init(&rh.rankHeap)
-	rh.Unlock()
-}
-
-func (rh *RankHeap) Push(r *chunkDownloaderRank) {
-	if r == nil {
+func (cdp *ChunkDownloaderPool) download(cd *ChunkDownloader, chunk *Chunk) {
+	// 所属Task已暂停或已注销的分块直接丢弃，不占用下载器
+	if !begin(chunk) {
 		return
 	}
-	rh.Lock()
-	heap.Push(&rh.rankHeap, r)
-	rh.Unlock()
-}
 
-func (rh *RankHeap) Pop() *chunkDownloaderRank {
-	var ret interface{}
-	rh.Lock()
-	ret = heap.Pop(&rh.rankHeap)
-	rh.Unlock()
-	pop, ok := ret.(*chunkDownloaderRank)
-	if !ok {
-		return nil
+	///////////////// 下载 ////////////////////
+	cdp.setStatus(cd, StatusBusy)
+	err := cd.Download(chunk)
+	finish(chunk)
+	cdp.setStatus(cd, StatusIdle)
+
+	switch {
+	case err == nil:	// 下载成功后通知Task
+		cdp.meter.Add(chunk.End + 1 - chunk.Begin)
+		atomic.AddInt64(&cdp.counters.chunksDone, 1)
+		chunkResults.With("success", "").Inc()
+		notify(chunk, NoticeDone, nil)
+	case !accepted(chunk):	// 下载期间Task被暂停(可能是主动中断的)，丢弃
+	case err == ErrTooManyTries:	// 放弃该分块，由Task决定如何处理
+		atomic.AddInt64(&cdp.counters.failures, 1)
+		chunkResults.With("failure", chunk.reason).Inc()
+		notify(chunk, NoticeFail, err)
+	default:	// 通知Task后将下载任务重新塞回
+		atomic.AddInt64(&cdp.counters.retries, 1)
+		chunkResults.With("retry", chunk.reason).Inc()
+		notify(chunk, NoticeRetry, err)
+		cdp.sched.push(chunk, true)
 	}
-	return pop
-}
-
-type chunkDownloaderRank struct {
-	chunkDownloaderId int
-	status Status
 }
 
-// rankHeap 将status小(idle)、id序号小的排在堆顶
-type rankHeap []*chunkDownloaderRank
+// setStatus 在idle表和busy表之间移动下载器
+func (cdp *ChunkDownloaderPool) setStatus(cd *ChunkDownloader, status Status) {
+	cdp.Lock()
+	defer cdp.Unlock()
 
-func (h rankHeap) Len() int           { return len(h) }
-func (h rankHeap) Less(i, j int) bool {
-	if h[i].status == h[j].status {
-		return h[i].chunkDownloaderId < h[j].chunkDownloaderId
+	cd.status = status
+	if status == StatusBusy {
+		delete(cdp.idleChunkDownloaderMap, cd.id)
+		cdp.busyChunkDownloaderMap[cd.id] = cd
+	} else {
+		delete(cdp.busyChunkDownloaderMap, cd.id)
+		cdp.idleChunkDownloaderMap[cd.id] = cd
 	}
-	return h[i].status < h[j].status
-}
-func (h rankHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
-
-func (h *rankHeap) Push(x interface{}) {
-	// Push and Pop use pointer receivers because they modify the slice's length,
-	// not just its contents.
-	*h = append(*h, x.(*chunkDownloaderRank))
 }
 
-func (h *rankHeap) Pop() interface{} {
-	old := *h
-	n := len(old)
-	x := old[n-1]
-	*h = old[0 : n-1]
-	return x
+// Stop 关闭调度器，工作协程下完手头的分块后退出
+func (cdp *ChunkDownloaderPool) Stop() {
+	cdp.sched.close()
+	logger.Info("pool stopped")
 }
-
-
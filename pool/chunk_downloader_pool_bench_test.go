@@ -0,0 +1,83 @@
+//go:build !windows
+// +build !windows
+
+package pool
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/edb"
+)
+
+// cpuTime 进程累计的用户态+内核态CPU时间
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// benchPool 用max个下载器下载b.N个大小为size的分块，服务端每个请求延迟delay
+// 报告吞吐量(MB/s)和每个分块消耗的CPU时间(cpu-ns/op，包括进程内的测试服务端)
+func benchPool(b *testing.B, max int, size int64, delay time.Duration) {
+	data := bytes.Repeat([]byte{'x'}, int(size))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "cdp-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := edb.OpenEDB(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := Init(max); err != nil {
+		b.Fatal(err)
+	}
+	Start()
+	defer Stop()
+	notify := make(chan Notice, max)
+	RegisterNotify(srv.URL, notify)
+	defer RemoveNotify(srv.URL)
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	cpu := cpuTime()
+	for i := 0; i < b.N; i++ {
+		Download(Chunk{Begin: 0, End: size - 1, Url: srv.URL, Db: db,
+			DataKey: fmt.Sprint("d", i), TaskKey: fmt.Sprint("t", i)})
+	}
+	for i := 0; i < b.N; i++ {
+		if n := <-notify; n.Type != NoticeDone {
+			b.Fatalf("notice %+v", n)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(cpuTime()-cpu)/float64(b.N), "cpu-ns/op")
+}
+
+// BenchmarkPool_Throughput 服务端没有延迟，下载器是瓶颈
+func BenchmarkPool_Throughput(b *testing.B) {
+	benchPool(b, 8, 64<<10, 0)
+}
+
+// BenchmarkPool_Saturated 服务端较慢，分块在队列中等待空闲下载器
+func BenchmarkPool_Saturated(b *testing.B) {
+	benchPool(b, 4, 4<<10, 10*time.Millisecond)
+}
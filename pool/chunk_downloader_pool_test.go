@@ -1,56 +1,16 @@
 package pool
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/azd1997/blockchair_downloader/edb"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 )
 
-func TestRankHeap(t *testing.T) {
-	rh := InitRankHeap()
-
-	rh.Push(&chunkDownloaderRank{
-		chunkDownloaderId: 1,
-		status:            1,
-	})
-
-	rh.Push(&chunkDownloaderRank{
-		chunkDownloaderId: 2,
-		status:            0,
-	})
-
-	rh.Push(&chunkDownloaderRank{
-		chunkDownloaderId: 3,
-		status:            1,
-	})
-
-	rh.Push(&chunkDownloaderRank{
-		chunkDownloaderId: 4,
-		status:            0,
-	})
-
-
-	ids := [4]int{}
-	for i:=0; i<4; i++ {
-		ids[i] = rh.rankHeap[i].chunkDownloaderId
-	}
-	t.Log(ids)
-
-	// 出来的顺序应该是 2 4 1 3
-	out := [4]int{}
-	for i:=0; i<4; i++ {
-		top := rh.Pop()
-		out[i] = top.chunkDownloaderId
-	}
-
-	if out != [4]int{2,4,1,3} {
-		t.Error("error")
-		t.Log(out)
-		return
-	}
-	t.Log("succ")
-}
-
 func TestChunkDownloaderPool_OneChunk(t *testing.T) {
 	err := Init(3)
 	if err != nil {
@@ -61,7 +21,7 @@ func TestChunkDownloaderPool_OneChunk(t *testing.T) {
 	defer Stop()
 
 	taskurl := "https://gz.blockchair.com/bitcoin/inputs/blockchair_bitcoin_inputs_20130425.tsv.gz"
-	notify := make(chan struct{})
+	notify := make(chan Notice)
 	RegisterNotify(taskurl, notify)
 
 	// 准备好数据库
@@ -81,7 +41,7 @@ func TestChunkDownloaderPool_OneChunk(t *testing.T) {
 		Begin:   0,
 		End:     10000,
 		Url:     taskurl,
-		Db:  db,
+		Db:      db,
 		DataKey: dk,
 		TaskKey: tk,
 		tried:   0,
@@ -94,7 +54,7 @@ func TestChunkDownloaderPool_OneChunk(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	if len(v) != int(chunk.End + 1 - chunk.Begin) {
+	if len(v) != int(chunk.End+1-chunk.Begin) {
 		panic("errrrr")
 	}
 
@@ -102,4 +62,113 @@ func TestChunkDownloaderPool_OneChunk(t *testing.T) {
 	fmt.Println("success")
 
 	// 接下来就是defer Stop()
-}
\ No newline at end of file
+}
+
+func TestSetMaxDownloaders(t *testing.T) {
+	if err := SetMaxDownloaders(1); err == nil && cdp == nil {
+		t.Fatal("SetMaxDownloaders should fail before Init")
+	}
+
+	var (
+		mu                    sync.Mutex
+		inflight, peak, limit int
+		violations            int // 新到达的请求超出limit的次数
+	)
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inflight++
+		if inflight > peak {
+			peak = inflight
+		}
+		if inflight > limit {
+			violations++
+		}
+		mu.Unlock()
+		time.Sleep(200 * time.Millisecond)
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	if err := Init(1); err != nil {
+		t.Fatal(err)
+	}
+	Start()
+	defer Stop()
+
+	db, err := edb.OpenEDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	notify := make(chan Notice)
+	RegisterNotify(srv.URL, notify)
+	defer RemoveNotify(srv.URL)
+
+	n := 0
+	// run 以上限max提交6个分块并等待全部下载完成，返回期间的最大并发数
+	run := func(max int, during func()) int {
+		mu.Lock()
+		peak, limit = 0, max
+		mu.Unlock()
+		for i := 0; i < 6; i++ {
+			n++
+			Download(Chunk{Begin: 0, End: 9, Url: srv.URL, Db: db,
+				DataKey: fmt.Sprint("d", n), TaskKey: fmt.Sprint("t", n)})
+		}
+		if during != nil {
+			during()
+		}
+		for i := 0; i < 6; i++ {
+			if no := <-notify; no.Type != NoticeDone {
+				t.Fatalf("notice %+v", no)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if violations > 0 {
+			t.Fatalf("%d requests exceeded limit %d", violations, limit)
+		}
+		return peak
+	}
+
+	if p := run(1, nil); p != 1 {
+		t.Fatalf("peak with 1 downloader = %d", p)
+	}
+
+	if err := SetMaxDownloaders(3); err != nil {
+		t.Fatal(err)
+	}
+	if MaxDownloaders() != 3 || Stats().MaxDownloaders != 3 {
+		t.Fatalf("max = %d", MaxDownloaders())
+	}
+	if p := run(3, nil); p != 3 {
+		t.Fatalf("peak with 3 downloaders = %d", p)
+	}
+
+	// 调小时正在下载的分块继续完成，之后到达的请求不超过新的上限
+	run(3, func() {
+		for {
+			mu.Lock()
+			busy := inflight
+			mu.Unlock()
+			if busy == 3 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if err := SetMaxDownloaders(1); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		limit = 1
+		mu.Unlock()
+	})
+
+	if err := SetMaxDownloaders(0); err == nil {
+		t.Fatal("SetMaxDownloaders(0) should fail")
+	}
+}
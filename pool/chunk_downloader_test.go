@@ -1,7 +1,14 @@
 package pool
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/azd1997/blockchair_downloader/edb"
@@ -24,8 +31,7 @@ func TestChunkDownloader_Download(t *testing.T) {
 
 
 	// 块下载
-	tryagain := make(chan *Chunk, 10)
-	cd := NewChunkDownloader(1, tryagain)
+	cd := NewChunkDownloader(1)
 	
 	chunk := &Chunk{
 		Begin:   0,
@@ -53,3 +59,44 @@ func TestChunkDownloader_Download(t *testing.T) {
 
 	fmt.Println("success")
 }
+
+func TestChunkDownloader_RangeIgnored(t *testing.T) {
+	data := make([]byte, 300)
+	rand.Read(data)
+	// 忽略Range，总是返回整个文件
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := edb.OpenEDB(filepath.Join(dir, "x.DOWNLOADING"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	cd := NewChunkDownloader(1)
+	for _, c := range []struct{ begin, end int64 }{{100, 199}, {0, 99}, {0, 399}} {
+		chunk := &Chunk{Begin: c.begin, End: c.end, Url: srv.URL + "/x", Db: db, DataKey: "d", TaskKey: "t"}
+		if err := cd.Download(chunk); err != ErrRangeIgnored || chunk.reason != "range_ignored" {
+			t.Fatalf("%d-%d: err=%v reason=%q", c.begin, c.end, err, chunk.reason)
+		}
+	}
+	if db.Has([]byte("d")) {
+		t.Fatal("nothing should be written")
+	}
+
+	// 分块恰好是整个文件时可以使用200的响应
+	chunk := &Chunk{Begin: 0, End: int64(len(data)) - 1, Url: srv.URL + "/x", Db: db, DataKey: "d", TaskKey: "t"}
+	if err := cd.Download(chunk); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Get([]byte("d")); err != nil || !bytes.Equal(v, data) {
+		t.Fatalf("data mismatched: %v", err)
+	}
+}
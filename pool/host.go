@@ -0,0 +1,89 @@
+package pool
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// HostLimit 单个主机的下载限制，零值表示不限制
+type HostLimit struct {
+	MaxDownloaders int   // 同时下载该主机分块的下载器数上限
+	RateLimit      int64 // 该主机所有下载合计的速度上限，字节/秒
+}
+
+type hostState struct {
+	max     int
+	limiter *rate.Limiter // 不限速时为nil
+}
+
+var (
+	hostLock sync.RWMutex
+	hosts    = map[string]*hostState{}
+)
+
+// SetHostLimit 设置主机的下载限制，host为主机名（不含端口），零值表示取消限制
+// 与SetRateLimit一样不需要先Init，对正在排队的分块也立即生效
+func SetHostLimit(host string, l HostLimit) {
+	host = strings.ToLower(host)
+	hostLock.Lock()
+	if l.MaxDownloaders <= 0 && l.RateLimit <= 0 {
+		delete(hosts, host)
+	} else {
+		h := &hostState{max: l.MaxDownloaders}
+		if l.RateLimit > 0 {
+			h.limiter = rate.NewLimiter(rate.Limit(l.RateLimit), burst(l.RateLimit))
+		}
+		hosts[host] = h
+	}
+	hostLock.Unlock()
+	if cdp != nil {
+		cdp.sched.wake() // 调大或取消上限后排队的分块可以开始下载
+	}
+}
+
+// GetHostLimit 主机当前的下载限制
+func GetHostLimit(host string) HostLimit {
+	hostLock.RLock()
+	defer hostLock.RUnlock()
+	h, ok := hosts[strings.ToLower(host)]
+	if !ok {
+		return HostLimit{}
+	}
+	l := HostLimit{MaxDownloaders: h.max}
+	if h.limiter != nil {
+		l.RateLimit = int64(h.limiter.Limit())
+	}
+	return l
+}
+
+// hostMax 主机的下载器数上限，0表示不限制
+func hostMax(host string) int {
+	hostLock.RLock()
+	defer hostLock.RUnlock()
+	if h, ok := hosts[host]; ok {
+		return h.max
+	}
+	return 0
+}
+
+// hostLimiter 主机的限速，没有时返回nil
+func hostLimiter(host string) *rate.Limiter {
+	hostLock.RLock()
+	defer hostLock.RUnlock()
+	if h, ok := hosts[host]; ok {
+		return h.limiter
+	}
+	return nil
+}
+
+// hostOf url的主机名（小写，不含端口），解析失败时返回空串
+func hostOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,82 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/metrics"
+)
+
+// 下载器池的指标，登记在metrics.Default中
+var (
+	bytesByHost = metrics.Default.CounterVec("godl_bytes_total",
+		"从各主机下载的字节数，包括分块和直接下载", "host")
+	chunkResults = metrics.Default.CounterVec("godl_chunks_total",
+		"分块下载结果：success成功，retry本次失败、已重新排队，failure失败次数过多而放弃；reason为失败原因", "result", "reason")
+	requestDuration = metrics.Default.HistogramVec("godl_request_duration_seconds",
+		"分块请求从发出到收到响应头的时间", nil, "host")
+	chunkDuration = metrics.Default.HistogramVec("godl_chunk_duration_seconds",
+		"成功的分块从发出请求到写入数据库的时间", nil, "host")
+	dbWriteDuration = metrics.Default.HistogramVec("godl_db_write_duration_seconds",
+		"分块写入数据库(badger)的时间：set写入数据，delete删除分块任务", dbBuckets, "op")
+)
+
+// dbBuckets 数据库写入一般在毫秒以内
+var dbBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1}
+
+func init() {
+	metrics.Default.GaugeFunc("godl_downloaders", "下载器数：busy正在下载，idle空闲",
+		[]string{"state"}, func(emit func(float64, ...string)) {
+			if cdp == nil {
+				return
+			}
+			cdp.RLock()
+			busy, idle := len(cdp.busyChunkDownloaderMap), len(cdp.idleChunkDownloaderMap)
+			cdp.RUnlock()
+			emit(float64(busy), "busy")
+			emit(float64(idle), "idle")
+		})
+	metrics.Default.GaugeFunc("godl_downloaders_max", "同时工作的下载器数上限",
+		nil, func(emit func(float64, ...string)) {
+			emit(float64(MaxDownloaders()))
+		})
+	metrics.Default.GaugeFunc("godl_chunks_queued", "排队等待下载的分块数",
+		nil, func(emit func(float64, ...string)) {
+			if cdp != nil {
+				emit(float64(cdp.sched.len()))
+			}
+		})
+	metrics.Default.GaugeFunc("godl_chunks_active", "正在下载的分块数，即活跃连接数",
+		nil, func(emit func(float64, ...string)) {
+			emit(float64(Stats().ActiveChunks))
+		})
+	metrics.Default.GaugeFunc("godl_rate_limit_bytes", "所有下载合计的速度上限（字节/秒），0表示不限速",
+		nil, func(emit func(float64, ...string)) {
+			emit(float64(RateLimit()))
+		})
+}
+
+// netReason 请求或读取响应失败的原因
+func netReason(err error) string {
+	var ne net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	}
+	return "network"
+}
+
+// statusReason 服务端返回了非预期的状态码
+func statusReason(code int) string {
+	return "http_" + strconv.Itoa(code)
+}
+
+// observeSince 记录从start到现在的秒数
+func observeSince(h *metrics.Histogram, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,39 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChunkDownloader_FailReason(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cd := NewChunkDownloader(1)
+	chunk := &Chunk{Begin: 0, End: 99, Url: srv.URL + "/x"}
+	if err := cd.Download(chunk); err == nil || chunk.reason != "http_503" || chunk.tried != 1 {
+		t.Fatalf("err=%v reason=%q tried=%d", err, chunk.reason, chunk.tried)
+	}
+
+	srv.Close()
+	if err := cd.Download(chunk); err == nil || chunk.reason != "network" {
+		t.Fatalf("err=%v reason=%q", err, chunk.reason)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if r := netReason(ctx.Err()); r != "canceled" {
+		t.Fatalf("canceled: %q", r)
+	}
+	if r := netReason(context.DeadlineExceeded); r != "timeout" {
+		t.Fatalf("deadline: %q", r)
+	}
+	if r := netReason(errors.New("x")); r != "network" {
+		t.Fatalf("other: %q", r)
+	}
+}
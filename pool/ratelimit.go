@@ -0,0 +1,112 @@
+package pool
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/azd1997/blockchair_downloader/metrics"
+	"golang.org/x/time/rate"
+)
+
+// limiter 所有下载器合计的限速，运行中可以随时调整，默认不限速
+var limiter = rate.NewLimiter(rate.Inf, 0)
+
+// SetRateLimit 设置所有下载合计的速度上限，单位字节/秒，<=0表示不限速
+// 对正在下载的分块也立即生效，不需要先Init
+func SetRateLimit(bytesPerSec int64) {
+	if bytesPerSec <= 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	limiter.SetBurst(burst(bytesPerSec))
+	limiter.SetLimit(rate.Limit(bytesPerSec))
+}
+
+// RateLimit 当前的速度上限，0表示不限速
+func RateLimit() int64 {
+	l := limiter.Limit()
+	if l == rate.Inf {
+		return 0
+	}
+	return int64(l)
+}
+
+// burst 每次最多读取约0.1秒的数据，限速较低时也不小于1KB（除非限速本身更低）
+func burst(bytesPerSec int64) int {
+	b := bytesPerSec / 10
+	if b < 1024 {
+		b = 1024
+	}
+	if b > bytesPerSec {
+		b = bytesPerSec
+	}
+	if b > 1<<20 {
+		b = 1 << 20
+	}
+	return int(b)
+}
+
+// LimitReader 按全局限速读取r，用于分块和不经过cdp的直接下载；ctx取消时立即返回错误
+func LimitReader(ctx context.Context, r io.Reader) io.Reader {
+	return LimitHostReader(ctx, "", r)
+}
+
+// LimitHostReader 同时按全局限速和host的限速（见SetHostLimit）读取r，读取的字节数计入host的godl_bytes_total
+func LimitHostReader(ctx context.Context, host string, r io.Reader) io.Reader {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	lr := &limitedReader{r: r, ctx: ctx, host: strings.ToLower(host)}
+	if lr.host != "" {
+		lr.bytes = bytesByHost.With(lr.host)
+	}
+	return lr
+}
+
+type limitedReader struct {
+	r     io.Reader
+	ctx   context.Context
+	host  string
+	bytes *metrics.Counter // 为nil时不统计
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.read(p)
+	if n > 0 && lr.bytes != nil {
+		lr.bytes.Add(float64(n))
+	}
+	return n, err
+}
+
+func (lr *limitedReader) read(p []byte) (int, error) {
+	var limiters []*rate.Limiter
+	if limiter.Limit() != rate.Inf {
+		limiters = append(limiters, limiter)
+	}
+	if lr.host != "" {
+		if l := hostLimiter(lr.host); l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+	if len(limiters) == 0 {
+		return lr.r.Read(p)
+	}
+	for _, l := range limiters {
+		if b := l.Burst(); len(p) > b {
+			p = p[:b]
+		}
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		for _, l := range limiters {
+			if werr := l.WaitN(lr.ctx, n); werr != nil {
+				if err == nil {
+					err = werr
+				}
+				break
+			}
+		}
+	}
+	return n, err
+}
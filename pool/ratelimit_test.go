@@ -0,0 +1,69 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	defer SetRateLimit(0)
+
+	data := make([]byte, 64<<10)
+	start := time.Now()
+	if _, err := ioutil.ReadAll(LimitReader(nil, bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("unlimited read took %v", d)
+	}
+
+	// 128KB/s，除去开始时的一次突发约需0.45s
+	SetRateLimit(128 << 10)
+	if RateLimit() != 128<<10 {
+		t.Fatalf("RateLimit = %d", RateLimit())
+	}
+	start = time.Now()
+	got, err := ioutil.ReadAll(LimitReader(context.Background(), bytes.NewReader(data)))
+	if err != nil || len(got) != len(data) {
+		t.Fatal(len(got), err)
+	}
+	if d := time.Since(start); d < 350*time.Millisecond || d > 2*time.Second {
+		t.Fatalf("limited read took %v", d)
+	}
+
+	// ctx取消后立即返回
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	SetRateLimit(1024)
+	if _, err := ioutil.ReadAll(LimitReader(ctx, bytes.NewReader(data))); err == nil {
+		t.Fatal("want context error")
+	}
+}
+
+func TestHostRateLimit(t *testing.T) {
+	SetHostLimit("slow.example", HostLimit{RateLimit: 128 << 10})
+	defer SetHostLimit("slow.example", HostLimit{})
+	if l := GetHostLimit("SLOW.example"); l.RateLimit != 128<<10 {
+		t.Fatalf("GetHostLimit = %+v", l)
+	}
+
+	data := make([]byte, 64<<10)
+	start := time.Now()
+	if _, err := ioutil.ReadAll(LimitHostReader(nil, "fast.example", bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("unlimited host took %v", d)
+	}
+
+	start = time.Now()
+	if _, err := ioutil.ReadAll(LimitHostReader(nil, "slow.example", bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 350*time.Millisecond || d > 2*time.Second {
+		t.Fatalf("limited host took %v", d)
+	}
+}
@@ -0,0 +1,136 @@
+package pool
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleInterval RunSchedule检查时间表的间隔
+var ScheduleInterval = time.Minute
+
+// ScheduleRule 每天[Start, End)时段内的最大下载器数
+// Start、End为距零点的时长，End<=Start表示跨过零点，如22:00-06:00
+type ScheduleRule struct {
+	Start time.Duration
+	End   time.Duration
+	Max   int
+}
+
+// Schedule 按一天中的时段调整下载器数的时间表，多个时段重叠时取前面的
+type Schedule []ScheduleRule
+
+// ParseSchedule 解析时间表，格式为逗号分隔的"HH:MM-HH:MM=n"，例如：
+//
+//	00:00-08:00=50,08:00-18:00=5
+//
+// 没有覆盖到的时段使用默认的下载器数
+func ParseSchedule(s string) (Schedule, error) {
+	var sched Schedule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("schedule %q: missing '=n'", part)
+		}
+		span := strings.SplitN(kv[0], "-", 2)
+		if len(span) != 2 {
+			return nil, fmt.Errorf("schedule %q: want HH:MM-HH:MM", part)
+		}
+		start, err := parseClock(span[0])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %v", part, err)
+		}
+		end, err := parseClock(span[1])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %v", part, err)
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || max <= 0 {
+			return nil, fmt.Errorf("schedule %q: invalid downloader number", part)
+		}
+		sched = append(sched, ScheduleRule{Start: start, End: end, Max: max})
+	}
+	return sched, nil
+}
+
+// parseClock 解析HH:MM，允许24:00
+func parseClock(s string) (time.Duration, error) {
+	hm := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(hm) != 2 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err1 := strconv.Atoi(hm[0])
+	m, err2 := strconv.Atoi(hm[1])
+	if err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// At t时刻的下载器数，没有匹配的时段时返回0
+func (s Schedule) At(t time.Time) int {
+	if i := s.window(t); i >= 0 {
+		return s[i].Max
+	}
+	return 0
+}
+
+// window t时刻所在时段在s中的下标，没有匹配的时段时返回-1
+func (s Schedule) window(t time.Time) int {
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	for i, r := range s {
+		if r.Start < r.End {
+			if clock >= r.Start && clock < r.End {
+				return i
+			}
+		} else if clock >= r.Start || clock < r.End { // 跨过零点
+			return i
+		}
+	}
+	return -1
+}
+
+// RunSchedule 按时间表调整cdp的下载器数，没有匹配的时段时恢复为def
+// 只在进入新的时段时调整，期间用SetMaxDownloaders手动设置的下载器数保持到下一个时段开始
+// 阻塞直到stop被关闭，一般 go pool.RunSchedule(...)
+func RunSchedule(s Schedule, def int, stop <-chan struct{}) {
+	runSchedule(s, def, stop, time.Now)
+}
+
+func runSchedule(s Schedule, def int, stop <-chan struct{}, now func() time.Time) {
+	window := -2 // 上次调整时所在的时段
+	apply := func() {
+		i := s.window(now())
+		if i == window {
+			return
+		}
+		window = i
+		n := def
+		if i >= 0 {
+			n = s[i].Max
+		}
+		if n != MaxDownloaders() {
+			if err := SetMaxDownloaders(n); err != nil {
+				logger.Error("schedule failed", "downloaders", n, "err", err)
+			}
+		}
+	}
+
+	apply()
+	ticker := time.NewTicker(ScheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			apply()
+		case <-stop:
+			return
+		}
+	}
+}
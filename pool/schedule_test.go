@@ -0,0 +1,98 @@
+package pool
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("00:00-08:00=50, 08:00-18:00=5,22:00-02:00=30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 3 || s[1] != (ScheduleRule{Start: 8 * time.Hour, End: 18 * time.Hour, Max: 5}) {
+		t.Fatalf("got %+v", s)
+	}
+
+	at := func(h, m int) int {
+		return s.At(time.Date(2021, 5, 1, h, m, 0, 0, time.Local))
+	}
+	cases := []struct{ h, m, want int }{
+		{0, 0, 50},
+		{7, 59, 50},
+		{8, 0, 5},
+		{17, 59, 5},
+		{18, 0, 0}, // 未覆盖的时段
+		{23, 0, 30},
+	}
+	for _, c := range cases {
+		if got := at(c.h, c.m); got != c.want {
+			t.Errorf("At(%02d:%02d) = %d, want %d", c.h, c.m, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{"08:00=5", "08:00-18:00", "8-18=5", "08:00-25:00=5", "08:00-18:00=0", "08:60-18:00=1"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("ParseSchedule(%q) should fail", bad)
+		}
+	}
+}
+
+func TestRunSchedule_ManualOverride(t *testing.T) {
+	if err := Init(2); err != nil {
+		t.Fatal(err)
+	}
+	Start()
+	defer Stop()
+	defer func(d time.Duration) { ScheduleInterval = d }(ScheduleInterval)
+	ScheduleInterval = 5 * time.Millisecond
+
+	var mu sync.Mutex
+	clock := time.Date(2021, 3, 15, 1, 0, 0, 0, time.Local)
+	setClock := func(t time.Time) {
+		mu.Lock()
+		clock = t
+		mu.Unlock()
+	}
+	now := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	waitFor := func(n int) {
+		for i := 0; i < 200 && MaxDownloaders() != n; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if got := MaxDownloaders(); got != n {
+			t.Fatalf("downloaders = %d, want %d", got, n)
+		}
+	}
+
+	s, _ := ParseSchedule("00:00-08:00=5")
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		runSchedule(s, 2, stop, now)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	waitFor(5)
+
+	// 同一时段内手动设置的下载器数不会被改回
+	if err := SetMaxDownloaders(3); err != nil {
+		t.Fatal(err)
+	}
+	setClock(clock.Add(time.Hour))
+	time.Sleep(10 * ScheduleInterval)
+	waitFor(3)
+
+	// 进入新的时段时按时间表调整
+	setClock(time.Date(2021, 3, 15, 9, 0, 0, 0, time.Local))
+	waitFor(2)
+	setClock(time.Date(2021, 3, 16, 0, 30, 0, 0, time.Local))
+	waitFor(5)
+}
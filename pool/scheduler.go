@@ -0,0 +1,252 @@
+package pool
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+)
+
+// TaskOptions Task在cdp中的调度选项
+type TaskOptions struct {
+	Priority int  // 优先级，越大越先下载；高优先级的分块下载完之前，低优先级的不会被调度
+	Weight   int  // 同优先级的Task按权重分配下载器，<=0视为1
+	InOrder  bool // 按文件顺序下载（流式播放等场景），否则按提交顺序
+
+	Auth     *httpclient.Auth // 分块请求的请求头、Cookie和认证，可以为nil
+	MaxTries int              // 单个分块最多尝试次数，<=0时为MaxTries
+
+	LogFields []interface{} // 分块日志附加的字段（键值对），如所属任务的编号；为空时附加url
+}
+
+// taskQueue 单个Task排队中的分块
+type taskQueue struct {
+	task string
+	host string // 主机名，用于按主机限制下载器数，见SetHostLimit
+	opts TaskOptions
+	// InOrder时按Begin升序，否则按提交顺序
+	chunks []*Chunk
+
+	// 虚拟时间：每出队一个分块增加 分块字节数/权重，
+	// 同优先级中虚拟时间最小的Task先出队，从而按权重公平分配
+	pass float64
+}
+
+// cost 分块按权重折算的虚拟时间
+func (q *taskQueue) cost(c *Chunk) float64 {
+	w := q.opts.Weight
+	if w <= 0 {
+		w = 1
+	}
+	return float64(c.End+1-c.Begin) / float64(w)
+}
+
+func (q *taskQueue) push(c *Chunk, front bool) {
+	switch {
+	case q.opts.InOrder:
+		i := sort.Search(len(q.chunks), func(i int) bool { return q.chunks[i].Begin >= c.Begin })
+		q.chunks = append(q.chunks, nil)
+		copy(q.chunks[i+1:], q.chunks[i:])
+		q.chunks[i] = c
+	case front:
+		q.chunks = append([]*Chunk{c}, q.chunks...)
+	default:
+		q.chunks = append(q.chunks, c)
+	}
+}
+
+func (q *taskQueue) pop() *Chunk {
+	c := q.chunks[0]
+	q.chunks[0] = nil
+	q.chunks = q.chunks[1:]
+	return c
+}
+
+// scheduler 分块调度器，取代原来容量固定的chunkQueue通道
+// 入队不会阻塞；出队时先选优先级最高的Task，同优先级的Task之间按权重加权公平排队(WFQ)
+type scheduler struct {
+	sync.Mutex
+	queues map[string]*taskQueue
+	order  []string       // Task的登记顺序，虚拟时间相同时先登记的先出队
+	size   int            // 排队中的分块总数
+	vtime  float64        // 最近出队的分块的虚拟开始时间
+	active map[string]int // 各主机已出队、还没下载完的分块数
+
+	cond   *sync.Cond // 等待分块入队，见take
+	closed bool
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{
+		queues: map[string]*taskQueue{},
+		active: map[string]int{},
+	}
+	s.cond = sync.NewCond(&s.Mutex)
+	return s
+}
+
+// queue 获取Task的队列，不存在则创建，调用者需持有锁
+func (s *scheduler) queue(task string) *taskQueue {
+	q, ok := s.queues[task]
+	if !ok {
+		q = &taskQueue{task: task, host: hostOf(task)}
+		s.queues[task] = q
+		s.order = append(s.order, task)
+	}
+	return q
+}
+
+// configure 设置Task的调度选项
+func (s *scheduler) configure(task string, opts TaskOptions) {
+	s.Lock()
+	q := s.queue(task)
+	q.opts = opts
+	if opts.InOrder {
+		sort.Slice(q.chunks, func(i, j int) bool { return q.chunks[i].Begin < q.chunks[j].Begin })
+	}
+	s.Unlock()
+}
+
+// options 获取Task的调度选项
+func (s *scheduler) options(task string) TaskOptions {
+	s.Lock()
+	defer s.Unlock()
+	if q, ok := s.queues[task]; ok {
+		return q.opts
+	}
+	return TaskOptions{}
+}
+
+// push 分块入队，front为true时排在该Task队首（用于重试）
+func (s *scheduler) push(c *Chunk, front bool) {
+	s.Lock()
+	q := s.queue(c.Url)
+	if len(q.chunks) == 0 && q.pass < s.vtime {
+		// 空闲后重新入队的Task从当前虚拟时间开始，不能积攒之前没用的份额
+		q.pass = s.vtime
+	}
+	q.push(c, front)
+	s.size++
+	s.Unlock()
+	s.cond.Signal()
+}
+
+// take 取出下一个分块，队列为空时阻塞等待
+// 调度器关闭或者retire返回true（下载器数被调小）时返回nil；retire在持有调度器锁时调用
+func (s *scheduler) take(retire func() bool) *Chunk {
+	s.Lock()
+	defer s.Unlock()
+	for {
+		if s.closed || retire() {
+			return nil
+		}
+		if c := s.pop(); c != nil {
+			return c
+		}
+		s.cond.Wait()
+	}
+}
+
+// done 分块下载结束（无论成败），释放它占用的主机名额
+func (s *scheduler) done(c *Chunk) {
+	s.Lock()
+	if s.active[c.host]--; s.active[c.host] <= 0 {
+		delete(s.active, c.host)
+	}
+	s.Unlock()
+	if hostMax(c.host) > 0 {
+		s.cond.Broadcast()
+	}
+}
+
+// wake 唤醒所有等待中的take，让它们重新检查retire
+func (s *scheduler) wake() {
+	s.cond.Broadcast()
+}
+
+// close 关闭调度器，等待中和之后的take都返回nil
+func (s *scheduler) close() {
+	s.Lock()
+	s.closed = true
+	s.Unlock()
+	s.cond.Broadcast()
+}
+
+// ready Task是否有可以出队的分块：队列非空且主机的下载器数没有达到上限
+func (s *scheduler) ready(q *taskQueue) bool {
+	if len(q.chunks) == 0 {
+		return false
+	}
+	max := hostMax(q.host)
+	return max <= 0 || s.active[q.host] < max
+}
+
+// pop 取出下一个应下载的分块，没有时返回nil，调用者需持有锁
+// 主机的下载器数达到上限的Task暂时跳过，不影响其他主机的Task
+func (s *scheduler) pop() *Chunk {
+	if s.size == 0 {
+		return nil
+	}
+
+	// 最高优先级
+	best, found := 0, false
+	for _, q := range s.queues {
+		if s.ready(q) && (!found || q.opts.Priority > best) {
+			best, found = q.opts.Priority, true
+		}
+	}
+
+	// 该优先级中虚拟时间最小的Task
+	var min *taskQueue
+	for _, task := range s.order {
+		q := s.queues[task]
+		if s.ready(q) && q.opts.Priority == best && (min == nil || q.pass < min.pass) {
+			min = q
+		}
+	}
+	if min == nil {
+		return nil
+	}
+	c := min.pop()
+	c.host = min.host
+	s.active[c.host]++
+	s.size--
+	s.vtime = min.pass
+	min.pass += min.cost(c)
+	return c
+}
+
+// remove 丢弃Task所有排队中的分块
+func (s *scheduler) remove(task string) {
+	s.Lock()
+	if q, ok := s.queues[task]; ok {
+		s.size -= len(q.chunks)
+		q.chunks = nil
+	}
+	s.Unlock()
+}
+
+// forget 删除Task的队列和选项
+func (s *scheduler) forget(task string) {
+	s.Lock()
+	defer s.Unlock()
+	q, ok := s.queues[task]
+	if !ok {
+		return
+	}
+	s.size -= len(q.chunks)
+	delete(s.queues, task)
+	for i, t := range s.order {
+		if t == task {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// len 排队中的分块总数
+func (s *scheduler) len() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.size
+}
@@ -0,0 +1,240 @@
+package pool
+
+import (
+	"testing"
+	"time"
+)
+
+func pushChunks(s *scheduler, task string, begins ...int64) {
+	for _, b := range begins {
+		s.push(&Chunk{Url: task, Begin: b, End: b}, false)
+	}
+}
+
+// pop 不阻塞地出队一个分块
+func pop(s *scheduler) *Chunk {
+	s.Lock()
+	defer s.Unlock()
+	return s.pop()
+}
+
+// popAll 依次出队，返回"task:begin"序列
+func popAll(s *scheduler) []string {
+	var out []string
+	for c := pop(s); c != nil; c = pop(s) {
+		out = append(out, c.Url+":"+string(rune('0'+c.Begin)))
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestScheduler_Priority(t *testing.T) {
+	s := newScheduler()
+	pushChunks(s, "low", 0, 1)
+	pushChunks(s, "high", 0, 1)
+	s.configure("high", TaskOptions{Priority: 10})
+
+	got := popAll(s)
+	want := []string{"high:0", "high:1", "low:0", "low:1"}
+	if !equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if s.len() != 0 {
+		t.Fatalf("len = %d", s.len())
+	}
+}
+
+func TestScheduler_RoundRobin(t *testing.T) {
+	s := newScheduler()
+	pushChunks(s, "a", 0, 1, 2)
+	pushChunks(s, "b", 0, 1)
+	pushChunks(s, "c", 0)
+
+	got := popAll(s)
+	want := []string{"a:0", "b:0", "c:0", "a:1", "b:1", "a:2"}
+	if !equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestScheduler_InOrderAndRetry(t *testing.T) {
+	s := newScheduler()
+	pushChunks(s, "stream", 3, 1, 2)
+	s.configure("stream", TaskOptions{InOrder: true})
+	pushChunks(s, "fifo", 3, 1)
+	// 重试的分块排在队首
+	s.push(&Chunk{Url: "fifo", Begin: 2, End: 2}, true)
+
+	got := popAll(s)
+	want := []string{"stream:1", "fifo:2", "stream:2", "fifo:3", "stream:3", "fifo:1"}
+	if !equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestScheduler_RemoveAndForget(t *testing.T) {
+	s := newScheduler()
+	// 入队不阻塞
+	for i := 0; i < 10000; i++ {
+		s.push(&Chunk{Url: "big", Begin: int64(i)}, false)
+	}
+	pushChunks(s, "small", 0)
+	if s.len() != 10001 {
+		t.Fatalf("len = %d", s.len())
+	}
+
+	s.remove("big")
+	if s.len() != 1 {
+		t.Fatalf("len after remove = %d", s.len())
+	}
+	s.forget("small")
+	if s.len() != 0 || pop(s) != nil || len(s.order) != 1 {
+		t.Fatalf("len=%d order=%v", s.len(), s.order)
+	}
+}
+
+// popCount 出队n个分块，统计每个Task出队的个数
+func popCount(s *scheduler, n int) map[string]int {
+	cnt := map[string]int{}
+	for i := 0; i < n; i++ {
+		c := pop(s)
+		if c == nil {
+			break
+		}
+		cnt[c.Url]++
+	}
+	return cnt
+}
+
+func TestScheduler_Weighted(t *testing.T) {
+	s := newScheduler()
+	for task, w := range map[string]int{"w1": 1, "w2": 2, "w3": 3} {
+		s.configure(task, TaskOptions{Weight: w})
+		for i := 0; i < 1000; i++ {
+			s.push(&Chunk{Url: task, Begin: int64(i), End: int64(i)}, false)
+		}
+	}
+	// 同样优先级但未设置权重的Task按权重1处理
+	for i := 0; i < 1000; i++ {
+		s.push(&Chunk{Url: "default", Begin: int64(i), End: int64(i)}, false)
+	}
+
+	// 各Task都有充足的分块排队，出队数应与权重成正比
+	cnt := popCount(s, 700)
+	want := map[string]int{"w1": 100, "w2": 200, "w3": 300, "default": 100}
+	for task, n := range want {
+		if d := cnt[task] - n; d < -1 || d > 1 {
+			t.Fatalf("%s: got %d, want about %d (%v)", task, cnt[task], n, cnt)
+		}
+	}
+}
+
+func TestScheduler_WeightedBySize(t *testing.T) {
+	s := newScheduler()
+	// 权重相同时按字节公平：big的分块是small的两倍大
+	for i := int64(0); i < 100; i++ {
+		s.push(&Chunk{Url: "big", Begin: i * 200, End: i*200 + 199}, false)
+		s.push(&Chunk{Url: "small", Begin: i * 100, End: i*100 + 99}, false)
+	}
+	cnt := popCount(s, 90)
+	if cnt["big"] != 30 || cnt["small"] != 60 {
+		t.Fatalf("got %v", cnt)
+	}
+}
+
+func TestScheduler_LateJoin(t *testing.T) {
+	s := newScheduler()
+	for i := 0; i < 100; i++ {
+		s.push(&Chunk{Url: "early", Begin: int64(i), End: int64(i)}, false)
+	}
+	popCount(s, 50)
+
+	// 后加入的Task不能因为之前没有排队而独占下载器
+	for i := 0; i < 100; i++ {
+		s.push(&Chunk{Url: "late", Begin: int64(i), End: int64(i)}, false)
+	}
+	cnt := popCount(s, 20)
+	if cnt["early"] != 10 || cnt["late"] != 10 {
+		t.Fatalf("got %v", cnt)
+	}
+}
+
+func TestScheduler_Take(t *testing.T) {
+	s := newScheduler()
+	never := func() bool { return false }
+
+	// 队列为空时阻塞，入队后被唤醒
+	got := make(chan *Chunk)
+	go func() { got <- s.take(never) }()
+	select {
+	case c := <-got:
+		t.Fatalf("take returned %v on empty queue", c)
+	case <-time.After(20 * time.Millisecond):
+	}
+	pushChunks(s, "a", 1)
+	if c := <-got; c == nil || c.Begin != 1 {
+		t.Fatalf("got %v", c)
+	}
+
+	// retire为true时不再取分块
+	pushChunks(s, "a", 2)
+	if c := s.take(func() bool { return true }); c != nil {
+		t.Fatalf("retired take got %v", c)
+	}
+
+	// 关闭后等待中的take返回nil
+	pop(s)
+	go func() { got <- s.take(never) }()
+	time.Sleep(10 * time.Millisecond)
+	s.close()
+	if c := <-got; c != nil {
+		t.Fatalf("take after close got %v", c)
+	}
+}
+
+func TestScheduler_HostLimit(t *testing.T) {
+	SetHostLimit("a.example", HostLimit{MaxDownloaders: 1})
+	defer SetHostLimit("a.example", HostLimit{})
+
+	s := newScheduler()
+	pushChunks(s, "http://a.example/1", 0, 1)
+	pushChunks(s, "http://a.example:8080/2", 0)
+	pushChunks(s, "http://b.example/1", 0, 1)
+
+	// a.example同时只能有一个分块在下载
+	first := pop(s)
+	if first.host != "a.example" {
+		t.Fatalf("first = %+v", first)
+	}
+	got := popAll(s)
+	want := []string{"http://b.example/1:0", "http://b.example/1:1"}
+	if !equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// 下载结束后释放名额
+	s.done(first)
+	if c := pop(s); c == nil || c.host != "a.example" {
+		t.Fatalf("after done: %+v", c)
+	}
+	if pop(s) != nil {
+		t.Fatal("host limit exceeded")
+	}
+
+	// 取消限制后剩下的分块都可以出队
+	SetHostLimit("a.example", HostLimit{})
+	if c := pop(s); c == nil || s.len() != 0 {
+		t.Fatalf("after unlimit: %+v len=%d", c, s.len())
+	}
+}
@@ -0,0 +1,75 @@
+package pool
+
+import (
+	"sync/atomic"
+)
+
+// PoolStats 下载器池的统计信息，汇总了所有Task
+type PoolStats struct {
+	MaxDownloaders  int `json:"max_downloaders"`
+	BusyDownloaders int `json:"busy_downloaders"`
+	IdleDownloaders int `json:"idle_downloaders"`
+
+	Tasks        int `json:"tasks"`         // 已登记的Task数
+	QueuedChunks int `json:"queued_chunks"` // 排队等待下载的分块数
+	ActiveChunks int `json:"active_chunks"` // 正在下载的分块数，即活跃连接数
+
+	BytesDone  int64 `json:"bytes_done"`
+	ChunksDone int64 `json:"chunks_done"`
+	Retries    int64 `json:"retries"`
+	Failures   int64 `json:"failures"` // 放弃下载的分块数
+
+	Speed    float64 `json:"speed"`     // 瞬时速度 B/s
+	AvgSpeed float64 `json:"avg_speed"` // 滑动平均速度 B/s
+}
+
+// counters cdp的累计计数，使用原子操作
+type counters struct {
+	chunksDone int64
+	retries    int64
+	failures   int64
+}
+
+// Stats 获取下载器池的统计信息
+func Stats() PoolStats {
+	if cdp == nil {
+		return PoolStats{}
+	}
+	return cdp.Stats()
+}
+
+// Stats 获取下载器池的统计信息
+func (cdp *ChunkDownloaderPool) Stats() PoolStats {
+	s := PoolStats{
+		QueuedChunks: cdp.sched.len(),
+		BytesDone:    cdp.meter.Total(),
+		ChunksDone:   atomic.LoadInt64(&cdp.counters.chunksDone),
+		Retries:      atomic.LoadInt64(&cdp.counters.retries),
+		Failures:     atomic.LoadInt64(&cdp.counters.failures),
+	}
+	s.Speed, s.AvgSpeed = cdp.meter.Rate()
+
+	cdp.RLock()
+	s.MaxDownloaders = cdp.maxChunkDownloader
+	s.BusyDownloaders = len(cdp.busyChunkDownloaderMap)
+	s.IdleDownloaders = len(cdp.idleChunkDownloaderMap)
+	cdp.RUnlock()
+
+	entryLock.RLock()
+	s.Tasks = len(entries)
+	for _, e := range entries {
+		s.ActiveChunks += e.active
+	}
+	entryLock.RUnlock()
+	return s
+}
+
+// ActiveChunks Task正在下载的分块数
+func ActiveChunks(task string) int {
+	entryLock.RLock()
+	defer entryLock.RUnlock()
+	if e, ok := entries[task]; ok {
+		return e.active
+	}
+	return 0
+}
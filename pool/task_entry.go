@@ -0,0 +1,189 @@
+package pool
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	entries   map[string]*taskEntry
+	entryLock sync.RWMutex // entries会被多个下载协程并发读取
+)
+
+// NoticeType 分块下载结果类型
+type NoticeType uint8
+
+const (
+	NoticeDone  NoticeType = iota // 分块下载成功
+	NoticeRetry                   // 分块本次下载失败，已重新放回队列
+	NoticeFail                    // 分块失败次数过多，放弃下载
+)
+
+// Notice 分块下载结果通知，由cdp发给注册了通知通道的Task
+type Notice struct {
+	Type   NoticeType
+	Begin  int64
+	End    int64
+	Tried  int   // 已尝试次数
+	Err    error // Type为NoticeRetry/NoticeFail时的错误原因
+	Status int   // Type为NoticeRetry/NoticeFail时最近一次失败的HTTP状态码，不是状态码错误时为0
+}
+
+// taskEntry Task在cdp中的登记信息
+type taskEntry struct {
+	ch   chan<- Notice
+	done chan struct{} // 在RemoveNotify时关闭，避免Task退出后下载协程阻塞在发送上
+
+	paused bool   // 暂停期间该Task的分块不会被下载
+	gen    uint64 // 每次暂停加1，旧的分块(gen不同)出队时直接丢弃
+
+	ctx    context.Context // 用于中断正在下载的分块
+	cancel context.CancelFunc
+
+	active int            // 正在下载的分块数
+	wg     sync.WaitGroup // 正在下载的分块，RemoveNotify等待它们结束
+}
+
+// RegisterNotify 注册Task的通知通道，每个分块下载结束（成功、重试或失败）都会通知
+func RegisterNotify(task string, notify chan<- Notice) {
+	ctx, cancel := context.WithCancel(context.Background())
+	entryLock.Lock()
+	if old, ok := entries[task]; ok {
+		close(old.done)
+		old.cancel()
+	}
+	entries[task] = &taskEntry{
+		ch:     notify,
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	entryLock.Unlock()
+}
+
+// RemoveNotify 注销Task：丢弃排队的分块，中断并等待正在下载的分块结束
+// 返回后不会再有该Task的分块写入数据库，Task可以安全地关闭数据库
+func RemoveNotify(task string) {
+	entryLock.Lock()
+	old, ok := entries[task]
+	if ok {
+		close(old.done)
+		old.cancel()
+		delete(entries, task)
+	}
+	entryLock.Unlock()
+	if cdp != nil {
+		cdp.sched.forget(task)
+	}
+	if ok {
+		old.wg.Wait()
+	}
+}
+
+// Configure 设置Task的调度选项（优先级、权重、顺序下载）和认证信息
+func Configure(task string, opts TaskOptions) {
+	if cdp != nil {
+		cdp.sched.configure(task, opts)
+	}
+}
+
+// Pause 暂停Task：丢弃已排队的分块，释放下载器给其他Task
+// abort为true时同时中断正在下载的分块，否则等它们自然结束
+func Pause(task string, abort bool) {
+	entryLock.Lock()
+	e, ok := entries[task]
+	if !ok || e.paused {
+		entryLock.Unlock()
+		return
+	}
+	e.paused = true
+	e.gen++
+	if abort {
+		e.cancel()
+		e.ctx, e.cancel = context.WithCancel(context.Background())
+	}
+	entryLock.Unlock()
+	// 排队中的分块直接丢弃
+	if cdp != nil {
+		cdp.sched.remove(task)
+	}
+}
+
+// Resume 恢复Task，之后调用Download的分块会正常下载
+// 暂停期间被丢弃的分块需要Task重新提交
+func Resume(task string) {
+	entryLock.Lock()
+	if e, ok := entries[task]; ok {
+		e.paused = false
+	}
+	entryLock.Unlock()
+}
+
+// stamp 为分块记录所属Task当前的gen和ctx
+func stamp(chunk *Chunk) {
+	entryLock.RLock()
+	if e, ok := entries[chunk.Url]; ok {
+		chunk.entry = e
+		chunk.gen = e.gen
+		chunk.ctx = e.ctx
+	}
+	entryLock.RUnlock()
+}
+
+// accepted 分块是否仍需下载
+// Task未登记、已注销或重新登记过（如Manager重试）时，它之前提交的分块都不再下载
+func accepted(chunk *Chunk) bool {
+	entryLock.RLock()
+	defer entryLock.RUnlock()
+	return current(chunk)
+}
+
+// current 调用者需持有entryLock
+func current(chunk *Chunk) bool {
+	e, ok := entries[chunk.Url]
+	return ok && e == chunk.entry && !e.paused && e.gen == chunk.gen
+}
+
+// begin 分块仍需下载时计入所属Task正在下载的分块数，与RemoveNotify互斥
+func begin(chunk *Chunk) bool {
+	entryLock.Lock()
+	defer entryLock.Unlock()
+	if !current(chunk) {
+		return false
+	}
+	chunk.entry.active++
+	chunk.entry.wg.Add(1)
+	return true
+}
+
+// finish 分块下载结束，与begin成对调用
+func finish(chunk *Chunk) {
+	entryLock.Lock()
+	chunk.entry.active--
+	entryLock.Unlock()
+	chunk.entry.wg.Done()
+}
+
+// notify 向Task发送分块下载结果
+func notify(chunk *Chunk, typ NoticeType, err error) {
+	entryLock.RLock()
+	e, ok := entries[chunk.Url]
+	entryLock.RUnlock()
+	if !ok || e.ch == nil {
+		return
+	}
+	n := Notice{
+		Type:  typ,
+		Begin: chunk.Begin,
+		End:   chunk.End,
+		Tried: chunk.tried,
+		Err:   err,
+	}
+	if typ != NoticeDone {
+		n.Status = chunk.status
+	}
+	select {
+	case e.ch <- n:
+	case <-e.done:
+	}
+}
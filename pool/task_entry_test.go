@@ -0,0 +1,70 @@
+package pool
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/edb"
+)
+
+func TestRemoveNotify_WaitsActiveChunks(t *testing.T) {
+	if err := Init(2); err != nil {
+		t.Fatal(err)
+	}
+	Start()
+	defer Stop()
+
+	var requests int32
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		started <- struct{}{}
+		<-r.Context().Done() // 直到请求被中断
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := edb.OpenEDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	url := srv.URL + "/a.bin"
+	RegisterNotify(url, make(chan Notice))
+	Download(Chunk{Begin: 0, End: 99, Url: url, Db: db, DataKey: "D0", TaskKey: "T0"})
+	<-started
+	if ActiveChunks(url) != 1 {
+		t.Fatalf("active = %d", ActiveChunks(url))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		RemoveNotify(url)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RemoveNotify did not return")
+	}
+
+	// 注销后提交的分块不会下载，失败的分块也不会重新排队
+	Download(Chunk{Begin: 100, End: 199, Url: url, Db: db, DataKey: "D1", TaskKey: "T1"})
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("requests = %d", n)
+	}
+	if s := Stats(); s.QueuedChunks != 0 || s.ActiveChunks != 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
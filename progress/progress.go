@@ -0,0 +1,322 @@
+// Package progress 在终端显示多个下载任务的进度
+//
+// 输出到终端时，每个未结束的任务占一行进度条，最后一行是汇总信息，定时原地刷新；
+// 输出不是终端时（重定向到文件、cron等），改为定时打印普通的日志行。
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+const (
+	// TTYInterval 终端模式的刷新间隔
+	TTYInterval = 500 * time.Millisecond
+	// LogInterval 非终端模式打印进度的间隔
+	LogInterval = 10 * time.Second
+	// MaxRows 终端模式最多同时显示的任务行数，其余的只计入汇总
+	MaxRows = 20
+
+	barWidth  = 30
+	nameWidth = 44
+)
+
+// source 提供统计信息的下载任务，一般是*task.Task
+type source interface {
+	Stats() task.Stats
+}
+
+type state uint8
+
+const (
+	stateActive state = iota
+	stateDone
+	stateFailed
+)
+
+type item struct {
+	name  string
+	src   source
+	state state
+	err   error
+	shown bool // 结束信息是否已打印
+}
+
+// Renderer 多任务进度显示
+type Renderer struct {
+	w        io.Writer
+	tty      bool
+	interval time.Duration
+
+	mu      sync.Mutex
+	items   []*item
+	lines   int // 终端模式下当前进度区域的行数
+	stopped bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New 创建进度显示，w为终端时使用原地刷新的进度条
+func New(w io.Writer) *Renderer {
+	r := &Renderer{
+		w:    w,
+		tty:  IsTerminal(w),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	r.interval = LogInterval
+	if r.tty {
+		r.interval = TTYInterval
+	}
+	return r
+}
+
+// IsTerminal w是否是字符设备（终端）
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// Add 添加一个任务，任务结束（完成或失败）时由事件回调更新状态
+func (r *Renderer) Add(name string, t *task.Task) {
+	it := r.add(name, t)
+	t.OnEvent(func(e task.Event) {
+		switch e.Type {
+		case task.EventCompleted:
+			r.finish(it, stateDone, nil)
+		case task.EventFailed:
+			r.finish(it, stateFailed, e.Err)
+		}
+	})
+}
+
+// add 添加一个统计来源，结束时需要调用finish
+func (r *Renderer) add(name string, src source) *item {
+	it := &item{name: name, src: src}
+	r.mu.Lock()
+	r.items = append(r.items, it)
+	r.mu.Unlock()
+	return it
+}
+
+func (r *Renderer) finish(it *item, st state, err error) {
+	r.mu.Lock()
+	it.state = st
+	it.err = err
+	r.mu.Unlock()
+}
+
+// Start 开始定时刷新
+func (r *Renderer) Start() {
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.render(false)
+			case <-r.stop:
+				r.render(true)
+				r.mu.Lock()
+				r.stopped = true
+				r.mu.Unlock()
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止刷新并输出最终状态
+func (r *Renderer) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+// Write 实现io.Writer，用于承接日志输出(log.SetOutput)
+// 终端模式下日志打印在进度区域之上，避免把进度条冲乱
+func (r *Renderer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.tty || r.stopped {
+		return r.w.Write(p)
+	}
+	r.clear()
+	n, err := r.w.Write(p)
+	r.draw()
+	return n, err
+}
+
+// render 输出一次进度，final表示最后一次
+func (r *Renderer) render(final bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tty {
+		r.clear()
+		r.flushFinished()
+		if !final {
+			r.draw()
+		} else {
+			fmt.Fprintln(r.w, r.summary())
+		}
+		return
+	}
+	r.flushFinished()
+	for _, it := range r.items {
+		if it.state == stateActive {
+			fmt.Fprintln(r.w, plainLine(it.name, it.src.Stats()))
+		}
+	}
+	fmt.Fprintln(r.w, r.summary())
+}
+
+// flushFinished 为刚结束的任务打印一行永久信息
+func (r *Renderer) flushFinished() {
+	for _, it := range r.items {
+		if it.state == stateActive || it.shown {
+			continue
+		}
+		it.shown = true
+		s := it.src.Stats()
+		if it.state == stateDone {
+			fmt.Fprintf(r.w, "done   %s  %s in %s\n", it.name, FormatBytes(s.BytesDone), FormatDuration(s.Elapsed))
+		} else {
+			fmt.Fprintf(r.w, "failed %s: %v\n", it.name, it.err)
+		}
+	}
+}
+
+// clear 清除终端中的进度区域，光标回到区域开头
+func (r *Renderer) clear() {
+	if r.lines > 0 {
+		fmt.Fprintf(r.w, "\033[%dA", r.lines)
+		for i := 0; i < r.lines; i++ {
+			fmt.Fprint(r.w, "\033[2K\n")
+		}
+		fmt.Fprintf(r.w, "\033[%dA", r.lines)
+	}
+	r.lines = 0
+}
+
+// draw 绘制进度区域：每个未结束任务一行，加一行汇总
+func (r *Renderer) draw() {
+	rows := 0
+	for _, it := range r.items {
+		if it.state != stateActive {
+			continue
+		}
+		if rows == MaxRows {
+			break
+		}
+		fmt.Fprintf(r.w, "\033[2K%s\n", barLine(it.name, it.src.Stats()))
+		rows++
+	}
+	fmt.Fprintf(r.w, "\033[2K%s\n", r.summary())
+	r.lines = rows + 1
+}
+
+// summary 汇总所有任务
+func (r *Renderer) summary() string {
+	var (
+		active, done, failed int
+		bytes                int64
+		speed                float64
+	)
+	for _, it := range r.items {
+		switch it.state {
+		case stateActive:
+			active++
+		case stateDone:
+			done++
+		case stateFailed:
+			failed++
+		}
+		s := it.src.Stats()
+		bytes += s.BytesDone
+		if it.state == stateActive {
+			speed += s.Speed
+		}
+	}
+	return fmt.Sprintf("Total: %d active, %d done, %d failed  %s  %s/s",
+		active, done, failed, FormatBytes(bytes), FormatBytes(int64(speed)))
+}
+
+// barLine 终端模式的单个任务行
+func barLine(name string, s task.Stats) string {
+	ratio := 0.0
+	if s.BytesTotal > 0 {
+		ratio = float64(s.BytesDone) / float64(s.BytesTotal)
+	}
+	n := int(ratio * barWidth)
+	bar := strings.Repeat("=", n)
+	if n < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-n-1)
+	}
+	status := fmt.Sprintf("%s/s ETA %s", FormatBytes(int64(s.Speed)), FormatDuration(s.ETA))
+	if s.Paused {
+		status = "paused"
+	}
+	return fmt.Sprintf("%-*s [%s] %5.1f%% %s/%s %s",
+		nameWidth, shorten(name, nameWidth), bar, ratio*100,
+		FormatBytes(s.BytesDone), FormatBytes(s.BytesTotal), status)
+}
+
+// plainLine 非终端模式的单个任务行
+func plainLine(name string, s task.Stats) string {
+	return fmt.Sprintf("%s: %s/%s chunks %d/%d speed %s/s eta %s conns %d retries %d",
+		name, FormatBytes(s.BytesDone), FormatBytes(s.BytesTotal), s.ChunksDone, s.ChunksTotal,
+		FormatBytes(int64(s.Speed)), FormatDuration(s.ETA), s.ActiveConns, s.Retries)
+}
+
+// shorten 过长的名称保留末尾部分
+func shorten(name string, width int) string {
+	r := []rune(name)
+	if len(r) <= width {
+		return name
+	}
+	return "..." + string(r[len(r)-width+3:])
+}
+
+// FormatBytes 以1024为进制格式化字节数，负数表示未知
+func FormatBytes(n int64) string {
+	if n < 0 {
+		return "?"
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// FormatDuration 格式化为h:mm:ss或m:ss，负数表示未知
+func FormatDuration(d time.Duration) string {
+	if d < 0 {
+		return "--:--"
+	}
+	sec := int64(d.Round(time.Second) / time.Second)
+	h, m, s := sec/3600, sec/60%60, sec%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
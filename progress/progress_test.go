@@ -0,0 +1,78 @@
+package progress
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+type fakeSource task.Stats
+
+func (f *fakeSource) Stats() task.Stats { return task.Stats(*f) }
+
+func TestRenderer_Plain(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(&buf)
+	if r.tty {
+		t.Fatal("buffer should not be a terminal")
+	}
+
+	a := &fakeSource{BytesDone: 512, BytesTotal: 2048, ChunksDone: 1, ChunksTotal: 4, ETA: -1}
+	b := &fakeSource{BytesDone: 100, BytesTotal: 100, Elapsed: 3 * time.Second}
+	c := &fakeSource{BytesTotal: 100}
+	r.add("a.tsv.gz", a)
+	itB := r.add("b.tsv.gz", b)
+	itC := r.add("c.tsv.gz", c)
+	r.finish(itB, stateDone, nil)
+	r.finish(itC, stateFailed, errors.New("404 Not Found"))
+
+	r.render(false)
+	out := buf.String()
+	for _, want := range []string{
+		"a.tsv.gz: 512B/2.0KiB chunks 1/4",
+		"done   b.tsv.gz  100B in 0:03",
+		"failed c.tsv.gz: 404 Not Found",
+		"Total: 1 active, 1 done, 1 failed",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+
+	// 结束信息只打印一次
+	buf.Reset()
+	r.render(false)
+	if strings.Contains(buf.String(), "b.tsv.gz") {
+		t.Errorf("finished task printed twice:\n%s", buf.String())
+	}
+}
+
+func TestFormat(t *testing.T) {
+	bytesCases := map[int64]string{
+		-1:              "?",
+		0:               "0B",
+		1023:            "1023B",
+		1536:            "1.5KiB",
+		5 * 1024 * 1024: "5.0MiB",
+	}
+	for n, want := range bytesCases {
+		if got := FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%d) = %s, want %s", n, got, want)
+		}
+	}
+	durCases := map[time.Duration]string{
+		-1:                          "--:--",
+		59 * time.Second:            "0:59",
+		61 * time.Minute:            "1:01:00",
+		2*time.Minute + time.Second: "2:01",
+	}
+	for d, want := range durCases {
+		if got := FormatDuration(d); got != want {
+			t.Errorf("FormatDuration(%s) = %s, want %s", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Package speed 提供并发安全的下载速度统计
+package speed
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// Window 计算瞬时速度的时间窗口
+	Window = 3 * time.Second
+	// avgTau 滑动平均的时间常数，越大越平滑
+	avgTau = 10 * time.Second
+	// avgStep 滑动平均最短的更新间隔
+	avgStep = 500 * time.Millisecond
+)
+
+type sample struct {
+	at time.Time
+	n  int64
+}
+
+// Meter 速度计
+// 瞬时速度取最近Window内的数据量，平均速度为指数滑动平均
+type Meter struct {
+	mu      sync.Mutex
+	total   int64
+	samples []sample // 最近Window内的数据
+
+	avg       float64 // 滑动平均速度 B/s
+	lastTick  time.Time
+	lastTotal int64
+}
+
+// NewMeter 创建速度计，从现在开始计时
+func NewMeter() *Meter {
+	return &Meter{lastTick: time.Now()}
+}
+
+// Add 记录新下载的n字节
+func (m *Meter) Add(n int64) {
+	now := time.Now()
+	m.mu.Lock()
+	m.total += n
+	m.samples = append(m.samples, sample{at: now, n: n})
+	m.update(now)
+	m.mu.Unlock()
+}
+
+// Total 已记录的总字节数
+func (m *Meter) Total() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.total
+}
+
+// Rate 返回瞬时速度和滑动平均速度，单位B/s
+func (m *Meter) Rate() (instant, average float64) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.update(now)
+
+	var n int64
+	for _, s := range m.samples {
+		n += s.n
+	}
+	return float64(n) / Window.Seconds(), m.avg
+}
+
+// Reset 清空速度（如暂停后），总量保留
+func (m *Meter) Reset() {
+	m.mu.Lock()
+	m.samples = nil
+	m.avg = 0
+	m.lastTick = time.Now()
+	m.lastTotal = m.total
+	m.mu.Unlock()
+}
+
+// update 淘汰过期样本并更新滑动平均，调用者需持有锁
+func (m *Meter) update(now time.Time) {
+	i := 0
+	for i < len(m.samples) && now.Sub(m.samples[i].at) > Window {
+		i++
+	}
+	if i > 0 {
+		m.samples = append(m.samples[:0], m.samples[i:]...)
+	}
+
+	elapsed := now.Sub(m.lastTick)
+	if elapsed < avgStep {
+		return
+	}
+	rate := float64(m.total-m.lastTotal) / elapsed.Seconds()
+	w := 1 - math.Exp(-elapsed.Seconds()/avgTau.Seconds())
+	m.avg += w * (rate - m.avg)
+	m.lastTick = now
+	m.lastTotal = m.total
+}
+
+// ETA 根据速度估计剩余时间，速度为0时返回-1
+func ETA(left int64, rate float64) time.Duration {
+	if left <= 0 {
+		return 0
+	}
+	if rate <= 0 {
+		return -1
+	}
+	return time.Duration(float64(left) / rate * float64(time.Second))
+}
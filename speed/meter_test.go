@@ -0,0 +1,39 @@
+package speed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeter(t *testing.T) {
+	m := NewMeter()
+	m.Add(1000)
+	m.Add(2000)
+	if m.Total() != 3000 {
+		t.Fatalf("total = %d", m.Total())
+	}
+	instant, _ := m.Rate()
+	if want := 3000 / Window.Seconds(); instant != want {
+		t.Fatalf("instant = %f, want %f", instant, want)
+	}
+
+	m.Reset()
+	if instant, avg := m.Rate(); instant != 0 || avg != 0 {
+		t.Fatalf("after reset: instant=%f avg=%f", instant, avg)
+	}
+	if m.Total() != 3000 {
+		t.Fatalf("reset should keep total, got %d", m.Total())
+	}
+}
+
+func TestETA(t *testing.T) {
+	if ETA(0, 0) != 0 {
+		t.Error("nothing left should be 0")
+	}
+	if ETA(100, 0) != -1 {
+		t.Error("zero rate should be unknown")
+	}
+	if got := ETA(100, 50); got != 2*time.Second {
+		t.Errorf("ETA = %s", got)
+	}
+}
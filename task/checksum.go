@@ -0,0 +1,81 @@
+package task
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// hashes Options.Checksum支持的算法，名字与aria2相同
+var hashes = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha-1":   sha1.New,
+	"sha-224": sha256.New224,
+	"sha-256": sha256.New,
+	"sha-384": sha512.New384,
+	"sha-512": sha512.New,
+}
+
+// ChecksumError 下载的文件校验和不符
+type ChecksumError struct {
+	File string
+	Algo string
+	Want string
+	Got  string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s: %s mismatch, want %s, got %s", e.File, e.Algo, e.Want, e.Got)
+}
+
+// parseChecksum 解析 算法=十六进制摘要，如 sha-256=e3b0c442...
+func parseChecksum(s string) (algo string, sum []byte, err error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return "", nil, fmt.Errorf("invalid checksum %q, want ALGO=HEX", s)
+	}
+	algo = strings.ToLower(strings.TrimSpace(kv[0]))
+	newHash, ok := hashes[algo]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported checksum algorithm %q", kv[0])
+	}
+	sum, err = hex.DecodeString(strings.TrimSpace(kv[1]))
+	if err != nil || len(sum) != newHash().Size() {
+		return "", nil, fmt.Errorf("invalid %s digest %q", algo, kv[1])
+	}
+	return algo, sum, nil
+}
+
+// verify 校验下载完成的文件，不符时删除文件（避免下次被当作已下载）并返回*ChecksumError
+func (t *Task) verify() error {
+	if t.Options.Checksum == "" {
+		return nil
+	}
+	algo, want, err := parseChecksum(t.Options.Checksum)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(t.FileName)
+	if err != nil {
+		return err
+	}
+	h := hashes[algo]()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want) {
+		os.Remove(t.FileName)
+		return &ChecksumError{File: t.FileName, Algo: algo, Want: hex.EncodeToString(want), Got: hex.EncodeToString(got)}
+	}
+	return nil
+}
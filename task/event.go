@@ -0,0 +1,104 @@
+package task
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgressInterval 两次EventProgress事件之间的最小间隔
+var ProgressInterval = time.Second
+
+// EventType 任务事件类型
+type EventType uint8
+
+const (
+	EventQueued    EventType = iota // 任务已排队，等待下载
+	EventStarted                    // 任务开始下载（分块已交给下载器池）
+	EventChunkDone                  // 一个分块下载完成
+	EventProgress                   // 周期性的进度通知
+	EventRetrying                   // 一个分块下载失败，正在重试
+	EventPaused                     // 任务已暂停
+	EventResumed                    // 任务已恢复
+	EventCompleted                  // 任务下载完成
+	EventFailed                     // 任务下载失败
+	EventRemoved                    // 任务已从Manager中删除，见Manager.Remove
+)
+
+var eventTypeNames = [...]string{
+	EventQueued:    "queued",
+	EventStarted:   "started",
+	EventChunkDone: "chunk_done",
+	EventProgress:  "progress",
+	EventRetrying:  "retrying",
+	EventPaused:    "paused",
+	EventResumed:   "resumed",
+	EventCompleted: "completed",
+	EventFailed:    "failed",
+	EventRemoved:   "removed",
+}
+
+func (e EventType) String() string {
+	if int(e) < len(eventTypeNames) {
+		return eventTypeNames[e]
+	}
+	return "unknown"
+}
+
+// Event 任务生命周期中的一个事件
+type Event struct {
+	Type  EventType
+	Task  *Task  // Manager中尚未创建Task的任务为nil
+	Url   string
+	JobID uint64   // 由Manager管理的任务的编号，否则为0
+	Tags  []string // 由Manager管理的任务的标签(Options.Tags)
+	Time  time.Time
+
+	// 分块相关事件(EventChunkDone/EventRetrying)的分块范围与尝试次数
+	Begin int64
+	End   int64
+	Tried int
+
+	// 事件发生时的分块进度
+	ChunkDone int64
+	ChunkNum  int64
+
+	Err error // EventRetrying/EventFailed的错误原因
+}
+
+// EventHandler 事件回调
+// 回调一般在Task的下载协程中同步执行，因此不应阻塞；
+// EventPaused/EventResumed在调用Pause/Resume的协程中执行，回调需要并发安全
+type EventHandler func(Event)
+
+// eventHub 保存Task的事件回调
+type eventHub struct {
+	sync.RWMutex
+	handlers []EventHandler
+}
+
+// OnEvent 注册事件回调，可多次调用注册多个
+func (t *Task) OnEvent(fn EventHandler) *Task {
+	if fn == nil {
+		return t
+	}
+	t.events.Lock()
+	t.events.handlers = append(t.events.handlers, fn)
+	t.events.Unlock()
+	return t
+}
+
+// emit 补全事件的公共字段并依次调用回调
+func (t *Task) emit(e Event) {
+	e.Task = t
+	e.Url = t.Url
+	e.Time = time.Now()
+	e.ChunkNum = t.ChunkNum
+	e.ChunkDone = t.ChunkNum - t.chunkLeft()
+
+	t.events.RLock()
+	handlers := t.events.handlers
+	t.events.RUnlock()
+	for _, fn := range handlers {
+		fn(e)
+	}
+}
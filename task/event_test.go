@@ -0,0 +1,183 @@
+package task
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/pool"
+)
+
+// newTestServer 返回一个支持Range请求的本地文件服务器
+func newTestServer(data []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+	}))
+}
+
+// testFileName 生成不重复的文件名，避免与已有下载冲突
+func testFileName() string {
+	return fmt.Sprintf("test-%d.bin", time.Now().UnixNano())
+}
+
+func removeTaskFiles(task *Task) {
+	os.RemoveAll(task.FileName)
+	os.RemoveAll(task.DbPath)
+	os.Remove(DownloadDir) // 目录为空时才会删除
+}
+
+func TestTask_Events(t *testing.T) {
+	if err := pool.Init(3); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 3*DefaultChunkSize+100)
+	rand.Read(data)
+	srv := newTestServer(data)
+	defer srv.Close()
+
+	task, err := NewTask(srv.URL + "/" + testFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeTaskFiles(task)
+
+	var (
+		lock   sync.Mutex
+		events []EventType
+	)
+	task.OnEvent(func(e Event) {
+		lock.Lock()
+		events = append(events, e.Type)
+		lock.Unlock()
+	})
+
+	if err = task.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(task.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("file mismatch: len(got)=%d, len(data)=%d", len(got), len(data))
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(events) < 2 || events[0] != EventQueued || events[1] != EventStarted {
+		t.Fatalf("unexpected leading events: %v", events)
+	}
+	if events[len(events)-1] != EventCompleted {
+		t.Fatalf("last event should be completed: %v", events)
+	}
+	chunkDone := 0
+	for _, e := range events {
+		if e == EventChunkDone {
+			chunkDone++
+		}
+	}
+	if int64(chunkDone) != task.ChunkNum {
+		t.Fatalf("chunk_done events = %d, want %d", chunkDone, task.ChunkNum)
+	}
+}
+
+func TestTask_PauseResume(t *testing.T) {
+	if err := pool.Init(2); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 8*DefaultChunkSize)
+	rand.Read(data)
+	// gate关闭前，所有分块请求都被挂起
+	gate := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			select {
+			case <-gate:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	task, err := NewTask(srv.URL + "/" + testFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeTaskFiles(task)
+
+	var (
+		lock      sync.Mutex
+		chunkDone int
+	)
+	started := make(chan struct{})
+	task.OnEvent(func(e Event) {
+		switch e.Type {
+		case EventStarted:
+			close(started)
+		case EventChunkDone:
+			lock.Lock()
+			chunkDone++
+			lock.Unlock()
+		}
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- task.Start()
+	}()
+
+	<-started
+	if err = task.Pause(true); err != nil {
+		t.Fatal(err)
+	}
+	if !task.Paused() {
+		t.Fatal("task should be paused")
+	}
+	// 放开请求，暂停期间不应有分块完成
+	close(gate)
+	time.Sleep(200 * time.Millisecond)
+	lock.Lock()
+	if chunkDone != 0 {
+		t.Fatalf("%d chunks downloaded while paused", chunkDone)
+	}
+	lock.Unlock()
+
+	if err = task.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("task not finished after resume")
+	}
+
+	got, err := ioutil.ReadFile(task.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("file mismatch: len(got)=%d, len(data)=%d", len(got), len(data))
+	}
+	if int64(chunkDone) != task.ChunkNum {
+		t.Fatalf("chunk_done events = %d, want %d", chunkDone, task.ChunkNum)
+	}
+}
@@ -0,0 +1,394 @@
+package task
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/edb"
+	"github.com/azd1997/blockchair_downloader/httpclient"
+)
+
+var (
+	ErrJobNotFound  = errors.New("job not found")
+	ErrJobStarting  = errors.New("job is starting, try again later")
+	ErrJobFinished  = errors.New("job already finished")
+	ErrJobNotFailed = errors.New("job has not failed")
+)
+
+// JobState Manager中任务的状态
+type JobState uint8
+
+const (
+	JobQueued    JobState = iota // 排队中，尚未创建Task
+	JobActive                    // 正在下载
+	JobCompleted                 // 下载完成
+	JobFailed                    // 下载失败
+	JobPaused                    // 已暂停：排队中的不会开始，下载中的不再提交分块（仍占用名额）
+)
+
+var jobStateNames = [...]string{
+	JobQueued:    "queued",
+	JobActive:    "active",
+	JobCompleted: "completed",
+	JobFailed:    "failed",
+	JobPaused:    "paused",
+}
+
+func (s JobState) String() string {
+	if int(s) < len(jobStateNames) {
+		return jobStateNames[s]
+	}
+	return "unknown"
+}
+
+// Job Manager中的一个下载任务
+// Task在轮到该任务下载时才创建（需要HEAD请求并打开数据库）
+type Job struct {
+	ID      uint64
+	Url     string
+	Options Options
+
+	state   JobState
+	task    *Task
+	removed bool  // 下载中被Remove，结束后从Manager中删除
+	waited  bool  // 计入Manager.Wait，见hold
+	dropped *Task // Resume失败时已经结束的Task，它的run不再更新任务
+	err     error
+	addTime time.Time
+	endTime time.Time
+
+	seq   uint64 // 同优先级按提交顺序
+	index int    // 在jobHeap中的下标
+}
+
+// JobStatus Job某一时刻的快照
+type JobStatus struct {
+	ID      uint64    `json:"id"`
+	Url     string    `json:"url"`
+	Options Options   `json:"options"`
+	State   JobState  `json:"state"`
+	Err     error     `json:"-"`
+	Stats   Stats     `json:"stats"`
+	File    string    `json:"file"` // 保存的文件路径，任务开始后才有
+	AddTime time.Time `json:"add_time"`
+	EndTime time.Time `json:"end_time"`
+}
+
+// Result Manager的汇总结果
+type Result struct {
+	Completed int
+	Failed    int
+	Jobs      []JobStatus
+}
+
+// Manager 下载队列管理
+// 接受任意多的下载请求，同时最多运行maxActive个Task，所有Task共享cdp
+type Manager struct {
+	mu        sync.Mutex
+	maxActive int
+	active    int
+	queue     jobHeap
+	jobs      map[uint64]*Job
+	order     []*Job            // 按提交顺序
+	byUrl     map[string][]*Job // 按url，见Lookup
+	nextID    uint64
+
+	handlers []EventHandler
+	wg       sync.WaitGroup
+	auth     *httpclient.Auth // 没有设置Options.Auth的任务使用的认证信息
+
+	db edb.DB // 保存任务状态，为nil时不持久化，见NewPersistentManager
+}
+
+// NewManager 创建Manager，maxActive为同时下载的最大任务数
+func NewManager(maxActive int) *Manager {
+	if maxActive <= 0 {
+		maxActive = 1
+	}
+	return &Manager{
+		maxActive: maxActive,
+		jobs:      map[uint64]*Job{},
+		byUrl:     map[string][]*Job{},
+	}
+}
+
+// OnEvent 注册事件回调，会收到所有任务的事件
+// 多个任务的事件可能在不同协程中同时回调，回调需要并发安全
+func (m *Manager) OnEvent(fn EventHandler) *Manager {
+	if fn != nil {
+		m.mu.Lock()
+		m.handlers = append(m.handlers, fn)
+		m.mu.Unlock()
+	}
+	return m
+}
+
+// SetAuth 设置默认的认证信息，用于Options.Auth为nil的任务
+// Options.Auth不会持久化，从状态数据库恢复的任务也使用这里的设置
+func (m *Manager) SetAuth(a *httpclient.Auth) *Manager {
+	m.mu.Lock()
+	m.auth = a
+	m.mu.Unlock()
+	return m
+}
+
+// Add 提交下载请求，立即返回
+func (m *Manager) Add(url string, opts Options) *Job {
+	m.mu.Lock()
+	m.nextID++
+	job := &Job{
+		ID:      m.nextID,
+		Url:     url,
+		Options: opts,
+		state:   JobQueued,
+		addTime: time.Now(),
+		seq:     m.nextID,
+	}
+	m.remember(job)
+	heap.Push(&m.queue, job)
+	m.hold(job)
+	m.save(job)
+	m.mu.Unlock()
+
+	m.emit(job, Event{Type: EventQueued, Url: url, Time: job.addTime})
+	m.schedule()
+	return job
+}
+
+// Wait 等待所有已提交的任务结束，返回汇总结果
+// 暂停的任务不计入，Resume后重新计入
+func (m *Manager) Wait() Result {
+	m.wg.Wait()
+	return m.Result()
+}
+
+// Result 当前的汇总结果
+func (m *Manager) Result() Result {
+	var r Result
+	r.Jobs = m.Jobs()
+	for _, j := range r.Jobs {
+		switch j.State {
+		case JobCompleted:
+			r.Completed++
+		case JobFailed:
+			r.Failed++
+		}
+	}
+	return r
+}
+
+// Jobs 按提交顺序返回所有任务的快照
+func (m *Manager) Jobs() []JobStatus {
+	m.mu.Lock()
+	jobs := make([]*Job, len(m.order))
+	copy(jobs, m.order)
+	m.mu.Unlock()
+
+	ss := make([]JobStatus, len(jobs))
+	for i, j := range jobs {
+		ss[i] = m.status(j)
+	}
+	return ss
+}
+
+// Job 获取单个任务的快照
+func (m *Manager) Job(id uint64) (JobStatus, error) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return JobStatus{}, ErrJobNotFound
+	}
+	return m.status(j), nil
+}
+
+// Lookup 获取url对应任务的快照，有多个时返回最后提交的未失败任务，都失败时返回最后一个
+func (m *Manager) Lookup(url string) (JobStatus, bool) {
+	m.mu.Lock()
+	var found *Job
+	for _, j := range m.byUrl[url] {
+		if found == nil || found.state == JobFailed || j.state != JobFailed {
+			found = j
+		}
+	}
+	m.mu.Unlock()
+	if found == nil {
+		return JobStatus{}, false
+	}
+	return m.status(found), true
+}
+
+// Task 获取任务对应的Task，尚未开始的任务返回nil
+func (m *Manager) Task(id uint64) *Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, ok := m.jobs[id]; ok {
+		return j.task
+	}
+	return nil
+}
+
+func (m *Manager) status(j *Job) JobStatus {
+	m.mu.Lock()
+	s := JobStatus{
+		ID:      j.ID,
+		Url:     j.Url,
+		Options: j.Options,
+		State:   j.state,
+		Err:     j.err,
+		AddTime: j.addTime,
+		EndTime: j.endTime,
+	}
+	t := j.task
+	m.mu.Unlock()
+	if t != nil {
+		s.Stats = t.Stats()
+		s.File = t.FileName
+	}
+	return s
+}
+
+// schedule 在有空闲名额时按优先级启动排队的任务
+func (m *Manager) schedule() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.active < m.maxActive && m.queue.Len() > 0 {
+		job := heap.Pop(&m.queue).(*Job)
+		job.state = JobActive
+		m.save(job)
+		m.active++
+		go m.run(job)
+	}
+}
+
+// run 创建并执行Task，结束后释放名额
+func (m *Manager) run(job *Job) {
+	t, err := m.start(job)
+
+	m.mu.Lock()
+	if t != nil && job.dropped == t { // Resume失败时已经结束并释放了名额
+		job.dropped = nil
+		m.mu.Unlock()
+		return
+	}
+	job.endTime = time.Now()
+	if err != nil {
+		job.state = JobFailed
+		job.err = err
+	} else {
+		job.state = JobCompleted
+	}
+	removed := job.removed
+	if removed {
+		m.forget(job)
+	} else {
+		m.save(job)
+	}
+	m.active--
+	m.release(job)
+	m.mu.Unlock()
+
+	if removed {
+		m.emit(job, Event{Type: EventRemoved, Url: job.Url, Time: time.Now()})
+	}
+	m.schedule()
+}
+
+func (m *Manager) start(job *Job) (*Task, error) {
+	m.mu.Lock()
+	opts := job.Options
+	if opts.Auth == nil {
+		opts.Auth = m.auth
+	}
+	m.mu.Unlock()
+
+	t, err := newTask(job.Url, opts, "job", job.ID)
+	if err != nil {
+		logger.Error("task failed", "job", job.ID, "url", httpclient.Redact(job.Url), "err", err)
+		m.emit(job, Event{Type: EventFailed, Url: job.Url, Time: time.Now(), Err: err})
+		return nil, err
+	}
+	t.queued = true // EventQueued已由Manager发出
+	t.OnEvent(func(e Event) {
+		m.mu.Lock()
+		dropped := job.dropped == t
+		m.mu.Unlock()
+		if !dropped { // Resume失败时Manager已经发出EventFailed
+			m.emit(job, e)
+		}
+	})
+
+	m.mu.Lock()
+	job.task = t
+	removed := job.removed
+	m.mu.Unlock()
+	if removed { // 创建Task期间被Remove
+		t.Cancel()
+	}
+
+	return t, t.Start()
+}
+
+// hold 任务计入Wait，调用者需持有m.mu
+func (m *Manager) hold(job *Job) {
+	if !job.waited {
+		job.waited = true
+		m.wg.Add(1)
+	}
+}
+
+// release 任务不再计入Wait，调用者需持有m.mu
+func (m *Manager) release(job *Job) {
+	if job.waited {
+		job.waited = false
+		m.wg.Done()
+	}
+}
+
+// emit 将任务事件转发给Manager的回调
+func (m *Manager) emit(job *Job, e Event) {
+	e.JobID = job.ID
+	m.mu.Lock()
+	e.Tags = job.Options.Tags
+	handlers := m.handlers
+	m.mu.Unlock()
+	for _, fn := range handlers {
+		fn(e)
+	}
+}
+
+///////////////////////// 堆,按优先级排序任务 ///////////////////////////
+
+// jobHeap 优先级大的在堆顶，相同优先级先提交的在前
+type jobHeap []*Job
+
+func (h jobHeap) Len() int { return len(h) }
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].Options.Priority == h[j].Options.Priority {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].Options.Priority > h[j].Options.Priority
+}
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	job := x.(*Job)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	x.index = -1
+	*h = old[0 : n-1]
+	return x
+}
@@ -0,0 +1,290 @@
+package task
+
+import (
+	"container/heap"
+	"errors"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+)
+
+// MaxActive 同时下载的最大任务数
+func (m *Manager) MaxActive() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.maxActive
+}
+
+// SetMaxActive 调整同时下载的最大任务数
+// 调大后排队的任务马上开始；调小时正在下载的任务会继续下完
+func (m *Manager) SetMaxActive(n int) error {
+	if n <= 0 {
+		return errors.New("manager need at least 1 active job")
+	}
+	m.mu.Lock()
+	m.maxActive = n
+	m.mu.Unlock()
+	m.schedule()
+	return nil
+}
+
+// Pause 暂停任务，暂停的任务不计入Wait
+// 排队中的任务不再开始；下载中的任务不再提交分块，正在下载的分块下完为止，任务仍占用名额
+func (m *Manager) Pause(id uint64) error {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return ErrJobNotFound
+	}
+	switch job.state {
+	case JobPaused:
+		m.mu.Unlock()
+		return nil
+	case JobCompleted, JobFailed:
+		m.mu.Unlock()
+		return ErrJobFinished
+	case JobQueued:
+		heap.Remove(&m.queue, job.index)
+		job.state = JobPaused
+		m.release(job)
+		m.save(job)
+		m.mu.Unlock()
+		m.emit(job, Event{Type: EventPaused, Url: job.Url, Time: time.Now()})
+		return nil
+	}
+
+	t, removed := job.task, job.removed
+	m.mu.Unlock()
+	if removed {
+		return ErrJobNotFound
+	}
+	if t == nil {
+		return ErrJobStarting
+	}
+	// 下载中的任务由Task发出EventPaused
+	if err := t.Pause(false); err != nil {
+		if err == ErrNotRunning {
+			err = ErrJobStarting
+		}
+		return err
+	}
+	m.mu.Lock()
+	if job.state == JobActive {
+		job.state = JobPaused
+		m.release(job)
+		m.save(job)
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// Resume 恢复暂停的任务：排队中暂停的重新排队，下载中暂停的继续提交分块
+// 下载中的任务恢复失败时任务失败，释放名额
+func (m *Manager) Resume(id uint64) error {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return ErrJobNotFound
+	}
+	if job.state != JobPaused {
+		m.mu.Unlock()
+		return nil
+	}
+	t := job.task
+	m.hold(job)
+	if t == nil {
+		job.state = JobQueued
+		heap.Push(&m.queue, job)
+		m.save(job)
+		m.mu.Unlock()
+		m.emit(job, Event{Type: EventResumed, Url: job.Url, Time: time.Now()})
+		m.schedule()
+		return nil
+	}
+	job.state = JobActive
+	m.save(job)
+	m.mu.Unlock()
+
+	err := t.Resume()
+	if err == nil {
+		return nil
+	}
+	m.mu.Lock()
+	failed := job.task == t && job.state == JobActive && !job.removed
+	if failed {
+		job.state = JobFailed
+		job.err = err
+		job.endTime = time.Now()
+		job.dropped = t
+		m.save(job)
+		m.active--
+		m.release(job)
+	}
+	m.mu.Unlock()
+	t.Cancel()
+	if failed {
+		logger.Error("task failed", "job", job.ID, "url", httpclient.Redact(job.Url), "err", err)
+		m.emit(job, Event{Type: EventFailed, Url: job.Url, Time: time.Now(), Err: err})
+		m.schedule()
+	}
+	return err
+}
+
+// Retry 重新下载失败的任务：清除错误后重新排队，按原来的选项从.DOWNLOADING续传
+func (m *Manager) Retry(id uint64) error {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok || job.removed {
+		m.mu.Unlock()
+		return ErrJobNotFound
+	}
+	if job.state != JobFailed {
+		m.mu.Unlock()
+		return ErrJobNotFailed
+	}
+	job.state = JobQueued
+	job.task = nil
+	job.err = nil
+	job.endTime = time.Time{}
+	heap.Push(&m.queue, job)
+	m.hold(job)
+	m.save(job)
+	m.mu.Unlock()
+
+	m.emit(job, Event{Type: EventQueued, Url: job.Url, Time: time.Now()})
+	m.schedule()
+	return nil
+}
+
+// Remove 删除任务
+// 排队中的和已结束的任务立即删除；下载中的任务被取消（见Task.Cancel），结束后删除
+// 删除时发出EventRemoved，下载了一部分的.DOWNLOADING不会被删除
+func (m *Manager) Remove(id uint64) error {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok || job.removed {
+		m.mu.Unlock()
+		return ErrJobNotFound
+	}
+
+	switch {
+	case job.state == JobQueued || job.state == JobPaused && job.task == nil:
+		if job.state == JobQueued {
+			heap.Remove(&m.queue, job.index)
+		}
+		m.forget(job)
+		m.release(job)
+		m.mu.Unlock()
+	case job.state == JobActive || job.state == JobPaused:
+		job.removed = true
+		t := job.task
+		m.mu.Unlock()
+		if t != nil { // 还在创建Task时由start取消
+			t.Cancel()
+		}
+		return nil // run结束时发出EventRemoved
+	default:
+		m.forget(job)
+		m.mu.Unlock()
+	}
+	m.emit(job, Event{Type: EventRemoved, Url: job.Url, Time: time.Now()})
+	return nil
+}
+
+// SetOptions 修改任务选项，opts.Auth为nil时保留原来的
+// 排队中和排队中暂停的任务所有选项都会生效；下载中的任务只有Priority、Weight、InOrder生效
+func (m *Manager) SetOptions(id uint64, opts Options) error {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return ErrJobNotFound
+	}
+	if opts.Auth == nil {
+		opts.Auth = job.Options.Auth
+	}
+	t := job.task
+	switch {
+	case job.state == JobCompleted || job.state == JobFailed:
+		m.mu.Unlock()
+		return ErrJobFinished
+	case job.state == JobActive && t == nil:
+		m.mu.Unlock()
+		return ErrJobStarting
+	case t == nil:
+		job.Options = opts
+		if job.state == JobQueued {
+			heap.Fix(&m.queue, job.index)
+		}
+	default:
+		job.Options.Priority, job.Options.Weight, job.Options.InOrder = opts.Priority, opts.Weight, opts.InOrder
+	}
+	m.save(job)
+	m.mu.Unlock()
+
+	if t != nil {
+		t.SetSchedule(opts.Priority, opts.Weight, opts.InOrder)
+	}
+	return nil
+}
+
+// remember 记录新任务，调用者需持有m.mu
+func (m *Manager) remember(job *Job) {
+	m.jobs[job.ID] = job
+	m.order = append(m.order, job)
+	m.byUrl[job.Url] = append(m.byUrl[job.Url], job)
+}
+
+// Purge 删除所有已结束（完成或失败）的任务及保存的状态，返回删除的任务数
+// 与Remove相同，每个删除的任务发出EventRemoved
+func (m *Manager) Purge() int {
+	m.mu.Lock()
+	var purged []*Job
+	kept := m.order[:0]
+	for _, job := range m.order {
+		if (job.state == JobCompleted || job.state == JobFailed) && !job.removed {
+			m.drop(job)
+			purged = append(purged, job)
+		} else {
+			kept = append(kept, job)
+		}
+	}
+	for i := len(kept); i < len(m.order); i++ {
+		m.order[i] = nil
+	}
+	m.order = kept
+	m.mu.Unlock()
+
+	for _, job := range purged {
+		m.emit(job, Event{Type: EventRemoved, Url: job.Url, Time: time.Now()})
+	}
+	return len(purged)
+}
+
+// forget 从Manager和状态数据库中删除任务，调用者需持有m.mu
+func (m *Manager) forget(job *Job) {
+	m.order = removeJob(m.order, job)
+	m.drop(job)
+}
+
+// drop 删除order以外的记录，调用者需持有m.mu
+func (m *Manager) drop(job *Job) {
+	delete(m.jobs, job.ID)
+	if jobs := removeJob(m.byUrl[job.Url], job); len(jobs) > 0 {
+		m.byUrl[job.Url] = jobs
+	} else {
+		delete(m.byUrl, job.Url)
+	}
+	m.unsave(job)
+}
+
+func removeJob(jobs []*Job, job *Job) []*Job {
+	for i, j := range jobs {
+		if j == job {
+			return append(jobs[:i], jobs[i+1:]...)
+		}
+	}
+	return jobs
+}
@@ -0,0 +1,160 @@
+package task
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/edb"
+)
+
+const (
+	JobKeyPrefix = 'J'
+	jobKeyLength = 9 // 1+8
+)
+
+// jobRecord Job在数据库中的存储格式
+type jobRecord struct {
+	ID      uint64    `json:"id"`
+	Url     string    `json:"url"`
+	Options Options   `json:"options"`
+	State   JobState  `json:"state"`
+	Err     string    `json:"err,omitempty"`
+	AddTime time.Time `json:"add_time"`
+	EndTime time.Time `json:"end_time"`
+}
+
+// NewPersistentManager 创建状态保存在edb数据库中的Manager
+// 数据库中未完成的任务（排队中或下载中）会重新排队，下载中的任务依靠分块数据库续传；
+// 暂停的任务保持暂停；已完成和失败的任务作为历史记录保留，可以用Purge删除
+func NewPersistentManager(maxActive int, dbPath string) (*Manager, error) {
+	db, err := edb.OpenEDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	m := NewManager(maxActive)
+	m.db = db
+
+	records, err := loadJobs(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	m.mu.Lock()
+	for _, r := range records {
+		job := &Job{
+			ID:      r.ID,
+			Url:     r.Url,
+			Options: r.Options,
+			state:   r.State,
+			addTime: r.AddTime,
+			endTime: r.EndTime,
+			seq:     r.ID,
+		}
+		if r.Err != "" {
+			job.err = errors.New(r.Err)
+		}
+		if r.ID > m.nextID {
+			m.nextID = r.ID
+		}
+		m.remember(job)
+		switch job.state {
+		case JobQueued, JobActive:
+			job.state = JobQueued
+			heap.Push(&m.queue, job)
+			m.hold(job)
+			m.save(job)
+		}
+		// 暂停的任务保持暂停，Resume后再排队
+	}
+	pending := m.queue.Len()
+	m.mu.Unlock()
+
+	logger.Info("jobs loaded", "path", dbPath, "jobs", len(records), "resume", pending)
+	m.schedule()
+	return m, nil
+}
+
+// Close 关闭状态数据库
+// 之后不应再提交任务；仍在下载的任务下次NewPersistentManager时会续传
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.db == nil {
+		return nil
+	}
+	err := m.db.Close()
+	m.db = nil
+	return err
+}
+
+// save 保存任务状态，调用者需持有m.mu
+func (m *Manager) save(job *Job) {
+	if m.db == nil {
+		return
+	}
+	r := jobRecord{
+		ID:      job.ID,
+		Url:     job.Url,
+		Options: job.Options,
+		State:   job.state,
+		AddTime: job.addTime,
+		EndTime: job.endTime,
+	}
+	if job.err != nil {
+		r.Err = job.err.Error()
+	}
+	v, err := json.Marshal(r)
+	if err == nil {
+		err = m.db.Set(jobKey(job.ID), v)
+	}
+	if err != nil {
+		logger.Error("save job failed", "job", job.ID, "err", err)
+	}
+}
+
+// unsave 删除保存的任务状态，调用者需持有m.mu
+func (m *Manager) unsave(job *Job) {
+	if m.db == nil {
+		return
+	}
+	if err := m.db.Delete(jobKey(job.ID)); err != nil {
+		logger.Error("delete job failed", "job", job.ID, "err", err)
+	}
+}
+
+// jobKey 任务键格式：J|[id]，id大端序以便按提交顺序排列
+func jobKey(id uint64) []byte {
+	key := make([]byte, jobKeyLength)
+	key[0] = JobKeyPrefix
+	binary.BigEndian.PutUint64(key[1:], id)
+	return key
+}
+
+// loadJobs 读取数据库中的所有任务，按ID排序
+func loadJobs(db edb.DB) ([]jobRecord, error) {
+	var (
+		records []jobRecord
+		err     error
+	)
+	db.IterDB(func(k, v []byte) error {
+		if len(k) != jobKeyLength || k[0] != JobKeyPrefix {
+			return nil
+		}
+		var r jobRecord
+		if e := json.Unmarshal(v, &r); e != nil {
+			if err == nil {
+				err = e
+			}
+			return e
+		}
+		records = append(records, r)
+		return nil
+	})
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, err
+}
@@ -0,0 +1,109 @@
+package task
+
+import (
+	"encoding/json"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/edb"
+	"github.com/azd1997/blockchair_downloader/pool"
+)
+
+func TestPersistentManager(t *testing.T) {
+	if err := pool.Init(4); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, DefaultChunkSize+1)
+	rand.Read(data)
+	srv := newTestServer(data)
+	defer srv.Close()
+
+	// 模拟上次进程退出时留下的状态：一个已完成、一个下载中、一个排队中、一个暂停
+	dbPath := filepath.Join(t.TempDir(), "jobs.db")
+	db, err := edb.OpenEDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := testFileName()
+	records := []jobRecord{
+		{ID: 1, Url: srv.URL + "/" + prefix + "-done", State: JobCompleted},
+		{ID: 2, Url: srv.URL + "/" + prefix + "-active", State: JobActive},
+		{ID: 3, Url: srv.URL + "/" + prefix + "-queued", State: JobQueued, Options: Options{Priority: 1}},
+		{ID: 4, Url: srv.URL + "/" + prefix + "-paused", State: JobPaused},
+	}
+	for _, r := range records {
+		v, _ := json.Marshal(r)
+		if err = db.Set(jobKey(r.ID), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	m, err := NewPersistentManager(2, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := m.Add(srv.URL+"/"+prefix+"-new", Options{})
+	if job.ID != 5 {
+		t.Errorf("new job id = %d, want 5", job.ID)
+	}
+	result := m.Wait()
+	for _, task := range m.order {
+		if task.task != nil {
+			defer removeTaskFiles(task.task)
+		}
+	}
+	// 已完成的任务不会重新下载，暂停的任务不计入Wait
+	if result.Completed != 4 || result.Failed != 0 {
+		t.Fatalf("completed=%d failed=%d", result.Completed, result.Failed)
+	}
+	if m.Task(1) != nil {
+		t.Error("completed job should not be restarted")
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开后除了暂停的都是完成状态
+	m, err = NewPersistentManager(2, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := m.Jobs()
+	if len(jobs) != 5 {
+		t.Fatalf("reloaded %d jobs, want 5", len(jobs))
+	}
+	for _, j := range jobs {
+		if j.ID == 4 {
+			if j.State != JobPaused {
+				t.Errorf("job 4: state=%s", j.State)
+			}
+		} else if j.State != JobCompleted || j.EndTime.IsZero() && j.ID != 1 {
+			t.Errorf("job %d: state=%s end=%s", j.ID, j.State, j.EndTime.Format(time.RFC3339))
+		}
+	}
+	if jobs[2].Options.Priority != 1 {
+		t.Errorf("options not persisted: %+v", jobs[2].Options)
+	}
+
+	// Purge删除的记录不会再被读取
+	if n := m.Purge(); n != 4 {
+		t.Fatalf("purged %d", n)
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m, err = NewPersistentManager(2, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if jobs = m.Jobs(); len(jobs) != 1 || jobs[0].ID != 4 {
+		t.Fatalf("jobs after purge = %+v", jobs)
+	}
+}
@@ -0,0 +1,543 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/logging"
+	"github.com/azd1997/blockchair_downloader/pool"
+)
+
+func TestManager(t *testing.T) {
+	if err := pool.Init(4); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 2*DefaultChunkSize)
+	rand.Read(data)
+	// 以slow开头的文件在gate关闭前不返回数据
+	gate := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "missing") {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.Contains(r.URL.Path, "slow") && r.Method == http.MethodGet {
+			<-gate
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	m := NewManager(1)
+	var (
+		lock    sync.Mutex
+		started []string
+		tasks   []*Task
+	)
+	m.OnEvent(func(e Event) {
+		if e.Type == EventStarted {
+			lock.Lock()
+			started = append(started, e.Url[strings.LastIndex(e.Url, "/")+1:])
+			tasks = append(tasks, e.Task)
+			lock.Unlock()
+		}
+	})
+	defer func() {
+		for _, task := range tasks {
+			removeTaskFiles(task)
+		}
+	}()
+
+	prefix := testFileName()
+	m.Add(srv.URL+"/"+prefix+"-slow", Options{})
+	// 等第一个任务开始后再提交，此时只能排队
+	for {
+		lock.Lock()
+		n := len(started)
+		lock.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.Add(srv.URL+"/"+prefix+"-low", Options{Priority: 0})
+	m.Add(srv.URL+"/"+prefix+"-missing", Options{Priority: 1})
+	m.Add(srv.URL+"/"+prefix+"-high", Options{Priority: 5})
+	close(gate)
+
+	result := m.Wait()
+	if result.Completed != 3 || result.Failed != 1 {
+		t.Fatalf("completed=%d failed=%d", result.Completed, result.Failed)
+	}
+	want := []string{prefix + "-slow", prefix + "-high", prefix + "-low"}
+	if strings.Join(started, ",") != strings.Join(want, ",") {
+		t.Fatalf("start order = %v, want %v", started, want)
+	}
+	for _, j := range result.Jobs {
+		if strings.HasSuffix(j.Url, "missing") != (j.State == JobFailed) {
+			t.Errorf("job %s state = %s", j.Url, j.State)
+		}
+	}
+}
+
+func TestManager_Control(t *testing.T) {
+	if err := pool.Init(4); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 2*DefaultChunkSize)
+	rand.Read(data)
+	gate := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "slow") && r.Method == http.MethodGet {
+			select {
+			case <-gate:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+	}))
+	defer srv.Close()
+	defer srv.CloseClientConnections()
+
+	m := NewManager(1)
+	var (
+		lock    sync.Mutex
+		events  = map[EventType][]uint64{}
+		tasks   []*Task
+		started = make(chan uint64, 10)
+	)
+	m.OnEvent(func(e Event) {
+		lock.Lock()
+		events[e.Type] = append(events[e.Type], e.JobID)
+		if e.Type == EventStarted {
+			tasks = append(tasks, e.Task)
+		}
+		lock.Unlock()
+		if e.Type == EventStarted {
+			started <- e.JobID
+		}
+	})
+	defer func() {
+		for _, task := range tasks {
+			removeTaskFiles(task)
+		}
+	}()
+
+	prefix := testFileName()
+	a := m.Add(srv.URL+"/"+prefix+"-a-slow", Options{})
+	<-started
+	b := m.Add(srv.URL+"/"+prefix+"-b", Options{})
+	c := m.Add(srv.URL+"/"+prefix+"-c", Options{})
+
+	// 排队中的任务
+	if err := m.Pause(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetOptions(b.ID, Options{Priority: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove(c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Job(c.ID); err != ErrJobNotFound {
+		t.Fatalf("removed job: %v", err)
+	}
+	if s, _ := m.Job(b.ID); s.State != JobPaused || s.Options.Priority != 3 {
+		t.Fatalf("b = %s %+v", s.State, s.Options)
+	}
+
+	// 下载中的任务
+	if err := m.Pause(a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := m.Job(a.ID); s.State != JobPaused || !s.Stats.Paused || s.File == "" {
+		t.Fatalf("a = %s %+v", s.State, s.Stats)
+	}
+	if err := m.Resume(a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Resume(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	close(gate)
+	result := m.Wait()
+	if result.Completed != 2 || len(result.Jobs) != 2 {
+		t.Fatalf("completed=%d jobs=%d", result.Completed, len(result.Jobs))
+	}
+	if err := m.Pause(a.ID); err != ErrJobFinished {
+		t.Fatalf("pause finished job: %v", err)
+	}
+
+	// 删除已结束的任务
+	if err := m.Remove(a.ID); err != nil || len(m.Jobs()) != 1 {
+		t.Fatalf("remove finished: %v, jobs=%d", err, len(m.Jobs()))
+	}
+
+	// 删除下载中的任务：取消后从Manager中删除
+	gate = make(chan struct{})
+	d := m.Add(srv.URL+"/"+prefix+"-d-slow", Options{})
+	<-started
+	if err := m.Remove(d.ID); err != nil {
+		t.Fatal(err)
+	}
+	m.Wait()
+	if len(m.Jobs()) != 1 {
+		t.Fatalf("jobs = %d", len(m.Jobs()))
+	}
+	close(gate)
+
+	lock.Lock()
+	defer lock.Unlock()
+	want := []uint64{c.ID, a.ID, d.ID}
+	if fmt.Sprint(events[EventRemoved]) != fmt.Sprint(want) {
+		t.Fatalf("removed events = %v, want %v", events[EventRemoved], want)
+	}
+
+	if err := m.SetMaxActive(0); err == nil {
+		t.Fatal("SetMaxActive(0) should fail")
+	}
+	if m.SetMaxActive(3); m.MaxActive() != 3 {
+		t.Fatalf("MaxActive = %d", m.MaxActive())
+	}
+}
+
+func TestManager_PausedAndPurge(t *testing.T) {
+	if err := pool.Init(4); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 2*DefaultChunkSize)
+	rand.Read(data)
+	gate := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "slow") && r.Method == http.MethodGet {
+			select {
+			case <-gate:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+	}))
+	defer srv.Close()
+	defer srv.CloseClientConnections()
+	defer close(gate)
+
+	m := NewManager(1)
+	var (
+		lock    sync.Mutex
+		failed  []uint64
+		tasks   []*Task
+		started = make(chan uint64, 10)
+	)
+	m.OnEvent(func(e Event) {
+		lock.Lock()
+		if e.Type == EventFailed {
+			failed = append(failed, e.JobID)
+		}
+		if e.Type == EventStarted {
+			tasks = append(tasks, e.Task)
+		}
+		lock.Unlock()
+		if e.Type == EventStarted {
+			started <- e.JobID
+		}
+	})
+	defer func() {
+		for _, task := range tasks {
+			removeTaskFiles(task)
+		}
+	}()
+	wait := func() Result {
+		done := make(chan Result, 1)
+		go func() { done <- m.Wait() }()
+		select {
+		case r := <-done:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("Wait blocked by paused jobs")
+		}
+		return Result{}
+	}
+
+	prefix := testFileName()
+	a := m.Add(srv.URL+"/"+prefix+"-a-slow", Options{})
+	<-started
+	b := m.Add(srv.URL+"/"+prefix+"-b", Options{})
+	if err := m.Pause(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Pause(a.ID); err != nil {
+		t.Fatal(err)
+	}
+	// 暂停的任务不计入Wait
+	if r := wait(); r.Completed != 0 || r.Failed != 0 {
+		t.Fatalf("completed=%d failed=%d", r.Completed, r.Failed)
+	}
+
+	// 恢复失败时任务失败并释放名额：数据库中有格式错误的分块任务
+	if err := m.Task(a.ID).db.Set([]byte{TaskKeyPrefix, 1}, []byte("-")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Resume(a.ID); err == nil {
+		t.Fatal("resume should fail")
+	}
+	if s, _ := m.Job(a.ID); s.State != JobFailed || s.Err == nil {
+		t.Fatalf("a = %s %v", s.State, s.Err)
+	}
+	if err := m.Resume(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if r := wait(); r.Completed != 1 || r.Failed != 1 {
+		t.Fatalf("completed=%d failed=%d", r.Completed, r.Failed)
+	}
+	lock.Lock()
+	if fmt.Sprint(failed) != fmt.Sprint([]uint64{a.ID}) {
+		t.Errorf("failed events = %v", failed)
+	}
+	lock.Unlock()
+
+	// 删除已结束的任务
+	c := m.Add(srv.URL+"/"+prefix+"-c-slow", Options{})
+	<-started
+	if n := m.Purge(); n != 2 {
+		t.Fatalf("purged %d", n)
+	}
+	if jobs := m.Jobs(); len(jobs) != 1 || jobs[0].ID != c.ID {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+	if _, ok := m.Lookup(b.Url); ok {
+		t.Fatal("purged job still found")
+	}
+	if err := m.Remove(c.ID); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+}
+
+func TestManager_Retry(t *testing.T) {
+	if err := pool.Init(2); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 100<<10)
+	rand.Read(data)
+	var (
+		lock sync.Mutex
+		up   bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		ok := up
+		lock.Unlock()
+		if !ok {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	m := NewManager(1)
+	var tasks []*Task
+	m.OnEvent(func(e Event) {
+		if e.Type == EventStarted {
+			tasks = append(tasks, e.Task)
+		}
+	})
+	defer func() {
+		for _, task := range tasks {
+			removeTaskFiles(task)
+		}
+	}()
+
+	job := m.Add(srv.URL+"/"+testFileName(), Options{})
+	if result := m.Wait(); result.Failed != 1 {
+		t.Fatalf("failed = %d", result.Failed)
+	}
+	lock.Lock()
+	up = true
+	lock.Unlock()
+
+	if err := m.Retry(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if result := m.Wait(); result.Completed != 1 || result.Jobs[0].Err != nil {
+		t.Fatalf("completed = %d, err = %v", result.Completed, result.Jobs[0].Err)
+	}
+	if err := m.Retry(job.ID); err != ErrJobNotFailed {
+		t.Fatalf("retry completed job: %v", err)
+	}
+	if err := m.Retry(job.ID + 1); err != ErrJobNotFound {
+		t.Fatalf("retry missing job: %v", err)
+	}
+}
+
+func TestManager_Lookup(t *testing.T) {
+	if err := pool.Init(2); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 10<<10)
+	rand.Read(data)
+	var (
+		lock sync.Mutex
+		up   bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		ok := up
+		lock.Unlock()
+		if !ok {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	m := NewManager(1)
+	var tasks []*Task
+	m.OnEvent(func(e Event) {
+		if e.Type == EventStarted {
+			tasks = append(tasks, e.Task)
+		}
+	})
+	defer func() {
+		for _, task := range tasks {
+			removeTaskFiles(task)
+		}
+	}()
+
+	url := srv.URL + "/" + testFileName()
+	if _, ok := m.Lookup(url); ok {
+		t.Fatal("lookup before add")
+	}
+	failed := m.Add(url, Options{})
+	m.Wait()
+	if job, ok := m.Lookup(url); !ok || job.ID != failed.ID || job.State != JobFailed {
+		t.Fatalf("lookup failed job: %+v %v", job, ok)
+	}
+
+	// 同一个url有未失败的任务时优先返回它
+	lock.Lock()
+	up = true
+	lock.Unlock()
+	done := m.Add(url, Options{})
+	m.Wait()
+	if job, ok := m.Lookup(url); !ok || job.ID != done.ID || job.State != JobCompleted {
+		t.Fatalf("lookup completed job: %+v %v", job, ok)
+	}
+
+	if err := m.Remove(done.ID); err != nil {
+		t.Fatal(err)
+	}
+	if job, ok := m.Lookup(url); !ok || job.ID != failed.ID {
+		t.Fatalf("lookup after remove: %+v %v", job, ok)
+	}
+	if err := m.Remove(failed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Lookup(url); ok {
+		t.Fatal("lookup after removing all jobs")
+	}
+}
+
+func TestManager_Logger(t *testing.T) {
+	if err := pool.Init(2); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	var buf bytes.Buffer
+	logger := logging.NewJSON(&buf, logging.LevelDebug)
+	pool.SetLogger(logger)
+	SetLogger(logger)
+	defer pool.SetLogger(nil)
+	defer SetLogger(nil)
+
+	data := make([]byte, 2*DefaultChunkSize)
+	rand.Read(data)
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fail := false
+		if strings.HasPrefix(r.Header.Get("Range"), fmt.Sprintf("bytes=%d-", DefaultChunkSize)) {
+			once.Do(func() { fail = true })
+		}
+		if fail {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	m := NewManager(1)
+	var tasks []*Task
+	m.OnEvent(func(e Event) {
+		if e.Type == EventStarted {
+			tasks = append(tasks, e.Task)
+		}
+	})
+	job := m.Add(srv.URL+"/"+testFileName(), Options{})
+	if result := m.Wait(); result.Completed != 1 {
+		t.Fatalf("completed = %d", result.Completed)
+	}
+	removeTaskFiles(tasks[0])
+
+	found := map[string]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		if rec["job"] != nil && rec["job"] != float64(job.ID) || rec["url_hash"] != nil && rec["url_hash"] != tasks[0].UrlHash {
+			t.Fatalf("wrong task fields: %s", line)
+		}
+		if _, ok := found[rec["msg"].(string)]; !ok {
+			found[rec["msg"].(string)] = rec
+		}
+	}
+	for msg, keys := range map[string][]string{
+		"task created":   {"job", "url_hash", "url", "file", "size", "chunks"},
+		"chunk failed":   {"job", "url_hash", "downloader", "range", "attempt", "reason", "err"},
+		"chunk done":     {"job", "url_hash", "downloader", "range", "attempt", "bytes", "elapsed"},
+		"task completed": {"job", "url_hash", "url", "elapsed"},
+	} {
+		rec, ok := found[msg]
+		if !ok {
+			t.Fatalf("no %q in:\n%s", msg, buf.String())
+		}
+		for _, k := range keys {
+			if _, ok := rec[k]; !ok {
+				t.Fatalf("%q has no %s: %v", msg, k, rec)
+			}
+		}
+	}
+	if f := found["chunk failed"]; f["level"] != "WARN" || f["reason"] != "http_503" || f["attempt"] != 1.0 ||
+		f["range"] != fmt.Sprintf("%d-%d", DefaultChunkSize, 2*DefaultChunkSize-1) {
+		t.Fatalf("chunk failed: %v", f)
+	}
+}
@@ -0,0 +1,66 @@
+package task
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/metrics"
+)
+
+// running 正在下载的Task，用于输出按任务的指标；任务结束后不再输出
+var running = struct {
+	sync.Mutex
+	tasks map[*Task]struct{}
+}{tasks: map[*Task]struct{}{}}
+
+func init() {
+	metrics.Default.GaugeFunc("godl_tasks_running", "正在下载的任务数",
+		nil, func(emit func(float64, ...string)) {
+			running.Lock()
+			n := len(running.tasks)
+			running.Unlock()
+			emit(float64(n))
+		})
+	metrics.Default.CounterFunc("godl_task_bytes_total", "正在下载的任务已下载的字节数，续传时包括之前下载的部分",
+		[]string{"task"}, func(emit func(float64, ...string)) {
+			for _, t := range runningTasks() {
+				t.mu.Lock()
+				n := t.bytesDone
+				t.mu.Unlock()
+				emit(float64(n), httpclient.Redact(t.Url))
+			}
+		})
+	metrics.Default.GaugeFunc("godl_task_size_bytes", "正在下载的任务的文件大小，服务端未返回大小的任务不输出",
+		[]string{"task"}, func(emit func(float64, ...string)) {
+			for _, t := range runningTasks() {
+				if t.FileSize >= 0 {
+					emit(float64(t.FileSize), httpclient.Redact(t.Url))
+				}
+			}
+		})
+}
+
+// runningTasks 按url排序的正在下载的Task
+func runningTasks() []*Task {
+	running.Lock()
+	list := make([]*Task, 0, len(running.tasks))
+	for t := range running.tasks {
+		list = append(list, t)
+	}
+	running.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Url < list[j].Url })
+	return list
+}
+
+// track 登记正在下载的Task，返回的函数在任务结束时调用
+func (t *Task) track() func() {
+	running.Lock()
+	running.tasks[t] = struct{}{}
+	running.Unlock()
+	return func() {
+		running.Lock()
+		delete(running.tasks, t)
+		running.Unlock()
+	}
+}
@@ -0,0 +1,25 @@
+package task
+
+import "github.com/azd1997/blockchair_downloader/httpclient"
+
+// Options 任务选项，零值即默认行为
+type Options struct {
+	Priority int  `json:"priority"` // 优先级，越大越先下载（Manager中先开始，cdp中分块先调度）
+	Weight   int  `json:"weight"`   // 与同优先级的任务共享下载器时的权重，默认1
+	InOrder  bool `json:"in_order"` // 按文件顺序下载分块，适合边下边播
+
+	Dir       string `json:"dir"`       // 保存目录，为空时使用DownloadDir
+	FileName  string `json:"file_name"` // 保存的文件名，为空时使用url路径的最后一段
+	Overwrite bool   `json:"overwrite"` // 覆盖已存在的同名文件，否则在文件名后追加时间戳
+	Restart   bool   `json:"restart"`   // 丢弃已有的续传数据(.DOWNLOADING)，从头下载
+
+	ChunkSize int64  `json:"chunk_size"` // 分块大小，<=0时为DefaultChunkSize；续传时沿用原来的分块
+	MaxTries  int    `json:"max_tries"`  // 单个分块最多尝试次数，<=0时为pool.MaxTries
+	Checksum  string `json:"checksum"`   // 下载完成后校验，格式为 算法=十六进制摘要，如 sha-256=...；算法见hashes
+
+	Tags []string `json:"tags,omitempty"` // 标签，用于任务分组，Manager的事件带有任务的标签
+
+	// Auth 探测请求和分块请求的请求头、Cookie和认证
+	// 含有密码等敏感信息，不会随Manager的任务状态保存，见Manager.SetAuth
+	Auth *httpclient.Auth `json:"-"`
+}
@@ -0,0 +1,273 @@
+package task
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/pool"
+)
+
+func TestTask_AuthRefresh(t *testing.T) {
+	if err := pool.Init(3); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 4*DefaultChunkSize)
+	rand.Read(data)
+
+	// 服务端在处理了3个请求后更换token，旧token返回401
+	var (
+		mu       sync.Mutex
+		token    = "t1"
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ok := r.Header.Get("Authorization") == "Bearer "+token
+		if ok {
+			requests++
+			if requests == 3 {
+				token = "t2"
+			}
+		}
+		mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	// 没有认证时探测请求失败
+	if _, err := NewTask(srv.URL + "/" + testFileName()); err == nil {
+		t.Fatal("want 401 error")
+	}
+
+	auth := &httpclient.Auth{
+		Credential: httpclient.Credential{Token: "t1"},
+		Provider: func(req *http.Request, rsp *http.Response) (httpclient.Credential, error) {
+			return httpclient.Credential{Token: "t2"}, nil
+		},
+	}
+	task, err := NewTaskWithOptions(srv.URL+"/"+testFileName(), Options{Auth: auth})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeTaskFiles(task)
+	if err = task.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(task.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("file mismatch: len(got)=%d, len(data)=%d", len(got), len(data))
+	}
+	if s := task.Stats(); s.Retries != 0 {
+		t.Fatalf("retries = %d, token refresh should not cost a retry", s.Retries)
+	}
+}
+
+func TestFileNameFromUrl(t *testing.T) {
+	cases := []struct{ url, want string }{
+		{"https://gz.blockchair.com/bitcoin/inputs/a_20210315.tsv.gz", "a_20210315.tsv.gz"},
+		{"https://gz.blockchair.com/bitcoin/inputs/a_20210315.tsv.gz?key=secret", "a_20210315.tsv.gz"},
+		{"https://h/dir/file%20name.bin#frag", "file name.bin"},
+		{"https://h/", ""},
+		{"https://h", ""},
+	}
+	for _, c := range cases {
+		if got := fileNameFromUrl(c.url); got != c.want {
+			t.Errorf("fileNameFromUrl(%q) = %q, want %q", c.url, got, c.want)
+		}
+	}
+}
+
+func TestTask_DirAndFileName(t *testing.T) {
+	srv := newTestServer([]byte("hello"))
+	defer srv.Close()
+
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	task, err := NewTaskWithOptions(srv.URL+"/x.bin?key=secret", Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.db.Close()
+	if want := filepath.Join(dir, "x.bin"); task.FileName != want {
+		t.Fatalf("FileName = %s, want %s", task.FileName, want)
+	}
+
+	// 已存在同名文件时追加时间戳
+	if err := ioutil.WriteFile(filepath.Join(dir, "y.bin"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	task, err = NewTaskWithOptions(srv.URL+"/x.bin", Options{Dir: dir, FileName: "y.bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.db.Close()
+	if !strings.HasPrefix(task.FileName, filepath.Join(dir, "y.bin-")) {
+		t.Fatalf("FileName = %s", task.FileName)
+	}
+
+	// Overwrite时直接使用原文件名
+	task, err = NewTaskWithOptions(srv.URL+"/x.bin", Options{Dir: dir, FileName: "y.bin", Overwrite: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.db.Close()
+	if want := filepath.Join(dir, "y.bin"); task.FileName != want {
+		t.Fatalf("FileName = %s, want %s", task.FileName, want)
+	}
+}
+
+func TestTask_ChecksumAndChunkSize(t *testing.T) {
+	if err := pool.Init(3); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 3*DefaultChunkSize+1)
+	rand.Read(data)
+	srv := newTestServer(data)
+	defer srv.Close()
+	sum := sha256.Sum256(data)
+	dir := t.TempDir()
+
+	if _, err := NewTaskWithOptions(srv.URL+"/a.bin", Options{Dir: dir, Checksum: "crc=00"}); err == nil {
+		t.Fatal("unsupported algorithm should fail before downloading")
+	}
+
+	task, err := NewTaskWithOptions(srv.URL+"/a.bin", Options{
+		Dir:       dir,
+		ChunkSize: 2 * DefaultChunkSize,
+		Checksum:  "SHA-256=" + hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ChunkNum != 2 {
+		t.Fatalf("ChunkNum = %d", task.ChunkNum)
+	}
+	if err = task.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	sum[0]++
+	task, err = NewTaskWithOptions(srv.URL+"/b.bin", Options{Dir: dir, Checksum: "sha-256=" + hex.EncodeToString(sum[:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ce *ChecksumError
+	if err = task.Start(); !errors.As(err, &ce) {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := os.Stat(task.FileName); !os.IsNotExist(err) {
+		t.Fatal("mismatched file should be removed")
+	}
+}
+
+func TestTask_ResumeChunkSize(t *testing.T) {
+	if err := pool.Init(3); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 3*DefaultChunkSize+1)
+	rand.Read(data)
+	srv := newTestServer(data)
+	defer srv.Close()
+	dir := t.TempDir()
+
+	// 以1024分块，写入分块任务后中断
+	first, err := NewTaskWithOptions(srv.URL+"/a.bin", Options{Dir: dir, ChunkSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = first.splitChunks(); err != nil {
+		t.Fatal(err)
+	}
+	first.db.Close()
+
+	// 续传时指定了不同的分块大小，仍按1024下载和合并
+	task, err := NewTaskWithOptions(srv.URL+"/a.bin", Options{Dir: dir, ChunkSize: 2 * DefaultChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !task.Resuming || task.ChunkSize != 1024 || task.ChunkNum != 13 {
+		t.Fatalf("resume=%v ChunkSize=%d ChunkNum=%d", task.Resuming, task.ChunkSize, task.ChunkNum)
+	}
+	if err = task.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(task.FileName); !bytes.Equal(got, data) {
+		t.Fatal("merged file mismatch")
+	}
+	if s := task.Stats(); s.ChunksDone != 13 || s.ChunksTotal != 13 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestTask_MergeFailClosesDB(t *testing.T) {
+	if err := pool.Init(3); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 2*DefaultChunkSize+1)
+	rand.Read(data)
+	srv := newTestServer(data)
+	defer srv.Close()
+	dir := t.TempDir()
+
+	task, err := NewTaskWithOptions(srv.URL+"/a.bin", Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 目标文件位置被目录占用，合并时创建文件失败
+	if err = os.Mkdir(task.FileName, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = task.Start(); err == nil {
+		t.Fatal("merge into a directory should fail")
+	}
+
+	// 数据库已经关闭，同一进程内可以再次打开续传
+	if err = os.Remove(task.FileName); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := NewTaskWithOptions(srv.URL+"/a.bin", Options{Dir: dir, Overwrite: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed.Resuming {
+		t.Fatal("chunk db should be kept for resuming")
+	}
+	if err = resumed.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(resumed.FileName); !bytes.Equal(got, data) {
+		t.Fatal("merged file mismatch")
+	}
+}
@@ -0,0 +1,63 @@
+package task
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/pool"
+)
+
+// Info 用HEAD请求探测到的远程文件信息
+type Info struct {
+	Size         int64     // 文件大小，未知时为-1
+	ModTime      time.Time // Last-Modified，未知时为零值
+	ETag         string
+	AcceptRanges bool // 服务端是否支持按字节分块下载
+}
+
+// StatusError 服务端返回了4xx/5xx：Probe的HEAD请求，或者直接下载、分块最后一次下载的GET请求
+type StatusError struct {
+	Method string // 为空时是HEAD
+	Url    string // 已隐藏敏感信息
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	method := e.Method
+	if method == "" {
+		method = http.MethodHead
+	}
+	return fmt.Sprintf("%s %s: %s", method, e.Url, e.Status)
+}
+
+// Probe 获取远程文件的大小、修改时间以及是否支持分块下载
+// 使用cdp的HTTP客户端，auth可以为nil；服务端返回4xx/5xx时返回*StatusError
+func Probe(url string, auth *httpclient.Auth) (Info, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	rsp, err := auth.Do(pool.HTTPClient(), req)
+	if err != nil {
+		return Info{}, err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode >= http.StatusBadRequest {
+		return Info{}, &StatusError{Url: httpclient.Redact(url), Code: rsp.StatusCode, Status: rsp.Status}
+	}
+
+	info := Info{
+		Size:         rsp.ContentLength,
+		ETag:         rsp.Header.Get("ETag"),
+		AcceptRanges: rsp.Header.Get("Accept-Ranges") == "bytes", // 这表示服务端支持按字节下载
+	}
+	if lm := rsp.Header.Get("Last-Modified"); lm != "" {
+		if t, err := http.ParseTime(lm); err == nil {
+			info.ModTime = t
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,95 @@
+package task
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/pool"
+)
+
+func TestProbe(t *testing.T) {
+	modTime := time.Date(2021, 3, 16, 1, 2, 3, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, modTime, bytes.NewReader([]byte("hello")))
+	}))
+	defer srv.Close()
+
+	info, err := Probe(srv.URL+"/x.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 5 || !info.ModTime.Equal(modTime) || !info.AcceptRanges {
+		t.Fatalf("info = %+v", info)
+	}
+
+	_, err = Probe(srv.URL+"/missing?key=secret", nil)
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusNotFound {
+		t.Fatalf("err = %v", err)
+	}
+	if bytes.Contains([]byte(err.Error()), []byte("secret")) {
+		t.Fatalf("key leaked: %v", err)
+	}
+}
+
+func TestTask_StatusError(t *testing.T) {
+	if err := pool.Init(3); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 2*DefaultChunkSize)
+	// HEAD正常，GET时文件已被删除或者认证过期
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			if strings.HasPrefix(r.URL.Path, "/direct") {
+				w.Header().Set("Content-Length", "5")
+				return
+			}
+			http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/gone.bin") {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+
+	for _, c := range []struct {
+		path string
+		code int
+	}{
+		{"/gone.bin", http.StatusNotFound},
+		{"/auth.bin", http.StatusUnauthorized},
+		{"/direct/gone.bin", http.StatusNotFound},
+	} {
+		task, err := NewTaskWithOptions(srv.URL+c.path+"?key=secret", Options{Dir: dir, MaxTries: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.ChunkSupported == strings.HasPrefix(c.path, "/direct") {
+			t.Fatalf("%s: ChunkSupported=%v", c.path, task.ChunkSupported)
+		}
+		err = task.Start()
+		var se *StatusError
+		if !errors.As(err, &se) || se.Code != c.code || se.Method != http.MethodGet {
+			t.Fatalf("%s: err = %v", c.path, err)
+		}
+		if strings.Contains(err.Error(), "secret") {
+			t.Fatalf("key leaked: %v", err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package task
+
+import (
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/pool"
+	"github.com/azd1997/blockchair_downloader/speed"
+)
+
+// Stats 任务的统计信息
+type Stats struct {
+	BytesDone   int64 `json:"bytes_done"`
+	BytesTotal  int64 `json:"bytes_total"` // 服务端未返回大小时为-1
+	ChunksDone  int64 `json:"chunks_done"`
+	ChunksTotal int64 `json:"chunks_total"`
+
+	Speed    float64       `json:"speed"`     // 瞬时速度 B/s
+	AvgSpeed float64       `json:"avg_speed"` // 滑动平均速度 B/s
+	ETA      time.Duration `json:"eta"`       // 预计剩余时间，未知时为-1
+	Elapsed  time.Duration `json:"elapsed"`
+
+	ActiveConns int   `json:"active_conns"` // 正在下载的分块数
+	Retries     int64 `json:"retries"`      // 分块重试总次数
+	Paused      bool  `json:"paused"`
+}
+
+// Stats 获取任务的统计信息，可以在任意协程调用
+func (t *Task) Stats() Stats {
+	t.mu.Lock()
+	s := Stats{
+		BytesDone:   t.bytesDone,
+		BytesTotal:  t.FileSize,
+		ChunksDone:  t.ChunkNum - t.ChunkLeft,
+		ChunksTotal: t.ChunkNum,
+		Elapsed:     time.Since(t.StartTime),
+		Retries:     t.retries,
+		Paused:      t.paused,
+	}
+	direct := t.direct
+	t.mu.Unlock()
+
+	if t.ChunkSupported {
+		s.ActiveConns = pool.ActiveChunks(t.Url)
+	} else if direct {
+		s.ActiveConns = 1
+	}
+	s.Speed, s.AvgSpeed = t.meter.Rate()
+	rate := s.AvgSpeed
+	if rate <= 0 {
+		rate = s.Speed
+	}
+	if s.BytesTotal < 0 {
+		s.ETA = -1
+	} else {
+		s.ETA = speed.ETA(s.BytesTotal-s.BytesDone, rate)
+	}
+	return s
+}
+
+// addBytes 记录新完成的数据量
+func (t *Task) addBytes(n int64) {
+	t.mu.Lock()
+	t.bytesDone += n
+	t.mu.Unlock()
+	t.meter.Add(n)
+}
+
+// progressWriter 统计直接下载时写入的数据量
+type progressWriter struct {
+	t *Task
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	w.t.addBytes(int64(len(p)))
+	return len(p), nil
+}
@@ -0,0 +1,146 @@
+package task
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/pool"
+)
+
+func TestTask_Stats(t *testing.T) {
+	if err := pool.Init(4); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	data := make([]byte, 16*DefaultChunkSize+1)
+	rand.Read(data)
+	srv := newTestServer(data)
+	defer srv.Close()
+
+	task, err := NewTask(srv.URL + "/" + testFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeTaskFiles(task)
+
+	// 下载过程中并发读取统计信息
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s := task.Stats()
+				if s.BytesDone > s.BytesTotal || s.ChunksDone > s.ChunksTotal {
+					t.Errorf("invalid stats: %+v", s)
+				}
+				pool.Stats()
+			}
+		}
+	}()
+
+	err = task.Start()
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := task.Stats()
+	if s.BytesDone != int64(len(data)) || s.BytesTotal != int64(len(data)) {
+		t.Fatalf("bytes = %d/%d, want %d", s.BytesDone, s.BytesTotal, len(data))
+	}
+	if s.ChunksDone != task.ChunkNum || s.ChunksTotal != task.ChunkNum {
+		t.Fatalf("chunks = %d/%d, want %d", s.ChunksDone, s.ChunksTotal, task.ChunkNum)
+	}
+	if s.ETA != 0 || s.ActiveConns != 0 {
+		t.Fatalf("finished task should have no eta and connections: %+v", s)
+	}
+
+	ps := pool.Stats()
+	if ps.ChunksDone != task.ChunkNum || ps.BytesDone != int64(len(data)) {
+		t.Fatalf("pool stats = %+v", ps)
+	}
+	if ps.MaxDownloaders != 4 || ps.Tasks != 0 {
+		t.Fatalf("pool stats = %+v", ps)
+	}
+}
+
+func TestTask_StatsDirect(t *testing.T) {
+	if err := pool.Init(1); err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	defer pool.Stop()
+
+	// 不支持Range的服务器，分几次慢慢写出
+	data := make([]byte, 64<<10)
+	rand.Read(data)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		for i := 0; i < len(data); i += 8 << 10 {
+			w.Write(data[i : i+8<<10])
+			w.(http.Flusher).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	task, err := NewTask(srv.URL + "/" + testFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeTaskFiles(task)
+	if task.ChunkSupported {
+		t.Fatal("server should not support ranges")
+	}
+
+	// 直接下载过程中并发读取统计信息（go test -race）
+	stop := make(chan struct{})
+	var (
+		wg   sync.WaitGroup
+		seen int32
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				if s := task.Stats(); s.ActiveConns == 1 {
+					atomic.StoreInt32(&seen, 1)
+				}
+			}
+		}
+	}()
+
+	err = task.Start()
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&seen) != 1 {
+		t.Fatal("direct download should report one connection")
+	}
+	if s := task.Stats(); s.BytesDone != int64(len(data)) || s.ActiveConns != 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
@@ -1,21 +1,28 @@
 package task
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/azd1997/blockchair_downloader/edb"
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/logging"
 	"github.com/azd1997/blockchair_downloader/pool"
+	"github.com/azd1997/blockchair_downloader/speed"
 	"github.com/azd1997/ego/utils"
 )
 
@@ -31,6 +38,21 @@ const (
 	DownloadDir = "./download/"
 )
 
+var (
+	ErrTaskClosed = errors.New("task closed")
+	ErrNotRunning = errors.New("task is not running")
+	ErrPauseUnsupported = errors.New("pause is not supported when server does not accept ranges")
+)
+
+// logger task包的日志，见SetLogger
+var logger logging.Var
+
+// SetLogger 设置Task和Manager的日志，默认（或nil）不输出
+// Task的日志带有url_hash、url字段，由Manager启动的还带有job字段
+func SetLogger(l logging.Logger) {
+	logger.Set(l)
+}
+
 // Task 任务
 // 一个Task描述一个下载文件任务url等相关状态
 // 并发：将大文件拆分为众多小分块进行http
@@ -46,10 +68,12 @@ type Task struct {
 	ChunkSize int64 `json:"chunk_size"` // 标准的分块大小，1024倍数 暂设为4096
 	ChunkNum  int64 `json:"chunk_num"`  // 总共的分块数量
 	ChunkLeft int64	`json:"chunk_left"`// 剩下的分块数
-	Resume bool `json:"resume"` // 续传
+	Resuming bool `json:"resume"` // 续传
 
 	StartTime time.Time `json:"start_time"`	// 开始时间
 
+	Options Options `json:"options"`	// 任务选项
+
 	// 数据库
 	// 三种键：数据、任务、数量
 	// 数据键格式：D|[start][end]
@@ -58,8 +82,25 @@ type Task struct {
 	DbPath string `json:"db_path"`
 	db edb.DB	// 数据库连接实例
 
-	notify chan struct{}	// 用于向pool注册，当该Task所有分块下载完成后通知该Task
+	notify chan pool.Notice	// 用于向pool注册，每个分块下载结束后通知该Task
 	close chan struct{} // 该任务结束
+	closeOnce sync.Once	// 保证close只关闭一次，见Cancel
+
+	events eventHub	// 事件回调
+	log logging.Logger	// 带有任务字段的日志
+	logFields []interface{}	// 任务的字段，也附加在分块的日志上，见pool.TaskOptions.LogFields
+	queued bool	// 是否已发出过EventQueued
+
+	mu sync.Mutex	// 保护以下字段及ChunkLeft，它们会被Pause/Resume等方法并发访问
+	running bool	// 分块已提交给cdp，尚未结束
+	paused bool
+	pending map[int64]bool	// 尚未完成的分块，以Begin标识
+	direct bool	// 正在直接下载（不分块）
+
+	// 统计
+	meter *speed.Meter
+	bytesDone int64
+	retries int64
 }
 
 //func (t *Task) DecrChunkLeft() {
@@ -71,62 +112,80 @@ type Task struct {
 //}
 
 
-// NewTask 新建任务
+// NewTask 使用默认选项新建任务
 func NewTask(url string) (*Task, error) {
+	return NewTaskWithOptions(url, Options{})
+}
+
+// NewTaskWithOptions 新建任务
+func NewTaskWithOptions(url string, opts Options) (*Task, error) {
+	return newTask(url, opts)
+}
 
+// newTask 新建任务，fields附加在任务的日志上
+func newTask(url string, opts Options, fields ...interface{}) (*Task, error) {
+
+	if opts.Checksum != "" {
+		if _, _, err := parseChecksum(opts.Checksum); err != nil {
+			return nil, err
+		}
+	}
 	task := &Task{
 		Url: url,
+		Options: opts,
 		ChunkSize: DefaultChunkSize,
 		StartTime: time.Now(),
-		notify: make(chan struct{}),
+		notify: make(chan pool.Notice),
+		meter: speed.NewMeter(),
 		close: make(chan struct{}),
 	}
+	if opts.ChunkSize > 0 {
+		task.ChunkSize = opts.ChunkSize
+	}
 	//fmt.Println("hex(md5(url)) = ", task.UrlHash)
 
 	// 获取url哈希值（有些url特别长，所以用哈希值替代比较合适）
 	h := md5.Sum([]byte(url))
 	task.UrlHash = hex.EncodeToString(h[:])
 	//fmt.Println("hex(md5(url)) = ", task.UrlHash)
+	task.logFields = append(fields[:len(fields):len(fields)], "url_hash", task.UrlHash)
+	task.log = logging.With(&logger, append(task.logFields, "url", httpclient.Redact(url))...)
 
 	// 获取文件大小以及是否支持按字节分块传输
-	rsp, err := http.Head(url)
+	info, err := Probe(url, opts.Auth)
 	if err != nil {
-		//fmt.Println(rsp, err)
 		return nil, err
 	}
-	task.FileSize = rsp.ContentLength
-	task.ChunkSupported = rsp.Header.Get("Accept-Ranges") == "bytes" // 这表示服务端支持按字节下载
-	rsp.Body.Close()
+	task.FileSize = info.Size
+	task.ChunkSupported = info.AcceptRanges
 	//fmt.Println("task.fileSize = ", task.FileSize)
 	//fmt.Println("task.shardSupported = ", task.ChunkSupported)
 
-	// 确定分块数量
-	if task.ChunkSupported {
-		task.ChunkNum = task.FileSize / task.ChunkSize
-		if task.FileSize % task.ChunkSize != 0 {
-			task.ChunkNum++
-		}
-	}
-
 	// 检查下载目录是否存在
-	if exists, _ := utils.DirExists(DownloadDir); !exists {
-		err = os.MkdirAll(DownloadDir, 0777)
+	dir := opts.Dir
+	if dir == "" {
+		dir = DownloadDir
+	}
+	if exists, _ := utils.DirExists(dir); !exists {
+		err = os.MkdirAll(dir, 0777)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	// 文件名 确定下载的唯一文件名，避免文件名重复
-	strs := strings.Split(url, "/")
-	fileName := strs[len(strs)-1]
+	fileName := opts.FileName
+	if fileName == "" {
+		fileName = fileNameFromUrl(url)
+	}
 	if strings.TrimSpace(fileName) == "" {
 		fileName = task.UrlHash
 	}
-	// 本地如果已经有该文件名的文件，将该文件名追加日期
-	if exist, err := utils.FileExists(fileName); exist || err != nil {
+	fileName = filepath.Join(dir, fileName)
+	// 本地如果已经有该文件名的文件，将该文件名追加日期（Overwrite时直接覆盖）
+	if exist, err := utils.FileExists(fileName); !opts.Overwrite && (exist || err != nil) {
 		fileName = fileName + "-" + strconv.Itoa(int(time.Now().Unix()))
 	}
-	fileName = DownloadDir + fileName
 	task.FileName = fileName
 	//fmt.Println("task.fileName = ", task.FileName)
 
@@ -134,10 +193,15 @@ func NewTask(url string) (*Task, error) {
 		// 如果本地已经有对应数据库，说明是续传；否则根据fileSize分块，并写入数据库
 		dbPath := fileName + ".DOWNLOADING"
 		task.DbPath = dbPath
+		if opts.Restart && edb.DbExists(dbPath) {
+			if err := os.RemoveAll(dbPath); err != nil {
+				return nil, err
+			}
+		}
 		//fmt.Println("task.dbPath = ", task.FileName)
 		// 打开数据库（如果没有就创建）
 		if edb.DbExists(dbPath) {
-			task.Resume = true
+			task.Resuming = true
 		}
 
 		// 创建数据库连接实例，直到任务结束或程序停止才关闭
@@ -146,37 +210,129 @@ func NewTask(url string) (*Task, error) {
 			return nil, err
 		}
 		task.db = db
+
+		// 续传时沿用原来的分块大小，否则数据键与新的分块对不上
+		if task.Resuming {
+			err = task.loadChunkSize()
+		} else {
+			err = task.saveChunkSize()
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		// 确定分块数量
+		task.ChunkNum = task.FileSize / task.ChunkSize
+		if task.FileSize % task.ChunkSize != 0 {
+			task.ChunkNum++
+		}
 	}
 
 	// 打印信息
-	fmt.Println()
-	fmt.Println("==================== Task Info ====================")
-	fmt.Println(utils.JsonMarshalIndentToString(task))
-	fmt.Println()
+	task.log.Info("task created", "file", task.FileName, "size", task.FileSize,
+		"chunks", task.ChunkNum, "resume", task.Resuming)
 
 	return task, nil
 }
 
-// Start 开始下载任务
+// Start 开始下载任务，阻塞直至任务结束
+// 任务过程中的各个阶段通过OnEvent注册的回调通知
 func (t *Task) Start() error {
+	if !t.queued {
+		t.queued = true
+		t.emit(Event{Type: EventQueued})
+	}
+
+	untrack := t.track()
+	defer untrack()
+
+	var err error
 	if t.ChunkSupported {
-		return t.downloadChunkly()
+		err = t.downloadChunkly()
+	} else {
+		err = t.downloadDirectly()
+	}
+	if err == nil {
+		err = t.verify()
+	}
+	if err == ErrTaskClosed {
+		t.log.Info("task canceled", "elapsed", time.Since(t.StartTime))
+	} else if err != nil {
+		t.log.Error("task failed", "err", err, "elapsed", time.Since(t.StartTime))
+	} else {
+		t.log.Info("task completed", "file", t.FileName, "elapsed", time.Since(t.StartTime))
+	}
+	if err != nil {
+		t.emit(Event{Type: EventFailed, Err: err})
+		return err
 	}
-	return t.downloadDirectly()
+	t.emit(Event{Type: EventCompleted})
+	return nil
+}
+
+// fileNameFromUrl url路径的最后一段，不包括查询参数（可能带有key等敏感信息）
+func fileNameFromUrl(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." || name == ".." {
+		return ""
+	}
+	return name
 }
 
 // 直接下载（不支持分块下载的情况）
 func (t *Task) downloadDirectly() error {
-	rsp, err := http.Get(t.Url)
+	t.emit(Event{Type: EventStarted})
+	req, err := http.NewRequest(http.MethodGet, t.Url, nil)
+	if err != nil {
+		return err
+	}
+	// Cancel时中断请求
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-t.close:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	req = req.WithContext(ctx)
+	rsp, err := t.Options.Auth.Do(pool.HTTPClient(), req)
 	if err != nil {
 		return err
 	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= 400 {
+		return &StatusError{Method: http.MethodGet, Url: httpclient.Redact(t.Url), Code: rsp.StatusCode, Status: rsp.Status}
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", rsp.Status)
+	}
 	f, err := os.Create(t.FileName)
 	if err != nil {
 		return err
 	}
-	io.Copy(f, rsp.Body)
-	return nil
+	defer f.Close()
+	t.mu.Lock()
+	t.direct = true
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.direct = false
+		t.mu.Unlock()
+	}()
+	_, err = io.Copy(f, io.TeeReader(pool.LimitHostReader(req.Context(), req.URL.Hostname(), rsp.Body), progressWriter{t}))
+	select {
+	case <-t.close:
+		return ErrTaskClosed
+	default:
+	}
+	return err
 }
 
 // 分块下载
@@ -187,86 +343,49 @@ func (t *Task) downloadChunkly() error {
 
 	// 向cdp注册一个通知通道
 	pool.RegisterNotify(t.Url, t.notify)
+	pool.Configure(t.Url, t.poolOptions())
 
 	// 读取或添加所有分块任务
-	chunks := make([]*pool.Chunk, 0)
-	if t.Resume {
-		t.db.IterKey(func(k []byte) error {	// 符合条件的k就是任务 注意k最好拷贝后使用
-			if len(k) == 0 {
-				return errors.New("nil key")
-			}
-			typ := k[0]
-			if typ == TaskKeyPrefix {
-				if len(k) != KeyLength {	// 1 + 8 + 8
-					return errors.New("error task key format: length should = 17")
-				}
-				begin, end := int64(-1), int64(-1)
-				begin, _ = binary.Varint(k[1:9])
-				end, _ = binary.Varint(k[9:17])
-				if end < begin {
-					return errors.New("error task key format: begin should <= end")
-				}
-				t.ChunkLeft++	// 设置还剩下的任务数
-				// 对于一个符合条件的任务，要丢给下载池下载
-				dkstr := string(k)
-				dk := []byte(dkstr)
-				dk[0] = DataKeyPrefix
-				dkstr = string(dk)
-				chunks = append(chunks, &pool.Chunk{
-					Begin:  begin,
-					End:    end,
-					Url:    t.Url,
-					Db: t.db,
-					TaskKey: string(k),
-					DataKey: dkstr,
-				})
-			}
-			return nil
-		})
+	var (
+		chunks []*pool.Chunk
+		err    error
+	)
+	if t.Resuming {
+		chunks, err = t.loadChunks()
 	} else {
-		t.ChunkLeft = t.ChunkNum	// 设置还剩下的任务数
-		// 初次下载，需要划分任务
-		for i:=int64(1); i<=t.ChunkNum; i++ {
-			// 计算begin,end
-			begin := (i-1) * t.ChunkSize
-			end := begin + t.ChunkSize - 1
-			if end > t.FileSize - 1 {
-				end = t.FileSize - 1
-			}
-			// 构建key并存储
-			key := make([]byte, KeyLength)
-			key[0] = TaskKeyPrefix
-			binary.PutVarint(key[1:9], begin)
-			binary.PutVarint(key[9:17], end)
-			if err := t.db.Set(key, []byte{PlaceHolder}); err != nil {
-				return err
-			}
-			// 将分块任务发给cdp
-			dkstr := string(key)
-			dk := []byte(dkstr)
-			dk[0] = DataKeyPrefix
-			dkstr = string(dk)
-			chunks = append(chunks, &pool.Chunk{
-				Begin:  begin,
-				End:    end,
-				Url:    t.Url,
-				Db: t.db,
-				TaskKey: string(key),
-				DataKey: dkstr,
-			})
-		}
+		chunks, err = t.splitChunks()
+	}
+	if err != nil {
+		pool.RemoveNotify(t.Url)
+		t.db.Close()
+		return err
 	}
 
 	if len(chunks) == 0 {
+		pool.RemoveNotify(t.Url)
+		t.log.Info("no chunks need to download")
+		// 上次所有分块都已下载但合并失败，直接合并
+		err = t.mergeChunksToFile()
 		t.db.Close()
-		log.Println("no chunks need to download")
-		return nil
+		return err
 	}
 
-	// 下载
-	for i:=0; i<len(chunks); i++ {
-		pool.Download(*(chunks[i]))
+	// 设置还剩下的任务数
+	t.mu.Lock()
+	t.running = true
+	t.pending = make(map[int64]bool, len(chunks))
+	t.bytesDone = t.FileSize
+	for _, c := range chunks {
+		t.pending[c.Begin] = true
+		t.bytesDone -= c.End + 1 - c.Begin	// 续传时已完成的部分
 	}
+	t.ChunkLeft = int64(len(chunks))
+	t.mu.Unlock()
+	t.meter.Reset()
+
+	// 下载
+	t.dispatch(chunks)
+	t.emit(Event{Type: EventStarted})
 
 
 	// 得到数据库中的分块任务（无论是续传还是初传），这些任务需要传给下载器池cdp
@@ -274,72 +393,293 @@ func (t *Task) downloadChunkly() error {
 	// 数据库中所有分块任务结束后，任务下载完成
 
 	// 等待所有分块下载完成
+	ticker := time.NewTicker(ProgressInterval)
+	defer ticker.Stop()
+	progressed := false	// 上次EventProgress之后是否有新的分块完成
 	for {
 		select {
-		case <-t.notify: // 一个分块下载结束
+		case n := <-t.notify: // 一个分块下载结束
+			switch n.Type {
+			case pool.NoticeRetry:
+				t.mu.Lock()
+				t.retries++
+				t.mu.Unlock()
+				t.emit(Event{Type: EventRetrying, Begin: n.Begin, End: n.End, Tried: n.Tried, Err: n.Err})
+				continue
+			case pool.NoticeFail:
+				t.stop()
+				// 最后一次失败是状态码错误时带上*StatusError，调用者据此区分404、401等
+				if n.Status >= 400 {
+					return fmt.Errorf("chunk %d-%d: %v: %w", n.Begin, n.End, n.Err, &StatusError{Method: http.MethodGet,
+						Url: httpclient.Redact(t.Url), Code: n.Status, Status: fmt.Sprintf("%d %s", n.Status, http.StatusText(n.Status))})
+				}
+				return fmt.Errorf("chunk %d-%d: %w", n.Begin, n.End, n.Err)
+			}
+			t.mu.Lock()
+			if !t.pending[n.Begin] {	// 暂停前未中断的分块在恢复后又被提交了一次，重复的通知忽略
+				t.mu.Unlock()
+				continue
+			}
+			delete(t.pending, n.Begin)
 			t.ChunkLeft--
-			log.Printf("Task(%s): downloaded (%d/%d) elapsed %s\n",
-				t.Url, (t.ChunkNum - t.ChunkLeft), t.ChunkNum, time.Now().Sub(t.StartTime).String())
-			if t.ChunkLeft == 0 {
+			left := t.ChunkLeft
+			if left == 0 {
+				t.running = false
+			}
+			t.mu.Unlock()
+			t.addBytes(n.End + 1 - n.Begin)
+			progressed = true
+			t.emit(Event{Type: EventChunkDone, Begin: n.Begin, End: n.End, Tried: n.Tried})
+			if left == 0 {
 				pool.RemoveNotify(t.Url)
+				t.emit(Event{Type: EventProgress})
 				err := t.mergeChunksToFile()	// 合并文件
-				if err != nil {
-					return err
-				}
-				// 关闭数据库
+				// 合并失败也要关闭数据库，否则目录锁一直被占用，续传时无法再打开
 				t.db.Close()
-				return nil
+				return err
+			}
+		case <-ticker.C:
+			if progressed {
+				progressed = false
+				t.emit(Event{Type: EventProgress})
 			}
 		case <-t.close:
-			log.Printf("Task(%s): downloaded (%d/%d) elapsed %s. quit unexpectly\n",
-				t.Url, (t.ChunkNum - t.ChunkLeft), t.ChunkNum, time.Now().Sub(t.StartTime).String())
-			// 关闭数据库
-			t.db.Close()
-			return nil
+			t.log.Debug("chunks stopped", "done", t.ChunkNum - t.chunkLeft(), "chunks", t.ChunkNum)
+			t.stop()
+			return ErrTaskClosed
 		}
 	}
 }
 
-func (t *Task) mergeChunksToFile() error {
-	// 创建文件
-	f, err := os.Create(t.FileName)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+// stop 异常结束分块下载：注销通知通道并关闭数据库
+func (t *Task) stop() {
+	t.mu.Lock()
+	t.running = false
+	t.mu.Unlock()
+	pool.RemoveNotify(t.Url)
+	t.db.Close()
+}
 
-	// 拼接所有分块
+// chunkLeft 并发安全地读取剩余分块数
+func (t *Task) chunkLeft() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ChunkLeft
+}
+
+// splitChunks 初次下载，划分分块并将分块任务写入数据库
+func (t *Task) splitChunks() ([]*pool.Chunk, error) {
+	chunks := make([]*pool.Chunk, 0, t.ChunkNum)
 	for i:=int64(1); i<=t.ChunkNum; i++ {
 		// 计算begin,end
-		begin := (i - 1) * t.ChunkSize
+		begin := (i-1) * t.ChunkSize
 		end := begin + t.ChunkSize - 1
-		if end > t.FileSize-1 {
+		if end > t.FileSize - 1 {
 			end = t.FileSize - 1
 		}
-		// 构建key
+		// 构建key并存储
 		key := make([]byte, KeyLength)
-		key[0] = DataKeyPrefix
+		key[0] = TaskKeyPrefix
 		binary.PutVarint(key[1:9], begin)
 		binary.PutVarint(key[9:17], end)
-		// 查询对应数据
-		v, err := t.db.Get(key)
-		if err != nil {
-			return err
+		if err := t.db.Set(key, []byte{PlaceHolder}); err != nil {
+			return nil, err
 		}
-		// 写入文件
-		_, err = f.WriteAt(v, begin)
+		chunks = append(chunks, t.newChunk(key, begin, end))
+	}
+	return chunks, nil
+}
+
+// numKey 数量键N，保存分块大小
+var numKey = []byte{NumKeyPrefix}
+
+// saveChunkSize 初次下载时将分块大小写入数据库
+func (t *Task) saveChunkSize() error {
+	v := make([]byte, binary.MaxVarintLen64)
+	return t.db.Set(numKey, v[:binary.PutVarint(v, t.ChunkSize)])
+}
+
+// loadChunkSize 续传时读取分块大小
+// 没有N键的旧数据库从begin为0的分块推算：分块大小即其长度
+func (t *Task) loadChunkSize() error {
+	if t.db.Has(numKey) {
+		v, err := t.db.Get(numKey)
 		if err != nil {
 			return err
 		}
+		size, n := binary.Varint(v)
+		if n <= 0 || size <= 0 {
+			return errors.New("error num key format")
+		}
+		t.ChunkSize = size
+		return nil
 	}
+	var size int64
+	t.db.IterKey(func(k []byte) error {
+		if len(k) == KeyLength && (k[0] == TaskKeyPrefix || k[0] == DataKeyPrefix) {
+			if begin, _ := binary.Varint(k[1:9]); begin == 0 {
+				end, _ := binary.Varint(k[9:17])
+				size = end + 1
+			}
+		}
+		return nil
+	})
+	if size <= 0 {
+		return errors.New("cannot determine chunk size of " + t.DbPath)
+	}
+	t.ChunkSize = size
+	return t.saveChunkSize()
+}
 
-	// 打印文件信息
-	stat, _ := f.Stat()
-	fmt.Printf("文件大小：%d byte\n", stat.Size())
+// loadChunks 从数据库读取所有尚未完成的分块任务(T键)
+func (t *Task) loadChunks() ([]*pool.Chunk, error) {
+	chunks := make([]*pool.Chunk, 0)
+	parse := func(k []byte) error {	// 符合条件的k就是任务 注意k最好拷贝后使用
+		if len(k) == 0 {
+			return errors.New("nil key")
+		}
+		typ := k[0]
+		if typ == TaskKeyPrefix {
+			if len(k) != KeyLength {	// 1 + 8 + 8
+				return errors.New("error task key format: length should = 17")
+			}
+			begin, end := int64(-1), int64(-1)
+			begin, _ = binary.Varint(k[1:9])
+			end, _ = binary.Varint(k[9:17])
+			if end < begin {
+				return errors.New("error task key format: begin should <= end")
+			}
+			// 对于一个符合条件的任务，要丢给下载池下载
+			chunks = append(chunks, t.newChunk([]byte(string(k)), begin, end))
+		}
+		return nil
+	}
+	// IterKey不返回错误，这里记录第一个解析错误
+	var err error
+	t.db.IterKey(func(k []byte) error {
+		e := parse(k)
+		if e != nil && err == nil {
+			err = e
+		}
+		return e
+	})
+	return chunks, err
+}
+
+// newChunk 根据任务键构建分块，数据键与任务键仅前缀不同
+func (t *Task) newChunk(taskKey []byte, begin, end int64) *pool.Chunk {
+	dk := []byte(string(taskKey))
+	dk[0] = DataKeyPrefix
+	return &pool.Chunk{
+		Begin:   begin,
+		End:     end,
+		Url:     t.Url,
+		Db:      t.db,
+		TaskKey: string(taskKey),
+		DataKey: string(dk),
+	}
+}
+
+// dispatch 将分块任务发给cdp
+func (t *Task) dispatch(chunks []*pool.Chunk) {
+	for i:=0; i<len(chunks); i++ {
+		pool.Download(*(chunks[i]))
+	}
+}
+
+// Pause 暂停任务
+// 该任务已排队的分块不再下载，下载器释放给其他任务；abort为true时同时中断正在下载的分块
+// 数据库保持打开，Start仍阻塞等待，之后可调用Resume继续
+func (t *Task) Pause(abort bool) error {
+	if !t.ChunkSupported {
+		return ErrPauseUnsupported
+	}
+	t.mu.Lock()
+	if !t.running {
+		t.mu.Unlock()
+		return ErrNotRunning
+	}
+	if t.paused {
+		t.mu.Unlock()
+		return nil
+	}
+	t.paused = true
+	t.mu.Unlock()
+
+	pool.Pause(t.Url, abort)
+	t.meter.Reset()
+	t.emit(Event{Type: EventPaused})
 	return nil
 }
 
-func (t *Task) mergeChunksToFile2() error {
+// Resume 恢复暂停的任务，将数据库中剩余的分块任务(T键)重新提交给cdp
+func (t *Task) Resume() error {
+	t.mu.Lock()
+	if !t.paused {
+		t.mu.Unlock()
+		return nil
+	}
+	t.paused = false
+	t.mu.Unlock()
+
+	pool.Resume(t.Url)
+	chunks, err := t.loadChunks()
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	for _, c := range chunks {
+		if !t.pending[c.Begin] {	// 一般不会发生：未完成的分块都应在pending中
+			t.pending[c.Begin] = true
+			t.ChunkLeft++
+		}
+	}
+	t.mu.Unlock()
+
+	t.dispatch(chunks)
+	t.emit(Event{Type: EventResumed})
+	return nil
+}
+
+// Cancel 取消任务，正在下载的分块被中断，Start返回ErrTaskClosed
+// 已下载的分块保留在.DOWNLOADING中，之后用相同的url和选项新建任务可以续传
+func (t *Task) Cancel() {
+	t.closeOnce.Do(func() { close(t.close) })
+}
+
+// SetSchedule 修改任务在下载器池中的优先级、权重和是否按顺序下载，对正在下载的任务立即生效
+func (t *Task) SetSchedule(priority, weight int, inOrder bool) {
+	t.mu.Lock()
+	t.Options.Priority, t.Options.Weight, t.Options.InOrder = priority, weight, inOrder
+	running := t.running
+	t.mu.Unlock()
+	if running {
+		pool.Configure(t.Url, t.poolOptions())
+	}
+}
+
+// poolOptions 任务在cdp中的调度选项
+func (t *Task) poolOptions() pool.TaskOptions {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return pool.TaskOptions{
+		Priority: t.Options.Priority,
+		Weight:   t.Options.Weight,
+		InOrder:  t.Options.InOrder,
+		Auth:     t.Options.Auth,
+		MaxTries: t.Options.MaxTries,
+		LogFields: t.logFields,
+	}
+}
+
+// Paused 任务是否处于暂停状态
+func (t *Task) Paused() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.paused
+}
+
+func (t *Task) mergeChunksToFile() error {
 	// 创建文件
 	f, err := os.Create(t.FileName)
 	if err != nil {
@@ -374,6 +714,6 @@ func (t *Task) mergeChunksToFile2() error {
 
 	// 打印文件信息
 	stat, _ := f.Stat()
-	fmt.Printf("文件大小：%d byte\n", stat.Size())
+	t.log.Debug("chunks merged", "file", t.FileName, "bytes", stat.Size())
 	return nil
 }
@@ -0,0 +1,131 @@
+package urlgen
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateFormat 日期范围起止时间的一种写法及其默认步长
+type dateFormat struct {
+	layout string
+	step   step
+}
+
+// dateFormats 按顺序尝试；起止时间的写法同时也是默认的输出格式
+var dateFormats = []dateFormat{
+	{"2006-01-02T15:04", step{n: 1, unit: "h"}},
+	{"2006-01-02T15", step{n: 1, unit: "h"}},
+	{"2006010215", step{n: 1, unit: "h"}},
+	{"2006-01-02", step{n: 1, unit: "d"}},
+	{"20060102", step{n: 1, unit: "d"}},
+	{"2006-01", step{n: 1, unit: "mo"}},
+	{"200601", step{n: 1, unit: "mo"}},
+	{"2006", step{n: 1, unit: "y"}},
+}
+
+// step 日期的步长，unit为h、d、w、mo、y
+type step struct {
+	n    int
+	unit string
+}
+
+func (s step) add(t time.Time) time.Time {
+	switch s.unit {
+	case "h":
+		return t.Add(time.Duration(s.n) * time.Hour)
+	case "d":
+		return t.AddDate(0, 0, s.n)
+	case "w":
+		return t.AddDate(0, 0, 7*s.n)
+	case "mo":
+		return t.AddDate(0, s.n, 0)
+	default:
+		return t.AddDate(s.n, 0, 0)
+	}
+}
+
+func parseStep(s string) (step, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		return step{}, fmt.Errorf("step %q has no unit (h, d, w, mo, y)", s)
+	}
+	n := 1
+	if i > 0 {
+		n, _ = strconv.Atoi(s[:i])
+	}
+	unit := s[i:]
+	switch unit {
+	case "h", "d", "w", "mo", "y":
+	default:
+		return step{}, fmt.Errorf("unknown step unit %q (h, d, w, mo, y)", unit)
+	}
+	if n <= 0 {
+		return step{}, fmt.Errorf("invalid step %q", s)
+	}
+	return step{n: n, unit: unit}, nil
+}
+
+// parseDates <START..END[,STEP][|LAYOUT]>
+//
+// START和END的写法可以是 2021-03-01T15、2021-03-01、20210301、2021-03、202103、2021 等，两者写法必须相同；
+// STEP如 1d、6h、2w、1mo、1y，默认按START的精度，如20210301为1d、2021-03为1mo；
+// LAYOUT为Go的时间格式，默认与START的写法相同，如<2021-03-01..2021-03-31|2006/01/02>。
+func parseDates(expr string) ([]string, error) {
+	var layout string
+	if i := strings.IndexByte(expr, '|'); i >= 0 {
+		expr, layout = expr[:i], expr[i+1:]
+		if layout == "" {
+			return nil, errors.New("empty layout")
+		}
+	}
+	var stepStr string
+	if i := strings.IndexByte(expr, ','); i >= 0 {
+		expr, stepStr = expr[:i], expr[i+1:]
+	}
+	i := strings.Index(expr, "..")
+	if i < 0 {
+		return nil, errors.New("want START..END")
+	}
+	from, to := expr[:i], expr[i+2:]
+
+	var (
+		f          dateFormat
+		start, end time.Time
+		err        error
+	)
+	for _, f = range dateFormats {
+		if start, err = time.Parse(f.layout, from); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid start %q", from)
+	}
+	if end, err = time.Parse(f.layout, to); err != nil {
+		return nil, fmt.Errorf("end %q is not in the same format as start %q", to, from)
+	}
+	if start.After(end) {
+		return nil, errors.New("start > end")
+	}
+	st := f.step
+	if stepStr != "" {
+		if st, err = parseStep(stepStr); err != nil {
+			return nil, err
+		}
+	}
+	if layout == "" {
+		layout = f.layout
+	}
+
+	var values []string
+	for t := start; !t.After(end); t = st.add(t) {
+		if len(values) >= MaxItems {
+			return nil, fmt.Errorf("more than %d values", MaxItems)
+		}
+		values = append(values, t.Format(layout))
+	}
+	return values, nil
+}
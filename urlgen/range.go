@@ -0,0 +1,61 @@
+package urlgen
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseRange [1-100]、[001-100:5]、[a-z]
+func parseRange(expr string) ([]string, error) {
+	step := 1
+	if i := strings.LastIndexByte(expr, ':'); i >= 0 {
+		n, err := strconv.Atoi(expr[i+1:])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid step %q", expr[i+1:])
+		}
+		expr, step = expr[:i], n
+	}
+	i := strings.IndexByte(expr, '-')
+	if i <= 0 || i == len(expr)-1 {
+		return nil, errors.New("want START-END")
+	}
+	from, to := expr[:i], expr[i+1:]
+
+	if isLetter(from) && isLetter(to) {
+		if from[0] > to[0] {
+			return nil, errors.New("start > end")
+		}
+		var values []string
+		for c := int(from[0]); c <= int(to[0]); c += step {
+			values = append(values, string(rune(c)))
+		}
+		return values, nil
+	}
+
+	start, err1 := strconv.ParseInt(from, 10, 64)
+	end, err2 := strconv.ParseInt(to, 10, 64)
+	if err1 != nil || err2 != nil || start < 0 {
+		return nil, fmt.Errorf("invalid range %s-%s", from, to)
+	}
+	if start > end {
+		return nil, errors.New("start > end")
+	}
+	if (end-start)/int64(step) >= MaxItems {
+		return nil, fmt.Errorf("more than %d values", MaxItems)
+	}
+	width := 0
+	if len(from) > 1 && from[0] == '0' {
+		width = len(from)
+	}
+	values := make([]string, 0, (end-start)/int64(step)+1)
+	for n := start; n <= end; n += int64(step) {
+		values = append(values, fmt.Sprintf("%0*d", width, n))
+	}
+	return values, nil
+}
+
+func isLetter(s string) bool {
+	return len(s) == 1 && (s[0] >= 'a' && s[0] <= 'z' || s[0] >= 'A' && s[0] <= 'Z')
+}
@@ -0,0 +1,176 @@
+// Package urlgen 按模板批量生成url
+//
+// 模板中可以有多个占位符，生成所有组合（笛卡尔积），左边的占位符变化最慢：
+//
+//	{a,b,c}                     列表
+//	[1-100] [001-100] [0-100:5] 数字范围，起始值有前导0时补齐到相同宽度，:后为步长
+//	[a-z] [A-Z:2]               字母范围
+//	<20210101..20210131>        日期范围，见parseDates
+//
+// \后的字符按原样输出，如\{、\[、\<。
+package urlgen
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxItems 一个模板最多生成的url数，防止写错范围时耗尽内存
+const MaxItems = 10000000
+
+// Item 生成的一个url，Values为各占位符的取值
+type Item struct {
+	Url    string
+	Values []string
+}
+
+// Format 将name中的#1、#2...替换为第1、2...个占位符的取值（类似curl -o），用于生成文件名
+func (it Item) Format(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '#' {
+			j := i + 1
+			for j < len(name) && name[j] >= '0' && name[j] <= '9' {
+				j++
+			}
+			var n int
+			if j > i+1 {
+				fmt.Sscan(name[i+1:j], &n)
+			}
+			if n >= 1 && n <= len(it.Values) {
+				b.WriteString(it.Values[n-1])
+				i = j - 1
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+// Template 解析后的模板
+type Template struct {
+	literals []string   // len(literals) == len(values)+1
+	values   [][]string // 每个占位符的所有取值
+}
+
+// Parse 解析模板
+func Parse(s string) (*Template, error) {
+	t := &Template{}
+	var lit strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) {
+			i++
+			lit.WriteByte(s[i])
+			continue
+		}
+		end, ok := closing[c]
+		if !ok {
+			lit.WriteByte(c)
+			continue
+		}
+		j := strings.IndexByte(s[i+1:], end)
+		if j < 0 {
+			return nil, fmt.Errorf("urlgen: unclosed %q at %d", c, i)
+		}
+		expr := s[i+1 : i+1+j]
+		values, err := parsers[c](expr)
+		if err != nil {
+			return nil, fmt.Errorf("urlgen: %c%s%c: %v", c, expr, end, err)
+		}
+		t.literals = append(t.literals, lit.String())
+		t.values = append(t.values, values)
+		lit.Reset()
+		i += j + 1
+	}
+	t.literals = append(t.literals, lit.String())
+	if t.Count() > MaxItems {
+		return nil, fmt.Errorf("urlgen: %q expands to more than %d urls", s, MaxItems)
+	}
+	return t, nil
+}
+
+var closing = map[byte]byte{'{': '}', '[': ']', '<': '>'}
+
+var parsers = map[byte]func(string) ([]string, error){
+	'{': parseList,
+	'[': parseRange,
+	'<': parseDates,
+}
+
+// Count 生成的url数
+func (t *Template) Count() int {
+	n := 1
+	for _, v := range t.values {
+		n *= len(v)
+		if n > MaxItems {
+			return MaxItems + 1
+		}
+	}
+	return n
+}
+
+// Each 按顺序生成每个url，fn返回错误时停止并返回该错误
+func (t *Template) Each(fn func(Item) error) error {
+	idx := make([]int, len(t.values))
+	for {
+		it := Item{Values: make([]string, len(t.values))}
+		var b strings.Builder
+		for k, v := range t.values {
+			b.WriteString(t.literals[k])
+			b.WriteString(v[idx[k]])
+			it.Values[k] = v[idx[k]]
+		}
+		b.WriteString(t.literals[len(t.values)])
+		it.Url = b.String()
+		if err := fn(it); err != nil {
+			return err
+		}
+
+		// 右边的占位符先进位
+		k := len(idx) - 1
+		for ; k >= 0; k-- {
+			if idx[k]++; idx[k] < len(t.values[k]) {
+				break
+			}
+			idx[k] = 0
+		}
+		if k < 0 {
+			return nil
+		}
+	}
+}
+
+// Items 所有生成的url及占位符取值
+func (t *Template) Items() []Item {
+	items := make([]Item, 0, t.Count())
+	t.Each(func(it Item) error {
+		items = append(items, it)
+		return nil
+	})
+	return items
+}
+
+// Expand 解析模板并返回生成的所有url
+func Expand(s string) ([]string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, t.Count())
+	t.Each(func(it Item) error {
+		urls = append(urls, it.Url)
+		return nil
+	})
+	return urls, nil
+}
+
+// parseList {a,b,c}
+func parseList(expr string) ([]string, error) {
+	if expr == "" {
+		return nil, errors.New("empty list")
+	}
+	return strings.Split(expr, ","), nil
+}
@@ -0,0 +1,67 @@
+package urlgen
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	cases := []struct {
+		tmpl string
+		want []string
+	}{
+		{"http://h/a", []string{"http://h/a"}},
+		{"http://h/{a,b}/[1-3]", []string{"http://h/a/1", "http://h/a/2", "http://h/a/3", "http://h/b/1", "http://h/b/2", "http://h/b/3"}},
+		{"f[08-11].gz", []string{"f08.gz", "f09.gz", "f10.gz", "f11.gz"}},
+		{"f[0-10:5]", []string{"f0", "f5", "f10"}},
+		{"[a-c][X-Z:2]", []string{"aX", "aZ", "bX", "bZ", "cX", "cZ"}},
+		{"d_<20210130..20210202>", []string{"d_20210130", "d_20210131", "d_20210201", "d_20210202"}},
+		{"<2021-01-30..2021-02-02,2d|2006/01/02>", []string{"2021/01/30", "2021/02/01"}},
+		{"<2020-11..2021-02>", []string{"2020-11", "2020-12", "2021-01", "2021-02"}},
+		{"<202011..202105,3mo|2006_01>", []string{"2020_11", "2021_02", "2021_05"}},
+		{"<2021-03-01T22..2021-03-02T01|2006010215>", []string{"2021030122", "2021030123", "2021030200", "2021030201"}},
+		{"<2021-03-01..2021-03-15,1w>", []string{"2021-03-01", "2021-03-08", "2021-03-15"}},
+		{`a\{b\}\[1-2]`, []string{"a{b}[1-2]"}},
+	}
+	for _, c := range cases {
+		got, err := Expand(c.tmpl)
+		if err != nil {
+			t.Errorf("%s: %v", c.tmpl, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\n got %v\nwant %v", c.tmpl, got, c.want)
+		}
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	for _, tmpl := range []string{
+		"{a,b", "[1-", "[5-1]", "[1-x]", "[1-3:0]", "{}",
+		"<20210102..20210101>", "<20210101..2021-01-02>", "<2021..2022,1x>", "<x..y>", "<20210101>",
+		"[0-99999999][0-99999999]",
+	} {
+		if _, err := Parse(tmpl); err == nil {
+			t.Errorf("%s: want error", tmpl)
+		}
+	}
+}
+
+func TestItem_Format(t *testing.T) {
+	tmpl, err := Parse("http://h/{bitcoin,dogecoin}/<20210301..20210302>.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.Count() != 4 {
+		t.Fatalf("Count = %d", tmpl.Count())
+	}
+	var names []string
+	for _, it := range tmpl.Items() {
+		names = append(names, it.Format("#1_#2_#3#.gz"))
+	}
+	want := "bitcoin_20210301_#3#.gz bitcoin_20210302_#3#.gz dogecoin_20210301_#3#.gz dogecoin_20210302_#3#.gz"
+	if got := strings.Join(names, " "); got != want {
+		t.Fatalf("got %s", got)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/listing"
+)
+
+const (
+	baseUrl = "https://gz.blockchair.com"
+
+	// latestDate 不按日期导出的表（如addresses）只有一个最新的快照文件
+	latestDate = "latest"
+)
+
+// bitcoinLikeTables 比特币系的链都有这些表
+var bitcoinLikeTables = []string{"blocks", "transactions", "inputs", "outputs", "addresses"}
+
+// chainTables 各条链支持的表，见 https://gz.blockchair.com
+var chainTables = map[string][]string{
+	"bitcoin":      bitcoinLikeTables,
+	"bitcoin-cash": bitcoinLikeTables,
+	"bitcoin-sv":   bitcoinLikeTables,
+	"litecoin":     bitcoinLikeTables,
+	"dogecoin":     bitcoinLikeTables,
+	"dash":         bitcoinLikeTables,
+	"groestlcoin":  bitcoinLikeTables,
+	"zcash":        bitcoinLikeTables,
+	"ecash":        bitcoinLikeTables,
+	"ethereum":     {"blocks", "transactions", "calls", "erc-20/tokens", "erc-20/transactions", "addresses"},
+}
+
+// undatedTables 只有最新快照、不按日期导出的表
+var undatedTables = map[string]bool{
+	"addresses": true,
+}
+
+// dataset 一条链的一张表
+type dataset struct {
+	chain string
+	table string
+}
+
+// defaultDataset 不指定-chain/-tables时下载的数据集
+var defaultDataset = dataset{chain: "bitcoin", table: "inputs"}
+
+// url 某一天的导出文件地址，如
+// https://gz.blockchair.com/bitcoin/inputs/blockchair_bitcoin_inputs_20210315.tsv.gz
+func (d dataset) url(date string) string {
+	if !d.dated() {
+		date = latestDate
+	}
+	return fmt.Sprintf("%s/%s/%s/blockchair_%s_%s_%s.tsv.gz",
+		baseUrl, d.chain, d.table, d.chain, strings.Replace(d.table, "/", "_", -1), date)
+}
+
+// dir 数据集在服务器上的目录，其索引页列出了所有可下载的文件
+func (d dataset) dir() string {
+	return fmt.Sprintf("%s/%s/%s/", baseUrl, d.chain, d.table)
+}
+
+func (d dataset) dated() bool {
+	return !undatedTables[d.table]
+}
+
+// parseDatasets 解析-chain和-tables参数，均为逗号分隔，tables为all时选择链支持的所有表
+func parseDatasets(chains, tables string) ([]dataset, error) {
+	var sets []dataset
+	for _, chain := range splitList(chains) {
+		supported, ok := chainTables[chain]
+		if !ok {
+			return nil, fmt.Errorf("unknown chain %q, supported: %s", chain, strings.Join(knownChains(), ", "))
+		}
+		names := splitList(tables)
+		if len(names) == 1 && names[0] == "all" {
+			names = supported
+		}
+		for _, table := range names {
+			if !contains(supported, table) {
+				return nil, fmt.Errorf("chain %s has no table %q, supported: %s", chain, table, strings.Join(supported, ", "))
+			}
+			sets = append(sets, dataset{chain: chain, table: table})
+		}
+	}
+	if len(sets) == 0 {
+		return nil, fmt.Errorf("no chain or table selected")
+	}
+	return sets, nil
+}
+
+// outputDir 文件的保存目录：out下与远程相同的 链/表 子目录
+func outputDir(out, rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return out
+	}
+	return filepath.Join(out, filepath.FromSlash(strings.TrimPrefix(path.Dir(u.Path), "/")))
+}
+
+// listFiles 读取各数据集目录的索引页，返回 url -> 服务器上的文件
+func listFiles(sets []dataset, fetch func(dir string) ([]listing.Entry, error)) (map[string]listing.Entry, error) {
+	files := make(map[string]listing.Entry)
+	for _, set := range sets {
+		entries, err := fetch(set.dir())
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.Dir {
+				files[e.Url] = e
+			}
+		}
+	}
+	return files, nil
+}
+
+// checkSize 用索引页中的精确大小校验下载的文件
+func checkSize(file string, e listing.Entry) error {
+	if !e.Exact() {
+		return nil
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if fi.Size() != e.Size {
+		return fmt.Errorf("%s: size %d, want %d", file, fi.Size(), e.Size)
+	}
+	return nil
+}
+
+func knownChains() []string {
+	chains := make([]string, 0, len(chainTables))
+	for c := range chainTables {
+		chains = append(chains, c)
+	}
+	sort.Strings(chains)
+	return chains
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// dates start到end（包括end）的每一天
+func dates(start, end time.Time) []string {
+	var ds []string
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		ds = append(ds, timeToDate(d))
+	}
+	return ds
+}
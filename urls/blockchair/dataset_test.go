@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/listing"
+)
+
+func TestDataset_url(t *testing.T) {
+	cases := []struct {
+		set  dataset
+		want string
+	}{
+		{defaultDataset, "https://gz.blockchair.com/bitcoin/inputs/blockchair_bitcoin_inputs_20210315.tsv.gz"},
+		{dataset{"bitcoin-cash", "blocks"}, "https://gz.blockchair.com/bitcoin-cash/blocks/blockchair_bitcoin-cash_blocks_20210315.tsv.gz"},
+		{dataset{"ethereum", "erc-20/transactions"}, "https://gz.blockchair.com/ethereum/erc-20/transactions/blockchair_ethereum_erc-20_transactions_20210315.tsv.gz"},
+		{dataset{"litecoin", "addresses"}, "https://gz.blockchair.com/litecoin/addresses/blockchair_litecoin_addresses_latest.tsv.gz"},
+	}
+	for _, c := range cases {
+		if got := c.set.url("20210315"); got != c.want {
+			t.Errorf("%v: got %s", c.set, got)
+		}
+	}
+}
+
+func TestParseDatasets(t *testing.T) {
+	sets, err := parseDatasets("bitcoin, dogecoin", "blocks,outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []dataset{{"bitcoin", "blocks"}, {"bitcoin", "outputs"}, {"dogecoin", "blocks"}, {"dogecoin", "outputs"}}
+	if !reflect.DeepEqual(sets, want) {
+		t.Fatalf("got %v", sets)
+	}
+
+	sets, err = parseDatasets("ethereum", "all")
+	if err != nil || len(sets) != len(chainTables["ethereum"]) {
+		t.Fatalf("all: %v %v", sets, err)
+	}
+
+	for _, bad := range [][2]string{{"nocoin", "blocks"}, {"bitcoin", "calls"}, {"", "blocks"}} {
+		if _, err := parseDatasets(bad[0], bad[1]); err == nil {
+			t.Errorf("parseDatasets(%q, %q) should fail", bad[0], bad[1])
+		}
+	}
+}
+
+func TestGenUrls_datasets(t *testing.T) {
+	start := time.Date(2021, 3, 15, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 0, 1)
+	got := genUrls(start, end, dataset{"bitcoin", "blocks"}, dataset{"bitcoin", "addresses"}, dataset{"bitcoin", "inputs"})
+	want := []string{
+		"https://gz.blockchair.com/bitcoin/addresses/blockchair_bitcoin_addresses_latest.tsv.gz",
+		"https://gz.blockchair.com/bitcoin/blocks/blockchair_bitcoin_blocks_20210315.tsv.gz",
+		"https://gz.blockchair.com/bitcoin/inputs/blockchair_bitcoin_inputs_20210315.tsv.gz",
+		"https://gz.blockchair.com/bitcoin/blocks/blockchair_bitcoin_blocks_20210316.tsv.gz",
+		"https://gz.blockchair.com/bitcoin/inputs/blockchair_bitcoin_inputs_20210316.tsv.gz",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v", got)
+	}
+
+	// 不指定数据集时与原来一样下载bitcoin的inputs
+	if got := genUrls(start, start); len(got) != 1 || got[0] != defaultDataset.url("20210315") {
+		t.Fatalf("default: %v", got)
+	}
+}
+
+func TestOutputDir(t *testing.T) {
+	got := outputDir("./download", "https://gz.blockchair.com/ethereum/erc-20/tokens/blockchair_ethereum_erc-20_tokens_20210315.tsv.gz")
+	if want := filepath.Join("download", "ethereum", "erc-20", "tokens"); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	blocks := dataset{"bitcoin", "blocks"}
+	fetched := map[string]bool{}
+	files, err := listFiles([]dataset{blocks, defaultDataset}, func(dir string) ([]listing.Entry, error) {
+		fetched[dir] = true
+		if dir != blocks.dir() {
+			return nil, nil
+		}
+		return []listing.Entry{
+			{Name: "old", Url: dir + "old/", Dir: true, Size: -1},
+			{Name: "f", Url: blocks.url("20210315"), Size: 3},
+		}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[blocks.url("20210315")].Size != 3 || !fetched[defaultDataset.dir()] {
+		t.Fatalf("files = %v, fetched = %v", files, fetched)
+	}
+
+	file := filepath.Join(t.TempDir(), "f")
+	ioutil.WriteFile(file, []byte("abc"), 0644)
+	if err := checkSize(file, listing.Entry{Size: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSize(file, listing.Entry{Size: 4}); err == nil {
+		t.Fatal("want size mismatch")
+	}
+	if err := checkSize(file, listing.Entry{Size: 1 << 20, Approx: true}); err != nil {
+		t.Fatal("approx size should not be checked")
+	}
+}
@@ -4,28 +4,68 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/azd1997/blockchair_downloader/config"
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/listing"
+	"github.com/azd1997/blockchair_downloader/logging"
+	"github.com/azd1997/blockchair_downloader/metrics"
 	"github.com/azd1997/blockchair_downloader/pool"
+	"github.com/azd1997/blockchair_downloader/progress"
 	"github.com/azd1997/blockchair_downloader/task"
 )
 
 const (
-	urlFormat = "https://gz.blockchair.com/bitcoin/inputs/blockchair_bitcoin_inputs_%s.tsv.gz"
+	urlFormat = "https://gz.blockchair.com/bitcoin/inputs/blockchair_bitcoin_inputs_%s.tsv.gz"	// genUrls2使用
+
+	// KeyEnv 没有-key参数时从该环境变量读取API key
+	KeyEnv = "BLOCKCHAIR_API_KEY"
+	// 没有API key时blockchair只允许单个连接，多开的连接会被限速甚至封禁
+	anonymousDownloaders = 1
+	anonymousTasks = 1
 )
 
 // 命令行格式：
-// blockchair [-n 20] [20210315][-20210320]
+// blockchair [-config FILE] [-profile NAME] [-key KEY] [-chain bitcoin] [-tables inputs,outputs] [-out ./download] [-n 20] [-j 3] [-schedule 00:00-08:00=50] [-sync [-report FILE]] [-metrics :9100] [-q] [20210315][-20210320]
 
 var (
 	nDownloaderFlag = flag.Int("n", 20, "指定使用最多n个下载器同时工作")
+	nTaskFlag = flag.Int("j", 3, "指定最多同时下载j个文件")
+	stateFlag = flag.String("state", "", "任务队列状态数据库路径，设置后进程重启时会续传未完成的任务")
+	quietFlag = flag.Bool("q", false, "安静模式，不显示进度和日志，只输出错误（适合cron）")
+	chainFlag = flag.String("chain", defaultDataset.chain, "链，逗号分隔可以指定多条，如 bitcoin,litecoin")
+	tablesFlag = flag.String("tables", defaultDataset.table, "表，逗号分隔，如 blocks,transactions；all表示链支持的所有表")
+	outFlag = flag.String("out", task.DownloadDir, "保存目录，文件按 链/表 保存在子目录中")
+	keyFlag = flag.String("key", "", "blockchair的API key，也可以用环境变量"+KeyEnv+"、GODL_KEY或配置文件的key设置；没有key时只用1个连接下载")
+	scheduleFlag = flag.String("schedule", "", "按时段调整下载器数，如 00:00-08:00=50,08:00-18:00=5，其余时段使用-n")
+	syncFlag = flag.Bool("sync", false, "增量同步：只下载本地缺失或与远程不一致的文件，跳过当天还在生成的文件；不指定日期时同步昨天（UTC）")
+	listFlag = flag.Bool("list", true, "先读取服务器的目录索引，跳过服务器上没有的文件，并用索引中的大小校验下载结果")
+	reportFlag = flag.String("report", "", "-sync的同步报告(JSON)路径，默认为-out下的"+syncReportName)
+	metricsFlag = flag.String("metrics", "", "在该地址的/metrics提供Prometheus指标，如 :9100")
+	clientOpts httpclient.Options	// -proxy、-insecure等HTTP客户端参数，见init
+	authFlags httpclient.AuthFlags	// -H、-cookies、-user等认证参数，见init
+	logFlags logging.Flags	// -log-level、-log-json，pool和task的日志，见init
+	configLoader config.Loader	// -config、-profile，配置文件中的设置作为没有指定的参数的值
+	configFlags = config.Flags{"downloaders": "n", "tasks": "j", "dir": "out", "key": "key", "schedule": "schedule"}
 )
 
+func init() {
+	clientOpts.RegisterFlags(flag.CommandLine)
+	authFlags.RegisterFlags(flag.CommandLine)
+	logFlags.RegisterFlags(flag.CommandLine)
+	configLoader.RegisterFlags(flag.CommandLine)
+}
+
 func main() {
 
 	var (
@@ -36,29 +76,99 @@ func main() {
 		urls []string
 		err error
 		i int
-		tasks []*task.Task
 		start, end time.Time
-		wg sync.WaitGroup
+		manager *task.Manager
+		result task.Result
+		renderer *progress.Renderer
+		schedule pool.Schedule
+		auth *httpclient.Auth
+		key string
+		numOfTask int
+		sets []dataset
+		entries []*syncEntry
+		pending map[string]*syncEntry
+		files map[string]listing.Entry
+		probe prober
+		mismatched int32
+		settings config.Settings
+		logger logging.Logger
 		)
 
 	flag.Parse()
 	if len(flag.Args()) != 1 && len(flag.Args()) != 0 {
 		goto ERR
 	}
+	// 环境变量中的API key与GODL_KEY一样优先于配置文件
+	if *keyFlag == "" && os.Getenv(KeyEnv) != "" {
+		flag.Set("key", os.Getenv(KeyEnv))
+	}
+	settings, err = configLoader.Load(flag.CommandLine, configFlags)
+	if err != nil {
+		fatal(err)
+	}
+
+	// 进度显示：安静模式下丢弃日志，只在出错时输出到stderr
+	if *quietFlag {
+		log.SetOutput(ioutil.Discard)
+	} else {
+		renderer = progress.New(os.Stdout)
+		log.SetOutput(renderer)
+	}
+	logger, err = logFlags.Logger(log.Writer())
+	if err != nil {
+		fatal(err)
+	}
+	pool.SetLogger(logger)
+	task.SetLogger(logger)
 
 	// 初始化下载器池
-	numOfCD = *nDownloaderFlag
-	err = pool.Init(numOfCD)
+	numOfCD, numOfTask = *nDownloaderFlag, *nTaskFlag
+	schedule, err = pool.ParseSchedule(*scheduleFlag)
 	if err != nil {
-		log.Fatalln(err)
+		fatal(err)
+	}
+	auth, err = authFlags.Auth()
+	if err != nil {
+		fatal(err)
+	}
+
+	// API key在发出请求时才加到url上，不会出现在文件名、日志和状态数据库中
+	key = *keyFlag
+	if key != "" {
+		if auth == nil {
+			auth = &httpclient.Auth{}
+		}
+		auth.Query = url.Values{"key": {key}}
+	} else {
+		log.Printf("没有API key，按匿名用户的限制只使用%d个连接下载（可用-key或%s设置）\n", anonymousDownloaders, KeyEnv)
+		numOfCD, numOfTask = anonymousDownloaders, anonymousTasks
+		schedule = nil
+	}
+	auth, err = settings.ApplyHosts(&clientOpts, auth)
+	if err != nil {
+		fatal(err)
+	}
+	err = pool.InitWithClient(numOfCD, clientOpts)
+	if err != nil {
+		fatal(err)
 	}
 	pool.Start()
 	defer pool.Stop()
-	fmt.Printf("最大允许下载器数量：%d\n", numOfCD)
+	if *metricsFlag != "" {
+		go func() {
+			fmt.Fprintln(os.Stderr, "-metrics:", metrics.ListenAndServe(*metricsFlag))
+		}()
+	}
+	log.Printf("最大允许下载器数量：%d\n", numOfCD)
+	if len(schedule) > 0 {
+		go pool.RunSchedule(schedule, numOfCD, nil)	// 随进程退出
+	}
 
 	// 解析时间
 	if len(flag.Args()) == 1 {
 		dateStr = flag.Arg(0)
+	} else if *syncFlag {
+		dateStr = timeToDate(time.Now().UTC().AddDate(0, 0, -1))	// 同步最近一个已生成完的
 	} else {	// ==0
 		dateStr = timeToDate(time.Now())	// 下载今天的
 	}
@@ -70,45 +180,153 @@ func main() {
 	} else {
 		goto ERR
 	}
-	fmt.Printf("下载日期范围：%s-%s\n", dateStart, dateEnd)
+	log.Printf("下载日期范围：%s-%s\n", dateStart, dateEnd)
 
 	// 检查dateStart, dateEnd格式
 	start, end, err = checkDateRange(dateStart, dateEnd)
 	if err != nil {
-		log.Fatalln(err)
+		fatal(err)
 	}
 
 	// 生成Url列表
-	urls = genUrls(start, end)
+	sets, err = parseDatasets(*chainFlag, *tablesFlag)
+	if err != nil {
+		fatal(err)
+	}
+	urls = genUrls(start, end, sets...)
 
-	// 根据url列表构建Task，并下载
-	wg.Add(len(urls))
-	tasks = make([]*task.Task, len(urls))
-	for i=0; i<len(urls); i++ {
-		go func(i int) {
-			tasks[i], err = task.NewTask(urls[i])
-			if err != nil {
-				log.Fatalln(err)
+	// 目录索引：读取失败时仍按日期生成的url下载
+	if *listFlag {
+		files, err = listFiles(sets, func(dir string) ([]listing.Entry, error) {
+			return listing.Fetch(pool.HTTPClient(), auth, dir)
+		})
+		if err != nil {
+			log.Printf("读取目录索引失败，按日期生成的url下载：%v\n", err)
+			files = nil
+		}
+	}
+
+	// 增量同步：先探测远程文件，只下载缺失或变化了的
+	if *syncFlag {
+		probe = probeWith(auth)
+		if files != nil {
+			probe = listProber(files, probe)	// 省去逐个文件的HEAD请求
+		}
+		entries = planSync(urls, *outFlag, timeToDate(time.Now().UTC()), probe)
+		pending = make(map[string]*syncEntry)
+		urls = urls[:0]
+		for _, e := range entries {
+			if e.Status == "" {
+				pending[e.Url] = e
+				urls = append(urls, e.Url)
+			} else {
+				log.Printf("同步：%s %s\n", e.Status, e.Url)
 			}
-			err = tasks[i].Start()
-			if err != nil {
-				log.Fatalln(err)
+		}
+	} else if files != nil {
+		found := urls[:0]
+		for _, u := range urls {
+			if _, ok := files[u]; ok {
+				found = append(found, u)
+			} else {
+				log.Printf("服务器上没有，跳过：%s\n", u)
 			}
-			wg.Done()
-		}(i)
+		}
+		urls = found
 	}
-	//tasks = tasks
 
-	wg.Wait()
+	// 根据url列表提交下载任务，由Manager控制同时下载的文件数
+	if *stateFlag != "" {
+		manager, err = task.NewPersistentManager(numOfTask, *stateFlag)
+		if err != nil {
+			fatal(err)
+		}
+		defer manager.Close()
+	} else {
+		manager = task.NewManager(numOfTask)
+	}
+	manager.SetAuth(auth)
+	manager.OnEvent(func(e task.Event) {
+		if e.Type != task.EventCompleted {
+			return
+		}
+		var err error
+		if f, ok := files[e.Url]; ok {
+			if err = checkSize(e.Task.FileName, f); err != nil {
+				atomic.AddInt32(&mismatched, 1)
+				fmt.Fprintf(os.Stderr, "校验失败：%v\n", err)
+			}
+		}
+		if entry := pending[e.Url]; entry != nil {
+			entry.finish(e.Task.FileName, err)
+		}
+	})
+	if renderer != nil {
+		manager.OnEvent(func(e task.Event) {
+			if e.Type == task.EventStarted {
+				renderer.Add(path.Base(e.Url), e.Task)
+			}
+		})
+		renderer.Start()
+	}
+	for i=0; i<len(urls); i++ {
+		// 跳过已在队列中的url（从状态数据库恢复的未失败任务）
+		if job, ok := manager.Lookup(urls[i]); !ok || job.State == task.JobFailed {
+			manager.Add(urls[i], task.Options{
+				Dir:       outputDir(*outFlag, urls[i]),
+				Overwrite: pending[urls[i]] != nil && pending[urls[i]].overwrite,
+			})
+		}
+	}
+
+	result = manager.Wait()
 
-	fmt.Println("下载完成！！！")
+	if renderer != nil {
+		renderer.Stop()
+	}
+	for _, job := range result.Jobs {
+		if job.State == task.JobFailed {
+			fmt.Fprintf(os.Stderr, "下载失败：%s: %v\n", job.Url, job.Err)
+			if entry := pending[job.Url]; entry != nil {
+				entry.finish("", job.Err)
+			}
+		}
+	}
+	result.Failed += int(atomic.LoadInt32(&mismatched))
+	if *syncFlag {
+		if *reportFlag == "" {
+			*reportFlag = filepath.Join(*outFlag, syncReportName)
+		}
+		err = writeReport(*reportFlag, &syncReport{Start: dateStart, End: dateEnd, Time: time.Now(), Entries: entries})
+		if err != nil {
+			fatal(err)
+		}
+		for _, e := range entries {
+			if e.Status == syncFailed && pending[e.Url] == nil {
+				result.Failed++	// 探测失败的也算失败
+				fmt.Fprintf(os.Stderr, "同步失败：%s: %s\n", e.Url, e.Reason)
+			}
+		}
+	}
+	if result.Failed > 0 {
+		os.Exit(1)
+	}
+	if renderer != nil {
+		fmt.Printf("下载完成！！！共%d个文件\n", result.Completed)
+	}
 	return
 
 ERR:
-	fmt.Println("确保命令行格式为：blockchair [-n 20] 20210315[-20210320]")
+	fmt.Println("确保命令行格式为：blockchair [-key KEY] [-chain bitcoin] [-tables inputs] [-out ./download] [-n 20] [-j 3] [-schedule 00:00-08:00=50] [-sync [-report FILE]] [-q] 20210315[-20210320]")
 	os.Exit(-1)
 }
 
+// fatal 输出错误并退出，安静模式下也会输出到stderr
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
 func checkDateRange(dateStart, dateEnd string) (start, end time.Time, err error) {
 	start, err = parseDate(dateStart)
 	if err != nil {
@@ -188,14 +406,27 @@ func timeToDate(t time.Time) string {
 	return string(slice)
 }
 
-func genUrls(start, end time.Time) []string {
-	fmt.Println("待下载Url列表：")
+// genUrls 生成start到end每一天的各个数据集的url，没有指定数据集时为bitcoin的inputs
+// 不按日期导出的表（addresses）只生成一个最新快照的url
+func genUrls(start, end time.Time, sets ...dataset) []string {
+	if len(sets) == 0 {
+		sets = []dataset{defaultDataset}
+	}
+	log.Println("待下载Url列表：")
 	urls := make([]string, 0)
-	for d:=start; !d.After(end); d=d.AddDate(0,0,1) {
-		date := timeToDate(d)
-		url := fmt.Sprintf(urlFormat, date)
-		urls = append(urls, url)
-		fmt.Println(url)
+	for _, set := range sets {
+		if !set.dated() {
+			urls = append(urls, set.url(latestDate))
+			log.Println(urls[len(urls)-1])
+		}
+	}
+	for _, date := range dates(start, end) {
+		for _, set := range sets {
+			if set.dated() {
+				urls = append(urls, set.url(date))
+				log.Println(urls[len(urls)-1])
+			}
+		}
 	}
 	return urls
 }
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -203,7 +204,7 @@ func (t *Task) downloadBlock(id int) error {
 		t.File.WriteAt(buf[:n], t.BlockList[id].Begin)
 
 		// 更新已下载大小
-		t.status.Downloaded += bufSize
+		atomic.AddInt64(&t.status.Downloaded, bufSize)
 		t.BlockList[id].Begin += bufSize
 
 		if e != nil {
@@ -214,29 +215,31 @@ func (t *Task) downloadBlock(id int) error {
 			return e
 		}
 	}
-
-	return nil
 }
 
 // startGetSpeeds 统计1s内下载数据总量的变化
 func (t *Task) startGetSpeeds() {
 	go func() {
-		var old = t.status.Downloaded
+		var old = atomic.LoadInt64(&t.status.Downloaded)
 		for {
 			if t.paused {
-				t.status.Speed = 0
+				atomic.StoreInt64(&t.status.Speed, 0)
 				return
 			}
 			time.Sleep(time.Second * 1)
-			t.status.Speed = t.status.Downloaded - old
-			old = t.status.Downloaded
+			cur := atomic.LoadInt64(&t.status.Downloaded)
+			atomic.StoreInt64(&t.status.Speed, cur-old)
+			old = cur
 		}
 	}()
 }
 
 // GetStatus 获取下载统计信息
 func (t *Task) GetStatus() Status {
-	return t.status
+	return Status{
+		Speed:      atomic.LoadInt64(&t.status.Speed),
+		Downloaded: atomic.LoadInt64(&t.status.Downloaded),
+	}
 }
 
 // Pause 暂停下载
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
+	"github.com/azd1997/blockchair_downloader/listing"
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+// 同步报告中每个文件的状态
+const (
+	syncDownloaded    = "downloaded"     // 本地缺失或与远程不一致，已重新下载
+	syncUpToDate      = "up_to_date"     // 本地文件与远程一致，跳过
+	syncSkippedToday  = "skipped_today"  // 当天（UTC）的文件还在生成中，等第二天再同步
+	syncMissingRemote = "missing_remote" // 远程没有这个文件（404）
+	syncFailed        = "failed"         // 探测或下载失败
+)
+
+// syncReportName -sync时默认的报告文件名，保存在-out下
+const syncReportName = "sync-report.json"
+
+// syncEntry 一个文件的同步结果
+type syncEntry struct {
+	Url     string    `json:"url"`
+	File    string    `json:"file"`
+	Status  string    `json:"status"`
+	Reason  string    `json:"reason,omitempty"`
+	Size    int64     `json:"size,omitempty"`     // 远程文件大小
+	ModTime time.Time `json:"mod_time,omitempty"` // 远程文件的Last-Modified
+
+	overwrite bool // 本地已有旧版本，下载时覆盖
+}
+
+// syncReport 写入-report的同步报告
+type syncReport struct {
+	Start   string         `json:"start"`
+	End     string         `json:"end"`
+	Time    time.Time      `json:"time"`
+	Counts  map[string]int `json:"counts"`
+	Entries []*syncEntry   `json:"entries"`
+}
+
+// prober 获取远程文件信息，便于测试时替换
+type prober func(url string) (task.Info, error)
+
+// planSync 对比本地与远程，确定需要下载的文件
+// today为UTC的当天日期，该日期及之后的文件还在生成中，直接跳过
+// 返回的entries中Status为空的是需要下载的
+func planSync(urls []string, out, today string, probe prober) []*syncEntry {
+	entries := make([]*syncEntry, 0, len(urls))
+	for _, u := range urls {
+		e := &syncEntry{Url: u, File: filepath.Join(outputDir(out, u), path.Base(u))}
+		entries = append(entries, e)
+
+		if date := urlDate(u); date != latestDate && date >= today {
+			e.Status = syncSkippedToday
+			continue
+		}
+		info, err := probe(u)
+		if err != nil {
+			var se *task.StatusError
+			if errors.As(err, &se) && se.Code == http.StatusNotFound {
+				e.Status = syncMissingRemote
+			} else {
+				e.Status, e.Reason = syncFailed, err.Error()
+			}
+			continue
+		}
+		if info.Size >= 0 {
+			e.Size = info.Size
+		}
+		e.ModTime = info.ModTime
+
+		fi, err := os.Stat(e.File)
+		if err != nil {
+			continue // 本地没有，下载
+		}
+		if upToDate(fi, info) {
+			e.Status = syncUpToDate
+		} else {
+			e.overwrite = true
+		}
+	}
+	return entries
+}
+
+// upToDate 本地文件大小与远程相同，且不早于远程的修改时间
+// 远程没有给出大小或时间时只比较另一项
+func upToDate(fi os.FileInfo, info task.Info) bool {
+	if info.Size >= 0 && fi.Size() != info.Size {
+		return false
+	}
+	return info.ModTime.IsZero() || !fi.ModTime().Before(info.ModTime)
+}
+
+// urlDate 从文件名中取出日期，如 blockchair_bitcoin_inputs_20210315.tsv.gz 为 20210315
+func urlDate(u string) string {
+	name := strings.TrimSuffix(path.Base(u), ".tsv.gz")
+	return name[strings.LastIndex(name, "_")+1:]
+}
+
+// probeWith 用cdp的客户端和auth探测
+func probeWith(auth *httpclient.Auth) prober {
+	return func(url string) (task.Info, error) {
+		return task.Probe(url, auth)
+	}
+}
+
+// listProber 用目录索引中的大小和修改时间代替HEAD请求，索引中没有的文件按404处理
+// 索引中没有精确大小或时间的，仍用next探测
+func listProber(files map[string]listing.Entry, next prober) prober {
+	return func(url string) (task.Info, error) {
+		e, ok := files[url]
+		if !ok {
+			return task.Info{}, &task.StatusError{Url: url, Code: http.StatusNotFound, Status: "404 Not Found (not in listing)"}
+		}
+		if !e.Exact() || e.ModTime.IsZero() {
+			return next(url)
+		}
+		return task.Info{Size: e.Size, ModTime: e.ModTime}, nil
+	}
+}
+
+// finish 记录下载结果：成功的文件修改时间设为远程的Last-Modified，下次同步时据此判断是否变化
+func (e *syncEntry) finish(fileName string, err error) {
+	if err != nil {
+		e.Status, e.Reason = syncFailed, err.Error()
+		return
+	}
+	e.Status, e.File = syncDownloaded, fileName
+	if !e.ModTime.IsZero() {
+		os.Chtimes(fileName, time.Now(), e.ModTime)
+	}
+}
+
+// writeReport 统计各状态的数量并写入JSON报告
+func writeReport(file string, r *syncReport) error {
+	r.Counts = make(map[string]int)
+	for _, e := range r.Entries {
+		r.Counts[e.Status]++
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(data, '\n'), 0644)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/azd1997/blockchair_downloader/listing"
+	"github.com/azd1997/blockchair_downloader/task"
+)
+
+func TestUrlDate(t *testing.T) {
+	cases := map[string]string{
+		defaultDataset.url("20210315"):                       "20210315",
+		dataset{"ethereum", "erc-20/tokens"}.url("20210315"): "20210315",
+		dataset{"bitcoin", "addresses"}.url("20210315"):      latestDate,
+	}
+	for u, want := range cases {
+		if got := urlDate(u); got != want {
+			t.Errorf("urlDate(%s) = %s, want %s", u, got, want)
+		}
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	out := t.TempDir()
+	modTime := time.Date(2021, 3, 16, 1, 0, 0, 0, time.UTC)
+	sets := []dataset{defaultDataset, {"bitcoin", "addresses"}}
+	urls := genUrls(time.Date(2021, 3, 13, 0, 0, 0, 0, time.Local), time.Date(2021, 3, 17, 0, 0, 0, 0, time.Local), sets...)
+
+	// 本地：13号一致，14号大小不同，15号比远程旧，16号缺失，17号为当天
+	local := func(date string, size int, mtime time.Time) {
+		u := defaultDataset.url(date)
+		file := filepath.Join(outputDir(out, u), filepath.Base(u))
+		os.MkdirAll(filepath.Dir(file), 0777)
+		if err := ioutil.WriteFile(file, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(file, mtime, mtime)
+	}
+	local("20210313", 10, modTime)
+	local("20210314", 5, modTime)
+	local("20210315", 10, modTime.Add(-time.Hour))
+
+	probed := map[string]bool{}
+	probe := func(u string) (task.Info, error) {
+		probed[u] = true
+		switch urlDate(u) {
+		case latestDate:
+			return task.Info{}, &task.StatusError{Url: u, Code: 404, Status: "404 Not Found"}
+		case "20210316":
+			return task.Info{}, errors.New("timeout")
+		}
+		return task.Info{Size: 10, ModTime: modTime}, nil
+	}
+	entries := planSync(urls, out, "20210317", probe)
+
+	want := map[string]struct {
+		status    string
+		overwrite bool
+	}{
+		dataset{"bitcoin", "addresses"}.url(""): {syncMissingRemote, false},
+		defaultDataset.url("20210313"):          {syncUpToDate, false},
+		defaultDataset.url("20210314"):          {"", true},
+		defaultDataset.url("20210315"):          {"", true},
+		defaultDataset.url("20210316"):          {syncFailed, false},
+		defaultDataset.url("20210317"):          {syncSkippedToday, false},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	for _, e := range entries {
+		w := want[e.Url]
+		if e.Status != w.status || e.overwrite != w.overwrite {
+			t.Errorf("%s: status=%q overwrite=%v", e.Url, e.Status, e.overwrite)
+		}
+	}
+	if probed[defaultDataset.url("20210317")] {
+		t.Error("today's file should not be probed")
+	}
+
+	// 下载完成后修改时间设为远程的，再次同步时为一致
+	e := entries[2]
+	e.finish(e.File, nil)
+	if fi, err := os.Stat(e.File); err != nil || !fi.ModTime().Equal(modTime) {
+		t.Fatalf("mtime not synced: %v %v", fi, err)
+	}
+	local("20210314", 10, modTime)
+	if again := planSync([]string{e.Url}, out, "20210317", probe); again[0].Status != syncUpToDate {
+		t.Fatalf("second sync: %q", again[0].Status)
+	}
+
+	report := filepath.Join(out, syncReportName)
+	if err := writeReport(report, &syncReport{Start: "20210313", End: "20210317", Entries: entries}); err != nil {
+		t.Fatal(err)
+	}
+	var r syncReport
+	data, _ := ioutil.ReadFile(report)
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Counts[syncUpToDate] != 1 || r.Counts[syncDownloaded] != 1 || r.Counts[syncSkippedToday] != 1 {
+		t.Fatalf("counts = %v", r.Counts)
+	}
+}
+
+func TestListProber(t *testing.T) {
+	modTime := time.Date(2021, 3, 16, 1, 2, 0, 0, time.UTC)
+	exact, approx := defaultDataset.url("20210315"), defaultDataset.url("20210316")
+	files := map[string]listing.Entry{
+		exact:  {Size: 10, ModTime: modTime},
+		approx: {Size: 10 << 20, Approx: true, ModTime: modTime},
+	}
+	var headed []string
+	probe := listProber(files, func(u string) (task.Info, error) {
+		headed = append(headed, u)
+		return task.Info{Size: 1}, nil
+	})
+
+	if info, err := probe(exact); err != nil || info.Size != 10 || !info.ModTime.Equal(modTime) {
+		t.Fatalf("exact: %+v %v", info, err)
+	}
+	if info, _ := probe(approx); info.Size != 1 {
+		t.Fatalf("approx size should fall back to HEAD: %+v", info)
+	}
+	var se *task.StatusError
+	if _, err := probe(defaultDataset.url("20210317")); !errors.As(err, &se) || se.Code != 404 {
+		t.Fatalf("missing: %v", err)
+	}
+	if len(headed) != 1 || headed[0] != approx {
+		t.Fatalf("headed = %v", headed)
+	}
+}
@@ -1,12 +1,39 @@
 package main
 
 import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+
+	"github.com/azd1997/blockchair_downloader/httpclient"
 	"github.com/azd1997/blockchair_downloader/pool"
+	"github.com/azd1997/blockchair_downloader/progress"
 	"github.com/azd1997/blockchair_downloader/task"
 )
 
+var (
+	quietFlag  = flag.Bool("q", false, "安静模式，不显示进度和日志")
+	clientOpts httpclient.Options
+)
+
+func init() {
+	clientOpts.RegisterFlags(flag.CommandLine)
+}
+
 func main() {
-	err := pool.Init(10)
+	flag.Parse()
+
+	var renderer *progress.Renderer
+	if *quietFlag {
+		log.SetOutput(ioutil.Discard)
+	} else {
+		renderer = progress.New(os.Stdout)
+		log.SetOutput(renderer)
+	}
+
+	err := pool.InitWithClient(10, clientOpts)
 	if err != nil {
 		panic(err)
 	}
@@ -18,6 +45,11 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	if renderer != nil {
+		renderer.Add(path.Base(url), t)
+		renderer.Start()
+		defer renderer.Stop()
+	}
 	err = t.Start()
 	if err != nil {
 		panic(err)